@@ -0,0 +1,99 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	abd "github.com/GoogleCloudPlatform/k8s-addon-builder/pkg"
+	"github.com/spf13/cobra"
+)
+
+var AttestProvenanceCmd = &cobra.Command{
+	Use:   "provenance <IMAGE>",
+	Short: "generate a SLSA provenance statement for an image in its registry",
+	Long: `Generate an in-toto statement with a SLSA v1.0 provenance predicate for IMAGE,
+as found in its registry (the statement is about the digest IMAGE resolves
+to).
+
+The source is the commit checked out in --repo (as cloned by 'ply git clone')
+and its origin remote; the builder is this version of ply. Build parameters
+given with --param are recorded as external parameters, and $BUILD_ID (set by
+Cloud Build) as the invocation ID. With --push, the statement is also pushed
+next to the image as an OCI referrer of it.`,
+	Args: cobra.ExactArgs(1),
+	RunE: attestProvenance,
+}
+
+var ProvenanceRepoDir string
+var ProvenanceParams map[string]string
+var ProvenanceOutput string
+var ProvenancePush bool
+
+func init() {
+	AttestCmd.AddCommand(AttestProvenanceCmd)
+	AttestProvenanceCmd.Flags().StringVar(&ProvenanceRepoDir, "repo", ".", "git clone of the source the image was built from")
+	AttestProvenanceCmd.Flags().StringToStringVar(&ProvenanceParams, "param", nil, "build parameter to record, as KEY=VALUE (can be repeated)")
+	AttestProvenanceCmd.Flags().StringVarP(&ProvenanceOutput, "output", "o", "provenance.json", "file to write the statement to")
+	AttestProvenanceCmd.Flags().BoolVar(&ProvenancePush, "push", false, "also push the statement to the registry as a referrer of the image")
+}
+
+func attestProvenance(cmd *cobra.Command, args []string) error {
+	named, ref, err := abd.ParseImageReference(args[0])
+	if err != nil {
+		return err
+	}
+	source, err := abd.ReadGitSource(ProvenanceRepoDir)
+	if err != nil {
+		return err
+	}
+
+	rcli := abd.NewRegistryClient()
+	desc, err := rcli.HeadManifest(named, ref)
+	if err != nil {
+		return err
+	}
+	if desc == nil {
+		return fmt.Errorf("image %v not found", abd.ImageString(named, ref))
+	}
+
+	statement, err := abd.ProvenanceStatement(abd.ProvenanceInfo{
+		Image:          named.Name(),
+		Digest:         desc.Digest,
+		Source:         source,
+		BuilderVersion: map[string]string{"ply": VersionGit, "date": VersionDate},
+		Parameters:     ProvenanceParams,
+		InvocationID:   os.Getenv("BUILD_ID"),
+	})
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(ProvenanceOutput, statement, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote provenance of %v@%v to %v\n", named.Name(), desc.Digest, ProvenanceOutput)
+
+	if ProvenancePush {
+		annotations := map[string]string{abd.AnnotationPredicateType: abd.SLSAProvenanceType}
+		referrer, err := rcli.PushReferrer(named, *desc, abd.MediaTypeInToto, statement, annotations)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Pushed provenance to %v\n", abd.ImageString(named, referrer.Digest))
+	}
+	return nil
+}
@@ -15,6 +15,9 @@
 package cmd
 
 import (
+	"fmt"
+
+	abd "github.com/GoogleCloudPlatform/k8s-addon-builder/pkg"
 	"github.com/spf13/cobra"
 )
 
@@ -26,6 +29,45 @@ var DockerRegexCmd = &cobra.Command{
 	},
 }
 
+var Platform string
+
+// FromManifestsDir selects, instead of a REGEX argument, the images referenced
+// by the Kubernetes manifests of a directory.
+var FromManifestsDir string
+
 func init() {
 	PlyCmd.AddCommand(DockerRegexCmd)
+	DockerRegexCmd.PersistentFlags().StringVar(&Platform, "platform", "", "only act on images built for this platform (os/arch[/variant], e.g. linux/arm64)")
+}
+
+func addFromManifestsFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVar(&FromManifestsDir, "from-manifests", "", "act on the images referenced by the Kubernetes manifests (and kustomize image overrides) of this directory instead of a REGEX")
+}
+
+// withManifestsRegex returns the arguments of a command taking a REGEX and
+// n-1 other arguments. With --from-manifests, the REGEX must be omitted: the
+// regex matching the images referenced by the manifests is put in its place.
+func withManifestsRegex(args []string, n int) ([]string, error) {
+	if FromManifestsDir == "" {
+		if len(args) != n {
+			return nil, fmt.Errorf("accepts %d arg(s), received %d", n, len(args))
+		}
+		return args, nil
+	}
+	if len(args) != n-1 {
+		return nil, fmt.Errorf("REGEX cannot be given with --from-manifests")
+	}
+
+	regex, names, skipped, err := abd.ManifestImagesRegex(FromManifestsDir)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Images referenced by the manifests in %v:\n", FromManifestsDir)
+	for _, name := range names {
+		fmt.Printf("  - %v\n", name)
+	}
+	for _, image := range skipped {
+		fmt.Printf("Skipping %v (referenced by digest only)\n", image)
+	}
+	return append([]string{regex}, args...), nil
 }
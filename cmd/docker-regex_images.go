@@ -51,6 +51,10 @@ func listImages(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	images, err = images.FilterPlatform(Platform)
+	if err != nil {
+		return err
+	}
 
 	if len(images) == 0 {
 		fmt.Printf("No images match regex %v\n", regex)
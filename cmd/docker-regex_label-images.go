@@ -16,6 +16,7 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 	"strings"
 
 	abd "github.com/GoogleCloudPlatform/k8s-addon-builder/pkg"
@@ -30,11 +31,19 @@ var DockerRegexLabelImagesCmd = &cobra.Command{
 }
 
 var Labels []string
+var SourceDateEpoch string
 
 func init() {
 	DockerRegexCmd.AddCommand(DockerRegexLabelImagesCmd)
 	DockerRegexLabelImagesCmd.Flags().StringSliceVarP(&Labels, "label", "l", nil, "label to append into an image (can be specified multiple times; required)")
 	DockerRegexLabelImagesCmd.MarkFlagRequired("label")
+	addSourceDateEpochFlag(DockerRegexLabelImagesCmd)
+}
+
+// addSourceDateEpochFlag registers the --source-date-epoch flag on a command
+// that produces new images.
+func addSourceDateEpochFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&SourceDateEpoch, "source-date-epoch", os.Getenv("SOURCE_DATE_EPOCH"), "pin the created timestamps of produced images to this many seconds since the Unix epoch, for reproducible image IDs (defaults to $SOURCE_DATE_EPOCH)")
 }
 
 func labelImages(cmd *cobra.Command, args []string) error {
@@ -43,6 +52,11 @@ func labelImages(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	created, err := abd.ParseSourceDateEpoch(SourceDateEpoch)
+	if err != nil {
+		return err
+	}
+
 	dcli, err := client.NewClientWithOpts(client.FromEnv)
 	if err != nil {
 		return err
@@ -52,6 +66,10 @@ func labelImages(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	found, err = found.FilterPlatform(Platform)
+	if err != nil {
+		return err
+	}
 
 	fmt.Println("Labels to add:")
 	fmt.Println(Labels)
@@ -79,7 +97,14 @@ func labelImages(cmd *cobra.Command, args []string) error {
 		dockerfileContents := "FROM " + image
 		fmt.Println(dockerfileContents)
 		tags := []string{image}
-		abd.BuildImage(dcli, []byte(dockerfileContents), labels, tags)
+		if err := abd.BuildImage(dcli, []byte(dockerfileContents), labels, tags); err != nil {
+			return err
+		}
+		if created != nil {
+			if err := abd.PinCreated(dcli, tags, *created); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
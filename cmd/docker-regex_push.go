@@ -16,7 +16,9 @@ package cmd
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os/exec"
+	"strings"
 
 	abd "github.com/GoogleCloudPlatform/k8s-addon-builder/pkg"
 	"github.com/docker/docker/client"
@@ -24,17 +26,39 @@ import (
 )
 
 var DockerRegexPushCmd = &cobra.Command{
-	Use:  "push <REGEX>",
-	Args: cobra.ExactArgs(1),
+	Use:   "push [REGEX]",
+	Short: "push the images matching a regex",
+	Long: `Push the local images whose names match REGEX.
+
+With --results, the pushed images are written to a file, one
+NAME:TAG=NAME:TAG@sha256:... line per image, which 'ply manifests rewrite
+--mapping-file' reads to pin the manifests to exactly what was pushed. The
+other names of each pushed image, such as the ones it had before
+'set-path-prefix' or 'tag-suffix append', map to it as well, so manifests
+referring to the images by their source names are rewritten too.`,
+	Args: cobra.RangeArgs(0, 1),
 	RunE: pushWrapper,
 }
 
+var PushPolicyFile string
+var PushResultsFile string
+
 func init() {
 	DockerRegexCmd.AddCommand(DockerRegexPushCmd)
+	addFromManifestsFlag(DockerRegexPushCmd)
+	DockerRegexPushCmd.Flags().StringVar(&PushPolicyFile, "policy", "", "YAML policy file the images must comply with (see 'ply policy check')")
+	DockerRegexPushCmd.Flags().StringVar(&PushResultsFile, "results", "", "file to write the pushed images to, as OLD=NEW image mappings")
 }
 
 func pushWrapper(cmd *cobra.Command, args []string) error {
+	args, err := withManifestsRegex(args, 1)
+	if err != nil {
+		return err
+	}
 	r, err := abd.MakeRegex(args[0])
+	if err != nil {
+		return err
+	}
 
 	dcli, err := client.NewClientWithOpts(client.FromEnv)
 	if err != nil {
@@ -44,20 +68,35 @@ func pushWrapper(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	found, err = found.FilterPlatform(Platform)
+	if err != nil {
+		return err
+	}
 
-	return pushImages(found)
+	if PushPolicyFile != "" {
+		policy, err := abd.LoadPolicy(PushPolicyFile)
+		if err != nil {
+			return err
+		}
+		if err := abd.ShowPolicyReport(found, policy.CheckImages(found)); err != nil {
+			return err
+		}
+	}
+
+	return pushImages(dcli, found)
 }
 
-func pushImages(images abd.ImageMap) error {
+func pushImages(dcli *client.Client, images abd.ImageMap) error {
 	if len(images) == 0 {
 		fmt.Println("No images to push")
-		return nil
+		return writePushResults(dcli, images)
 	}
 
 	fmt.Println("Images to push:")
 	images.ShowPretty()
 
-	for k, _ := range images {
+	names := images.SortedNames()
+	for _, k := range names {
 		cmd := exec.Command("docker", "push", k)
 		cmdOut, err := cmd.Output()
 		if err != nil {
@@ -66,5 +105,33 @@ func pushImages(images abd.ImageMap) error {
 		fmt.Println(string(cmdOut))
 
 	}
+	return writePushResults(dcli, images)
+}
+
+// writePushResults writes the digest each image was pushed with to
+// PushResultsFile, as image mappings from the tag (and the other names of the
+// image) to the pinned tag.
+func writePushResults(dcli *client.Client, images abd.ImageMap) error {
+	if PushResultsFile == "" {
+		return nil
+	}
+	// The daemon records the digests of pushed images in their RepoDigests.
+	resolver := abd.NewDigestResolver(dcli, abd.NewRegistryClient())
+	pinned := make(map[string]string)
+	for _, name := range images.SortedNames() {
+		p, err := resolver.Pin(name)
+		if err != nil {
+			return fmt.Errorf("could not get the digest of %v: %v", name, err)
+		}
+		pinned[name] = p
+	}
+	var results strings.Builder
+	for _, mapping := range abd.PushResultMappings(images, pinned) {
+		fmt.Fprintln(&results, mapping)
+	}
+	if err := ioutil.WriteFile(PushResultsFile, []byte(results.String()), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %v\n", PushResultsFile)
 	return nil
 }
@@ -26,16 +26,21 @@ import (
 )
 
 var DockerRegexSetPathPrefixCmd = &cobra.Command{
-	Use:  "set-path-prefix <REGEX> <PATH_PREFIX>",
-	Args: cobra.ExactArgs(2),
+	Use:  "set-path-prefix [REGEX] <PATH_PREFIX>",
+	Args: cobra.RangeArgs(1, 2),
 	RunE: setRegistryWrapper,
 }
 
 func init() {
 	DockerRegexCmd.AddCommand(DockerRegexSetPathPrefixCmd)
+	addFromManifestsFlag(DockerRegexSetPathPrefixCmd)
 }
 
 func setRegistryWrapper(cmd *cobra.Command, args []string) error {
+	args, err := withManifestsRegex(args, 2)
+	if err != nil {
+		return err
+	}
 	regex := args[0]
 	pathPrefix := args[1]
 	if regex == "" {
@@ -57,6 +62,10 @@ func setRegistryWrapper(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	found, err = found.FilterPlatform(Platform)
+	if err != nil {
+		return err
+	}
 
 	return setPathPrefix(dcli, found, pathPrefix)
 }
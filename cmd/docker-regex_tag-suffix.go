@@ -27,4 +27,5 @@ var DockerRegexTagSuffixCmd = &cobra.Command{
 
 func init() {
 	DockerRegexCmd.AddCommand(DockerRegexTagSuffixCmd)
+	addFromManifestsFlag(DockerRegexTagSuffixCmd)
 }
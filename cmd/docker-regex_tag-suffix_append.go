@@ -21,8 +21,8 @@ import (
 )
 
 var DockerRegexTagSuffixAppendCmd = &cobra.Command{
-	Use:  "append <REGEX> <TAG_SUFFIX>",
-	Args: cobra.ExactArgs(2),
+	Use:  "append [REGEX] <TAG_SUFFIX>",
+	Args: cobra.RangeArgs(1, 2),
 	RunE: appendTagSuffixWrapper,
 }
 
@@ -31,5 +31,9 @@ func init() {
 }
 
 func appendTagSuffixWrapper(cmd *cobra.Command, args []string) error {
-	return abd.EditTagSuffixWrapper(cmd, args, true)
+	args, err := withManifestsRegex(args, 2)
+	if err != nil {
+		return err
+	}
+	return abd.EditTagSuffixWrapper(cmd, args, true, Platform)
 }
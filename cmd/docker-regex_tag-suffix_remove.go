@@ -21,8 +21,8 @@ import (
 )
 
 var DockerRegexTagSuffixRemoveCmd = &cobra.Command{
-	Use:  "remove <REGEX> <TAG_SUFFIX>",
-	Args: cobra.ExactArgs(2),
+	Use:  "remove [REGEX] <TAG_SUFFIX>",
+	Args: cobra.RangeArgs(1, 2),
 	RunE: removeTagSuffixWrapper,
 }
 
@@ -31,5 +31,9 @@ func init() {
 }
 
 func removeTagSuffixWrapper(cmd *cobra.Command, args []string) error {
-	return abd.EditTagSuffixWrapper(cmd, args, false)
+	args, err := withManifestsRegex(args, 2)
+	if err != nil {
+		return err
+	}
+	return abd.EditTagSuffixWrapper(cmd, args, false, Platform)
 }
@@ -0,0 +1,116 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	abd "github.com/GoogleCloudPlatform/k8s-addon-builder/pkg"
+	"github.com/spf13/cobra"
+)
+
+var GCBGenerateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "generate a cloudbuild.yaml from a project file (ply.yaml)",
+	Long: `Generate a Cloud Build config running the pipeline of a project file (see
+'ply run'): one step per configured pipeline step, each running 'ply run STEP'
+in the addon-builder image after the previous one.
+
+Every step gets BUILD_ID and PROJECT_ID in its environment, along with the
+other variables the project file refers to. Variables that are not Cloud
+Build substitutions become user-defined substitutions (VERSION is passed as
+${_VERSION}), to be set with 'gcloud builds submit --substitutions
+_VERSION=...' or in the build trigger. They default to empty, or to the value
+given with --substitutions _VERSION=... (the environment is not read, so that
+no local value ends up in the config).`,
+	Args: cobra.NoArgs,
+	RunE: generateCloudBuild,
+}
+
+var GCBBuilderImage string
+var GCBOutput string
+
+func init() {
+	GCBCmd.AddCommand(GCBGenerateCmd)
+	GCBGenerateCmd.Flags().StringVarP(&ProjectFilePath, "file", "f", abd.ProjectFile, "project file")
+	GCBGenerateCmd.Flags().StringVar(&GCBBuilderImage, "builder-image", abd.DefaultBuilderImage, "image the steps run in")
+	GCBGenerateCmd.Flags().StringVarP(&GCBOutput, "output", "o", "", "file to write the config to (defaults to stdout)")
+	GCBGenerateCmd.Flags().StringSliceVar(&GCBSubstitutions, "substitutions", nil, "default values of the substitutions, _NAME=VALUE (repeatable)")
+}
+
+func generateCloudBuild(cmd *cobra.Command, args []string) error {
+	project, err := abd.LoadProject(ProjectFilePath)
+	if err != nil {
+		return err
+	}
+	vars, err := abd.ProjectVariables(ProjectFilePath)
+	if err != nil {
+		return err
+	}
+
+	defaults, err := substitutionDefaults(GCBSubstitutions, vars)
+	if err != nil {
+		return err
+	}
+
+	build := abd.GenerateCloudBuild(project, ProjectFilePath, vars, GCBBuilderImage, defaults)
+	if len(build.Steps) == 0 {
+		return fmt.Errorf("no steps are configured in %v", ProjectFilePath)
+	}
+	raw, err := build.Marshal()
+	if err != nil {
+		return err
+	}
+	if GCBOutput == "" {
+		_, err = os.Stdout.Write(raw)
+		return err
+	}
+	if err := ioutil.WriteFile(GCBOutput, raw, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %v steps to %v\n", len(build.Steps), GCBOutput)
+	return nil
+}
+
+// substitutionDefaults parses _NAME=VALUE pairs (or NAME=VALUE) into the
+// default values of the substitutions of the variables of a project file, by
+// variable name.
+func substitutionDefaults(pairs []string, vars []string) (map[string]string, error) {
+	known := make(map[string]bool)
+	for _, name := range vars {
+		if !abd.CloudBuildSubstitutions[name] {
+			known[name] = true
+		}
+	}
+	defaults := make(map[string]string)
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid substitution %q (must be NAME=VALUE)", pair)
+		}
+		name := kv[0]
+		if !known[name] && known[strings.TrimPrefix(name, "_")] {
+			name = strings.TrimPrefix(name, "_")
+		}
+		if !known[name] {
+			return nil, fmt.Errorf("invalid substitution %q: %v is not a user-defined variable of %v", pair, kv[0], ProjectFilePath)
+		}
+		defaults[name] = kv[1]
+	}
+	return defaults, nil
+}
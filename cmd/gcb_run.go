@@ -0,0 +1,110 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	abd "github.com/GoogleCloudPlatform/k8s-addon-builder/pkg"
+	"github.com/docker/docker/client"
+	"github.com/spf13/cobra"
+)
+
+var GCBRunCmd = &cobra.Command{
+	Use:   "run <CLOUDBUILD_YAML>",
+	Short: "run the steps of a cloudbuild.yaml locally",
+	Long: `Run the steps of a Cloud Build config locally, each in a container of its
+image started through the Docker daemon, to reproduce a build without
+submitting it.
+
+As in Cloud Build, the workspace directory is mounted on /workspace (changes
+made by the steps are kept), the Docker socket is mounted so that steps can
+use Docker, and steps run in parallel as their waitFor allows. Steps get
+BUILD_ID and PROJECT_ID in their environment. Substitutions are replaced in
+the name, entrypoint, args, env and dir of the steps: the built-in ones
+(PROJECT_ID, BUILD_ID, and COMMIT_SHA, SHORT_SHA and REVISION_ID if the
+workspace is a git repository), the substitutions of the config and those
+given with --substitutions.
+
+The workspace must be a path the Docker daemon can mount, i.e. a path on the
+daemon's host.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runCloudBuild,
+}
+
+var (
+	GCBWorkspace     string
+	GCBProjectID     string
+	GCBBuildID       string
+	GCBSubstitutions []string
+)
+
+func init() {
+	GCBCmd.AddCommand(GCBRunCmd)
+	GCBRunCmd.Flags().StringVar(&GCBWorkspace, "workspace", ".", "directory mounted on /workspace")
+	GCBRunCmd.Flags().StringVar(&GCBProjectID, "project-id", os.Getenv("PROJECT_ID"), "value of PROJECT_ID (defaults to $PROJECT_ID)")
+	GCBRunCmd.Flags().StringVar(&GCBBuildID, "build-id", "", "value of BUILD_ID (defaults to a random UUID)")
+	GCBRunCmd.Flags().StringSliceVar(&GCBSubstitutions, "substitutions", nil, "substitutions _NAME=VALUE (repeatable)")
+}
+
+func runCloudBuild(cmd *cobra.Command, args []string) error {
+	build, err := abd.LoadCloudBuild(args[0])
+	if err != nil {
+		return err
+	}
+	workspace, err := filepath.Abs(GCBWorkspace)
+	if err != nil {
+		return err
+	}
+	buildID := GCBBuildID
+	if buildID == "" {
+		if buildID, err = abd.NewBuildID(); err != nil {
+			return err
+		}
+	}
+
+	values := abd.BuiltinSubstitutions(workspace, GCBProjectID, buildID)
+	for _, pair := range GCBSubstitutions {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return fmt.Errorf("invalid substitution %q (must be NAME=VALUE)", pair)
+		}
+		values[kv[0]] = kv[1]
+	}
+	build, err = build.Substitute(values)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Running %v steps of %v in %v\n", len(build.Steps), args[0], workspace)
+	fmt.Println("Substitutions:")
+	for _, pair := range abd.SortedSubstitutions(build.Substitutions) {
+		fmt.Printf("  %v\n", pair)
+	}
+
+	dcli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return err
+	}
+	env := []string{"BUILD_ID=" + buildID, "PROJECT_ID=" + GCBProjectID}
+	if err := abd.NewCloudBuildRunner(dcli, workspace, env).Run(build); err != nil {
+		return err
+	}
+	fmt.Println("Build succeeded")
+	return nil
+}
@@ -15,96 +15,91 @@
 package cmd
 
 import (
-	"fmt"
-	"os/exec"
-	"regexp"
-	"strings"
+	"os"
 
+	abd "github.com/GoogleCloudPlatform/k8s-addon-builder/pkg"
 	"github.com/spf13/cobra"
-	git "gopkg.in/src-d/go-git.v4"
-	"gopkg.in/src-d/go-git.v4/plumbing"
 )
 
 var GitCloneCmd = &cobra.Command{
-	Use:  "clone <REPO_URL>",
+	Use:   "clone <REPO_URL>",
+	Short: "Clone a git repository and check out a revision",
+	Long: `Clone a git repository and check out a revision.
+
+--rev takes a branch, a tag, a full or abbreviated commit hash, or one of
+these followed by ~N, ^N or ^{}, e.g. "v1.2.3^{}" or "main~2". Branches that
+only exist on the remote are checked out from origin/<branch>.
+
+Large repositories can be cloned faster with a truncated history (--depth),
+a single branch (--single-branch), without file contents until they are
+needed (--filter=blob:none, which requires the git binary), or with only some
+directories checked out (--sparse). --rev works with shallow clones: the
+branch, tag or commit it starts from is fetched if it is not in the clone.
+
+Repositories are cloned with go-git, so no git binary is needed except for
+--filter. Credentials are taken from, in order:
+
+  - SSH remotes: --ssh-key (with the passphrase in $GIT_SSH_KEY_PASSWORD,
+    which --filter does not support), the SSH agent, then
+    ~/.ssh/id_ed25519, id_ecdsa or id_rsa.
+  - HTTP remotes: $GIT_TOKEN (with $GIT_USERNAME if set) or
+    $GIT_USERNAME/$GIT_PASSWORD, for the hosts listed in $GIT_TOKEN_HOST
+    (e.g. "github.com,git.example.com:8443"), then ~/.netrc (or $NETRC),
+    then the git credential helpers, e.g. "credential.helper gcloud.sh" in
+    /etc/gitconfig.
+
+HTTP remotes are accessed anonymously first, and only get credentials if they
+refuse (including by reporting the repository as not found), except for the
+hosts of $GIT_TOKEN_HOST, which get them from the start. Passwords are never
+sent over plain http, except to localhost.`,
 	Args: cobra.ExactArgs(1),
 	RunE: cloneAndCheckout,
 }
 
 var Dir string
 var Rev string
+var CloneDepth int
+var CloneSingleBranch bool
+var CloneFilter string
+var CloneSparse []string
+var CloneSSHKey string
+var CloneCredentialHelper string
 
 func init() {
 	GitCmd.AddCommand(GitCloneCmd)
 	GitCloneCmd.Flags().StringVarP(&Dir, "dir", "d", "", "directory to clone into (by default uses the name of the git repo)")
-	GitCloneCmd.Flags().StringVarP(&Rev, "rev", "r", "", "revision to check out after the clone (defaults to master branch)")
+	GitCloneCmd.Flags().StringVarP(&Rev, "rev", "r", "", "revision to check out after the clone: a branch, tag, full or abbreviated commit hash, optionally followed by ~N, ^N or ^{} (defaults to the remote HEAD)")
+	GitCloneCmd.Flags().IntVar(&CloneDepth, "depth", 0, "truncate the history to this many commits")
+	GitCloneCmd.Flags().BoolVar(&CloneSingleBranch, "single-branch", false, "only fetch the branch that is checked out")
+	GitCloneCmd.Flags().StringVar(&CloneFilter, "filter", "", "partial clone filter, e.g. blob:none (uses the git binary)")
+	GitCloneCmd.Flags().StringSliceVar(&CloneSparse, "sparse", nil, "only check out these directories (repeatable or comma-separated)")
+	GitCloneCmd.Flags().StringVar(&CloneSSHKey, "ssh-key", os.Getenv("GIT_SSH_KEY"), "private key file for SSH remotes (defaults to $GIT_SSH_KEY)")
+	GitCloneCmd.Flags().StringVar(&CloneCredentialHelper, "credential-helper", "", "git credential helper for HTTP remotes (defaults to credential.helper of the git config)")
 }
 
-func cloneAndCheckout(cmd *cobra.Command, args []string) error {
-	repoUrl := args[0]
-	var ecmd *exec.Cmd
-	var path string
-	if len(Dir) > 0 {
-		path = Dir
-	} else {
-		path = getRepoName(repoUrl)
-	}
-	ecmd = exec.Command("git", "clone", repoUrl, path)
-
-	if err := ecmd.Run(); err != nil {
-		return err
+func cloneOptions() abd.GitCloneOptions {
+	return abd.GitCloneOptions{
+		Rev:          Rev,
+		Depth:        CloneDepth,
+		SingleBranch: CloneSingleBranch,
+		Filter:       CloneFilter,
+		SparsePaths:  CloneSparse,
+		Auth: abd.GitAuthOptions{
+			SSHKey:           CloneSSHKey,
+			CredentialHelper: CloneCredentialHelper,
+		},
 	}
-
-	err := checkout(cmd, path)
-	if err != nil {
-		return err
-	}
-
-	return nil
 }
 
-func checkout(cmd *cobra.Command, path string) error {
-	repo, err := git.PlainOpen(path)
-	if err != nil {
-		return err
-	}
-
-	if len(Rev) > 0 {
-		worktree, err := repo.Worktree()
-		if err != nil {
-			return err
-		}
-		var checkoutOptions git.CheckoutOptions
-
-		if isHash(Rev) {
-			fmt.Println("checking out hash:", Rev)
-			checkoutOptions = git.CheckoutOptions{Hash: plumbing.NewHash(Rev)}
-		} else {
-			fmt.Println("checking out branch:", Rev)
-			checkoutOptions = git.CheckoutOptions{Branch: plumbing.ReferenceName("refs/remotes/origin/" + Rev)}
-		}
-		err = worktree.Checkout(&checkoutOptions)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func isHash(rev string) bool {
-	r, err := regexp.Compile("^[a-fA-F0-9]{40}$")
-	if err != nil {
-		return false
+func cloneAndCheckout(cmd *cobra.Command, args []string) error {
+	repoUrl := args[0]
+	path := Dir
+	if len(path) == 0 {
+		path = abd.RepoName(repoUrl)
 	}
-	return r.MatchString(rev)
+	return abd.CloneRepository(repoUrl, path, cloneOptions())
 }
 
-// Get the repository name; drop the last ".git" suffix if found.
-func getRepoName(repoUrl string) string {
-	parts := strings.Split(repoUrl, "/")
-	last := parts[len(parts)-1]
-	if strings.HasSuffix(last, ".git") {
-		return last[:len(last)-4]
-	}
-	return last
+func checkout(cmd *cobra.Command, path string) error {
+	return abd.CheckoutRevision(path, cloneOptions())
 }
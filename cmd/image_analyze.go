@@ -0,0 +1,111 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	abd "github.com/GoogleCloudPlatform/k8s-addon-builder/pkg"
+	"github.com/docker/docker/client"
+	units "github.com/docker/go-units"
+	"github.com/spf13/cobra"
+)
+
+var ImageAnalyzeCmd = &cobra.Command{
+	Use:   "analyze <REGEX>",
+	Short: "report the layer sizes and wasted bytes of images matching a regex",
+	Long: `Report, for each local image matching a regex, the size of every layer with
+the instruction that created it, the largest files, and the bytes wasted by
+files that a later layer overwrites or deletes. The efficiency score is the
+share of the bytes of the image that are visible in its final filesystem.
+
+With --max-size, the command fails if an image is larger than the budget
+(e.g. "250MB"), so that it can guard image size in CI.`,
+	Args: cobra.ExactArgs(1),
+	RunE: analyzeImages,
+}
+
+var (
+	AnalyzeTop     int
+	AnalyzeMaxSize string
+)
+
+func init() {
+	ImageCmd.AddCommand(ImageAnalyzeCmd)
+	ImageAnalyzeCmd.Flags().IntVar(&AnalyzeTop, "top", 10, "number of largest and wasted files to list")
+	ImageAnalyzeCmd.Flags().StringVar(&AnalyzeMaxSize, "max-size", "", "fail if an image is larger than this size (e.g. 250MB)")
+}
+
+func analyzeImages(cmd *cobra.Command, args []string) error {
+	r, err := abd.MakeRegex(args[0])
+	if err != nil {
+		return err
+	}
+	var maxSize int64
+	if AnalyzeMaxSize != "" {
+		if maxSize, err = units.FromHumanSize(AnalyzeMaxSize); err != nil {
+			return fmt.Errorf("invalid --max-size: %v", err)
+		}
+	}
+
+	dcli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return err
+	}
+	found, err := abd.FindImages(dcli, r)
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		fmt.Printf("No images match regex %v\n", args[0])
+		return nil
+	}
+
+	archive, err := abd.SaveImages(dcli, found.SortedNames())
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	// Images with several names are analyzed once.
+	analyses := make(map[string]*abd.ImageAnalysis)
+	overBudget := make([]string, 0)
+	for _, name := range found.SortedNames() {
+		m, ok := archive.Find(name)
+		if !ok {
+			return fmt.Errorf("image %v not found in the exported images", name)
+		}
+		analysis, ok := analyses[m.Config]
+		if !ok {
+			if analysis, err = archive.AnalyzeImage(m, AnalyzeTop); err != nil {
+				return err
+			}
+			analyses[m.Config] = analysis
+		}
+
+		fmt.Printf("%v:\n", name)
+		analysis.Print(os.Stdout)
+		if maxSize > 0 && analysis.Size > maxSize {
+			fmt.Printf("  Size exceeds the budget of %v\n", units.HumanSize(float64(maxSize)))
+			overBudget = append(overBudget, name)
+		}
+	}
+
+	if len(overBudget) > 0 {
+		return fmt.Errorf("%v of %v images exceed the size budget of %v", len(overBudget), len(found), units.HumanSize(float64(maxSize)))
+	}
+	return nil
+}
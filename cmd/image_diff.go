@@ -0,0 +1,74 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	abd "github.com/GoogleCloudPlatform/k8s-addon-builder/pkg"
+	"github.com/docker/docker/client"
+	"github.com/spf13/cobra"
+)
+
+var ImageDiffCmd = &cobra.Command{
+	Use:   "diff <IMAGE_A> <IMAGE_B>",
+	Short: "compare the config, layers and files of two local images",
+	Long: `Compare two images of the local daemon: every setting of their config
+(environment, labels, entrypoint, command, user, working directory, exposed
+ports, volumes, healthcheck, ...) and their layers. Layers are compared in
+order, and the first one that differs is reported: the images share none of
+the layers after it.
+
+Images can be named by tag, digest or image ID. With --files, the images are
+exported to also list the files that were added, removed or modified. Only the
+layers the images do not share are read.`,
+	Args: cobra.ExactArgs(2),
+	RunE: diffImages,
+}
+
+var (
+	ImageDiffFiles bool
+	ImageDiffJSON  bool
+)
+
+func init() {
+	ImageCmd.AddCommand(ImageDiffCmd)
+	ImageDiffCmd.Flags().BoolVar(&ImageDiffFiles, "files", false, "also compare the files of the images")
+	ImageDiffCmd.Flags().BoolVar(&ImageDiffJSON, "json", false, "print the differences as JSON")
+}
+
+func diffImages(cmd *cobra.Command, args []string) error {
+	dcli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return err
+	}
+	diff, err := abd.DiffImages(dcli, args[0], args[1], ImageDiffFiles)
+	if err != nil {
+		return err
+	}
+
+	if ImageDiffJSON {
+		raw, err := json.MarshalIndent(diff, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(raw))
+		return nil
+	}
+	diff.Print(os.Stdout)
+	return nil
+}
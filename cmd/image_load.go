@@ -0,0 +1,56 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"runtime"
+
+	abd "github.com/GoogleCloudPlatform/k8s-addon-builder/pkg"
+	"github.com/docker/docker/client"
+	"github.com/spf13/cobra"
+)
+
+var ImageLoadCmd = &cobra.Command{
+	Use:   "load <DIR>",
+	Short: "load the images of an OCI image layout into the docker daemon",
+	Args:  cobra.ExactArgs(1),
+	RunE:  loadImages,
+}
+
+var LoadPlatform string
+
+func init() {
+	ImageCmd.AddCommand(ImageLoadCmd)
+	ImageLoadCmd.Flags().StringVar(&LoadPlatform, "platform", runtime.GOOS+"/"+runtime.GOARCH, "platform to load from multi-platform images (os/arch[/variant])")
+}
+
+func loadImages(cmd *cobra.Command, args []string) error {
+	platform, err := abd.ParsePlatform(LoadPlatform)
+	if err != nil {
+		return err
+	}
+
+	archive, err := abd.ReadOCILayout(args[0], platform)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	dcli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return err
+	}
+	return archive.Load(dcli)
+}
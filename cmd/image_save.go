@@ -0,0 +1,67 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	abd "github.com/GoogleCloudPlatform/k8s-addon-builder/pkg"
+	"github.com/docker/docker/client"
+	"github.com/spf13/cobra"
+)
+
+var ImageSaveCmd = &cobra.Command{
+	Use:   "save <REGEX>",
+	Short: "export images matching a regex into an OCI image layout",
+	Args:  cobra.ExactArgs(1),
+	RunE:  saveImages,
+}
+
+var OCILayoutDir string
+
+func init() {
+	ImageCmd.AddCommand(ImageSaveCmd)
+	ImageSaveCmd.Flags().StringVar(&OCILayoutDir, "oci-layout", "", "directory of the OCI image layout to write (required)")
+	ImageSaveCmd.MarkFlagRequired("oci-layout")
+}
+
+func saveImages(cmd *cobra.Command, args []string) error {
+	r, err := abd.MakeRegex(args[0])
+	if err != nil {
+		return err
+	}
+
+	dcli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return err
+	}
+	found, err := abd.FindImages(dcli, r)
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		fmt.Printf("No images match regex %v\n", args[0])
+		return nil
+	}
+
+	archive, err := abd.SaveImages(dcli, found.SortedNames())
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	fmt.Printf("Images saved to %v:\n", OCILayoutDir)
+	return archive.WriteOCILayout(OCILayoutDir)
+}
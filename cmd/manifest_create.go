@@ -0,0 +1,86 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	abd "github.com/GoogleCloudPlatform/k8s-addon-builder/pkg"
+	"github.com/docker/docker/client"
+	"github.com/spf13/cobra"
+)
+
+var ManifestCreateCmd = &cobra.Command{
+	Use:   "create <TARGET>",
+	Short: "assemble already-pushed single-platform images into a manifest list and push it",
+	Long: `Assemble the local images matching --from into a manifest list (or OCI image
+index) named TARGET and push it.
+
+The platform of each image is read from its config. The images must already
+have been pushed (e.g. with "docker-regex push"); images from other
+repositories are copied into the repository of TARGET.`,
+	Args: cobra.ExactArgs(1),
+	RunE: createManifestList,
+}
+
+var ManifestFrom string
+var ManifestOCI bool
+
+func init() {
+	ManifestCmd.AddCommand(ManifestCreateCmd)
+	ManifestCreateCmd.Flags().StringVar(&ManifestFrom, "from", "", "regex matching the single-platform images to include (required)")
+	ManifestCreateCmd.Flags().BoolVar(&ManifestOCI, "oci", false, "create an OCI image index instead of a Docker manifest list")
+	ManifestCreateCmd.MarkFlagRequired("from")
+}
+
+func createManifestList(cmd *cobra.Command, args []string) error {
+	target, tag, err := abd.ParseImageReference(args[0])
+	if err != nil {
+		return err
+	}
+	if abd.IsDigest(tag) {
+		return fmt.Errorf("TARGET must be tagged, not pinned to a digest")
+	}
+	r, err := abd.MakeRegex(ManifestFrom)
+	if err != nil {
+		return err
+	}
+
+	dcli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return err
+	}
+	found, err := abd.FindImages(dcli, r)
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		return fmt.Errorf("no images match regex %v", ManifestFrom)
+	}
+
+	images := make([]abd.PlatformImage, 0)
+	for _, name := range found.SortedNames() {
+		platform := found[name].Platform
+		fmt.Printf("  - %v (%v)\n", name, platform)
+		images = append(images, abd.PlatformImage{Name: name, Platform: platform})
+	}
+
+	desc, err := abd.NewRegistryClient().CreateIndex(target, tag, images, ManifestOCI)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("pushed %v (%v)\n", abd.ImageString(target, tag), desc.Digest)
+	return nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	abd "github.com/GoogleCloudPlatform/k8s-addon-builder/pkg"
+	"github.com/spf13/cobra"
+)
+
+var ManifestInspectCmd = &cobra.Command{
+	Use:   "inspect <IMAGE>",
+	Short: "show the platforms of an image in its registry",
+	Args:  cobra.ExactArgs(1),
+	RunE:  inspectManifest,
+}
+
+func init() {
+	ManifestCmd.AddCommand(ManifestInspectCmd)
+}
+
+func inspectManifest(cmd *cobra.Command, args []string) error {
+	named, ref, err := abd.ParseImageReference(args[0])
+	if err != nil {
+		return err
+	}
+
+	desc, platforms, err := abd.NewRegistryClient().Platforms(named, ref)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%v\n", abd.ImageString(named, ref))
+	fmt.Printf("  MediaType: %v\n", desc.MediaType)
+	fmt.Printf("  Digest:    %v\n", desc.Digest)
+	fmt.Println("Platforms:")
+	for _, p := range platforms {
+		platform := "unknown"
+		if p.Platform != nil {
+			platform = p.Platform.String()
+		}
+		fmt.Printf("  - %v %v\n", platform, p.Digest)
+	}
+	return nil
+}
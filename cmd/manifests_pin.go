@@ -0,0 +1,96 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	abd "github.com/GoogleCloudPlatform/k8s-addon-builder/pkg"
+	"github.com/docker/docker/client"
+	"github.com/spf13/cobra"
+)
+
+var ManifestsPinCmd = &cobra.Command{
+	Use:   "pin",
+	Short: "pin the images of Kubernetes manifests to digests",
+	Long: `Replace the image references of the Kubernetes manifests of a directory
+with name:tag@sha256:... references. Digests come from the RepoDigests of the
+local images when they have one for the repository, and from the registry
+otherwise. References already pinned are updated if their tag has moved.
+
+With --check, nothing is written: the command fails if a reference is not
+pinned, or is pinned to a digest its tag no longer resolves to.`,
+	Args: cobra.NoArgs,
+	RunE: pinManifests,
+}
+
+var PinCheck bool
+
+func init() {
+	ManifestsCmd.AddCommand(ManifestsPinCmd)
+	ManifestsPinCmd.Flags().StringVar(&ManifestsDir, "dir", "", "directory of the YAML manifests (required)")
+	ManifestsPinCmd.Flags().BoolVar(&PinCheck, "check", false, "only check that the references are pinned to the current digests")
+	ManifestsPinCmd.Flags().BoolVar(&ManifestsDryRun, "dry-run", false, "only print the changes")
+	ManifestsPinCmd.MarkFlagRequired("dir")
+}
+
+func pinManifests(cmd *cobra.Command, args []string) error {
+	refs, err := abd.FindKubeImagesInDir(ManifestsDir)
+	if err != nil {
+		return err
+	}
+
+	dcli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return err
+	}
+	resolver := abd.NewDigestResolver(dcli, abd.NewRegistryClient())
+	pins := make(map[string]string)
+	for _, ref := range refs {
+		if _, ok := pins[ref.Image]; ok {
+			continue
+		}
+		pinned, err := resolver.Pin(ref.Image)
+		if err != nil {
+			return fmt.Errorf("%v: %v", ref, err)
+		}
+		pins[ref.Image] = pinned
+	}
+
+	if !PinCheck {
+		return editManifests(ManifestsDir, func(ref abd.KubeImageRef) (string, bool) {
+			pinned, ok := pins[ref.Image]
+			return pinned, ok
+		})
+	}
+
+	bad := 0
+	for _, ref := range refs {
+		pinned := pins[ref.Image]
+		if pinned == ref.Image {
+			continue
+		}
+		if bad == 0 {
+			fmt.Println("Image references not pinned to their current digest:")
+		}
+		bad++
+		fmt.Printf("  - %v: %v (want %v)\n", ref, ref.Image, pinned)
+	}
+	if bad > 0 {
+		return fmt.Errorf("%v of %v image references are unpinned or mismatched", bad, len(refs))
+	}
+	fmt.Printf("All %v image references are pinned\n", len(refs))
+	return nil
+}
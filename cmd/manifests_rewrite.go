@@ -0,0 +1,128 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	abd "github.com/GoogleCloudPlatform/k8s-addon-builder/pkg"
+	"github.com/spf13/cobra"
+)
+
+var ManifestsRewriteCmd = &cobra.Command{
+	Use:   "rewrite",
+	Short: "rewrite the image references of Kubernetes manifests",
+	Long: `Rewrite the images of the containers (including init and ephemeral
+containers) of the Pods, Deployments, DaemonSets, StatefulSets, ReplicaSets,
+Jobs and CronJobs found in the YAML files of a directory. Only the image
+references are replaced, so formatting and comments are preserved.
+
+Mappings are OLD=NEW pairs, given with --mapping or one per line in a
+--mapping-file. A mapping without a tag applies to every tag of the
+repository and keeps the tag, e.g. after 'ply docker-regex set-path-prefix':
+
+  ply manifests rewrite --dir manifests/ --mapping gcr.io/old/foo=gcr.io/new/foo
+
+The results file of 'ply docker-regex push --results' is a mapping file that
+pins the pushed images to the digests they were pushed with. It maps the names
+the images had before they were renamed too, so manifests can keep referring to
+the source images:
+
+  ply docker-regex set-path-prefix '^gcr.io/old/' gcr.io/new
+  ply docker-regex push '^gcr.io/new/' --results pushed.txt
+  ply manifests rewrite --dir manifests/ --mapping-file pushed.txt`,
+	Args: cobra.NoArgs,
+	RunE: rewriteManifests,
+}
+
+var (
+	ManifestsDir       string
+	ManifestsDryRun    bool
+	RewriteMappings    []string
+	RewriteMappingFile string
+)
+
+func init() {
+	ManifestsCmd.AddCommand(ManifestsRewriteCmd)
+	ManifestsRewriteCmd.Flags().StringVar(&ManifestsDir, "dir", "", "directory of the YAML manifests (required)")
+	ManifestsRewriteCmd.Flags().StringSliceVar(&RewriteMappings, "mapping", nil, "image mapping OLD=NEW (repeatable)")
+	ManifestsRewriteCmd.Flags().StringVar(&RewriteMappingFile, "mapping-file", "", "file of image mappings, one OLD=NEW per line")
+	ManifestsRewriteCmd.Flags().BoolVar(&ManifestsDryRun, "dry-run", false, "only print the changes")
+	ManifestsRewriteCmd.MarkFlagRequired("dir")
+}
+
+func rewriteManifests(cmd *cobra.Command, args []string) error {
+	mapping, err := abd.ParseImageMapping(RewriteMappings)
+	if err != nil {
+		return err
+	}
+	if RewriteMappingFile != "" {
+		if err := mapping.ReadImageMappingFile(RewriteMappingFile); err != nil {
+			return err
+		}
+	}
+	if mapping.Len() == 0 {
+		return fmt.Errorf("no image mappings given (use --mapping or --mapping-file)")
+	}
+
+	return editManifests(ManifestsDir, func(ref abd.KubeImageRef) (string, bool) {
+		return mapping.Map(ref.Image)
+	})
+}
+
+// editManifests rewrites the image references of the manifests of a
+// directory, and prints the changes.
+func editManifests(dir string, fn func(ref abd.KubeImageRef) (string, bool)) error {
+	files, err := abd.FindKubeManifests(dir)
+	if err != nil {
+		return err
+	}
+	count := 0
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		rewritten, changes, err := abd.RewriteKubeImages(file, content, fn)
+		if err != nil {
+			return err
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		for _, c := range changes {
+			fmt.Printf("%v: %v -> %v\n", c.KubeImageRef, c.Image, c.New)
+		}
+		count += len(changes)
+		if ManifestsDryRun {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(file, rewritten, info.Mode()); err != nil {
+			return err
+		}
+	}
+	if ManifestsDryRun {
+		fmt.Printf("%v image references would be rewritten in %v\n", count, dir)
+	} else {
+		fmt.Printf("%v image references rewritten in %v\n", count, dir)
+	}
+	return nil
+}
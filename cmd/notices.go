@@ -0,0 +1,109 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	abd "github.com/GoogleCloudPlatform/k8s-addon-builder/pkg"
+	"github.com/spf13/cobra"
+)
+
+var NoticesCmd = &cobra.Command{
+	Use:   "notices",
+	Short: "collect the licenses of a Go module and the modules it depends on",
+	Long: `Collect the license and notice files of a Go module and of its dependencies
+into a NOTICES file, along with a JSON report of the license of each module.
+
+Dependencies are read from vendor/ if the module is vendored, and from the
+module cache otherwise (run 'go mod download' first). Licenses are classified
+by SPDX ID; the command fails if any module has a disallowed license, after
+writing both files. Modules without a recognizable license are reported as
+UNKNOWN.`,
+	Args: cobra.NoArgs,
+	RunE: collectNotices,
+}
+
+var NoticesModuleDir string
+var NoticesOutput string
+var NoticesReport string
+var NoticesDisallowed []string
+
+func init() {
+	PlyCmd.AddCommand(NoticesCmd)
+	NoticesCmd.Flags().StringVar(&NoticesModuleDir, "module-dir", ".", "directory of the Go module (holding go.mod)")
+	NoticesCmd.Flags().StringVarP(&NoticesOutput, "output", "o", "NOTICES.txt", "file to write the license texts to")
+	NoticesCmd.Flags().StringVar(&NoticesReport, "report", "licenses.json", "file to write the license report to")
+	NoticesCmd.Flags().StringSliceVar(&NoticesDisallowed, "disallow",
+		[]string{"AGPL-3.0", "GPL-2.0", "GPL-3.0", "LGPL-2.1", "LGPL-3.0", abd.LicenseUnknown},
+		"licenses (SPDX IDs) that fail the command")
+}
+
+func collectNotices(cmd *cobra.Command, args []string) error {
+	modules, err := abd.ResolveModules(NoticesModuleDir)
+	if err != nil {
+		return err
+	}
+	notices, err := abd.CollectNotices(modules)
+	if err != nil {
+		return err
+	}
+
+	disallowed := make(map[string]bool)
+	for _, license := range NoticesDisallowed {
+		disallowed[license] = true
+	}
+	violations := make([]string, 0)
+	fmt.Println("Modules found:")
+	for _, n := range notices {
+		licenses := n.Licenses()
+		fmt.Printf("  - %v: %v\n", n.GoModule, strings.Join(licenses, ", "))
+		// A module is fine if any of its licenses is allowed (i.e. it is
+		// dual-licensed).
+		ok := false
+		for _, license := range licenses {
+			if !disallowed[license] {
+				ok = true
+			}
+		}
+		if !ok {
+			violations = append(violations, fmt.Sprintf("%v (%v)", n.GoModule, strings.Join(licenses, ", ")))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := abd.WriteNotices(&buf, notices); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(NoticesOutput, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	report, err := abd.LicenseReport(notices)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(NoticesReport, report, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %v and %v\n", NoticesOutput, NoticesReport)
+
+	if len(violations) > 0 {
+		return fmt.Errorf("modules with disallowed licenses:\n  %v", strings.Join(violations, "\n  "))
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	abd "github.com/GoogleCloudPlatform/k8s-addon-builder/pkg"
+	"github.com/docker/docker/client"
+	"github.com/spf13/cobra"
+)
+
+var PolicyCheckCmd = &cobra.Command{
+	Use:   "check <REGEX>",
+	Short: "check the images matching a regex against a policy",
+	Long: `Check the local images matching a regex against the rules of a YAML policy
+file, and report the violations of each image. The same check can be run
+before pushing with 'ply docker-regex push --policy'.
+
+Policy file example:
+
+  requiredLabels: [org.opencontainers.image.source]
+  forbidLatest: true
+  allowedRegistries: [gcr.io/my-project/]
+  maxSize: 500MB
+  requireNonRoot: true
+  allowedBaseDigests: ["sha256:..."]   # from the org.opencontainers.image.base.digest label`,
+	Args: cobra.ExactArgs(1),
+	RunE: checkPolicy,
+}
+
+var PolicyFile string
+
+func init() {
+	PolicyCmd.AddCommand(PolicyCheckCmd)
+	PolicyCheckCmd.Flags().StringVar(&PolicyFile, "policy", "", "YAML policy file (required)")
+	PolicyCheckCmd.MarkFlagRequired("policy")
+}
+
+func checkPolicy(cmd *cobra.Command, args []string) error {
+	r, err := abd.MakeRegex(args[0])
+	if err != nil {
+		return err
+	}
+	policy, err := abd.LoadPolicy(PolicyFile)
+	if err != nil {
+		return err
+	}
+
+	dcli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return err
+	}
+	found, err := abd.FindImages(dcli, r)
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		fmt.Printf("No images match regex %v\n", args[0])
+		return nil
+	}
+
+	return abd.ShowPolicyReport(found, policy.CheckImages(found))
+}
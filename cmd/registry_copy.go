@@ -0,0 +1,100 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	abd "github.com/GoogleCloudPlatform/k8s-addon-builder/pkg"
+	"github.com/docker/distribution/reference"
+	"github.com/spf13/cobra"
+)
+
+var RegistryCopyCmd = &cobra.Command{
+	Use:   "copy <SRC> <DST>",
+	Short: "copy images between registries without a docker daemon",
+	Long: `Copy the image SRC to DST, registry to registry, preserving digests.
+
+If DST has no tag or digest, the tag (or digest) of SRC is kept. With --regex,
+SRC and DST are repositories and every tag of SRC matching the regex is copied
+to DST under the same name.`,
+	Args: cobra.ExactArgs(2),
+	RunE: copyRegistryImages,
+}
+
+var CopyRegex string
+
+func init() {
+	RegistryCmd.AddCommand(RegistryCopyCmd)
+	RegistryCopyCmd.Flags().StringVar(&CopyRegex, "regex", "", "copy every tag of SRC matching this regex")
+}
+
+func copyRegistryImages(cmd *cobra.Command, args []string) error {
+	rcli := abd.NewRegistryClient()
+
+	if CopyRegex != "" {
+		r, err := abd.MakeRegex(CopyRegex)
+		if err != nil {
+			return err
+		}
+		src, err := parseRepository(args[0])
+		if err != nil {
+			return err
+		}
+		dst, err := parseRepository(args[1])
+		if err != nil {
+			return err
+		}
+		copied, err := rcli.CopyTags(src, dst, r)
+		if err != nil {
+			return err
+		}
+		if len(copied) == 0 {
+			fmt.Printf("No tags of %v match regex %v\n", reference.FamiliarString(src), CopyRegex)
+		}
+		return nil
+	}
+
+	src, srcRef, err := abd.ParseImageReference(args[0])
+	if err != nil {
+		return err
+	}
+	dst, dstRef, err := abd.ParseImageReference(args[1])
+	if err != nil {
+		return err
+	}
+	if !hasTagOrDigest(args[1]) {
+		dstRef = srcRef
+	}
+	_, err = rcli.Copy(src, srcRef, dst, dstRef)
+	return err
+}
+
+// parseRepository parses an image name that must not carry a tag or digest.
+func parseRepository(name string) (reference.Named, error) {
+	named, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return nil, err
+	}
+	if !reference.IsNameOnly(named) {
+		return nil, fmt.Errorf("%v must be a repository without a tag or digest", name)
+	}
+	return named, nil
+}
+
+func hasTagOrDigest(name string) bool {
+	named, err := reference.ParseNormalizedNamed(name)
+	return err == nil && !reference.IsNameOnly(named)
+}
@@ -0,0 +1,49 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+
+	abd "github.com/GoogleCloudPlatform/k8s-addon-builder/pkg"
+	"github.com/spf13/cobra"
+)
+
+var RegistryServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "serve a local, unauthenticated registry backed by a directory (for debugging)",
+	Args:  cobra.NoArgs,
+	RunE:  serveRegistry,
+}
+
+var ServeDir string
+var ServeAddr string
+
+func init() {
+	RegistryCmd.AddCommand(RegistryServeCmd)
+	RegistryServeCmd.Flags().StringVarP(&ServeDir, "dir", "d", "", "directory to store images in (required)")
+	RegistryServeCmd.Flags().StringVar(&ServeAddr, "addr", "localhost:5000", "address to listen on")
+	RegistryServeCmd.MarkFlagRequired("dir")
+}
+
+func serveRegistry(cmd *cobra.Command, args []string) error {
+	server, err := abd.NewRegistryServer(ServeDir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Serving registry from %v on %v\n", ServeDir, ServeAddr)
+	return http.ListenAndServe(ServeAddr, server)
+}
@@ -0,0 +1,50 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	abd "github.com/GoogleCloudPlatform/k8s-addon-builder/pkg"
+	"github.com/spf13/cobra"
+)
+
+var RegistryTagSuffixCmd = &cobra.Command{
+	Use:   "tag-suffix <REPO> <REGEX> <TAG_SUFFIX>",
+	Short: "append a suffix to the tags of a repository matching a regex, in the registry",
+	Args:  cobra.ExactArgs(3),
+	RunE:  appendRegistryTagSuffix,
+}
+
+func init() {
+	RegistryCmd.AddCommand(RegistryTagSuffixCmd)
+}
+
+func appendRegistryTagSuffix(cmd *cobra.Command, args []string) error {
+	named, err := parseRepository(args[0])
+	if err != nil {
+		return err
+	}
+	r, err := abd.MakeRegex(args[1])
+	if err != nil {
+		return err
+	}
+	tagSuffix := args[2]
+	if tagSuffix == "" {
+		return fmt.Errorf("TAG_SUFFIX cannot be empty")
+	}
+
+	return abd.NewRegistryClient().AppendTagSuffix(named, r, tagSuffix)
+}
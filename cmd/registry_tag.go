@@ -0,0 +1,41 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	abd "github.com/GoogleCloudPlatform/k8s-addon-builder/pkg"
+	"github.com/spf13/cobra"
+)
+
+var RegistryTagCmd = &cobra.Command{
+	Use:   "tag <IMAGE> <NEW_TAG>",
+	Short: "add a tag to an image in its registry, without pulling it",
+	Args:  cobra.ExactArgs(2),
+	RunE:  tagRegistryImage,
+}
+
+func init() {
+	RegistryCmd.AddCommand(RegistryTagCmd)
+}
+
+func tagRegistryImage(cmd *cobra.Command, args []string) error {
+	named, ref, err := abd.ParseImageReference(args[0])
+	if err != nil {
+		return err
+	}
+	// The new tag is validated before anything is fetched.
+	_, err = abd.NewRegistryClient().Tag(named, ref, args[1])
+	return err
+}
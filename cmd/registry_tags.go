@@ -0,0 +1,68 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	abd "github.com/GoogleCloudPlatform/k8s-addon-builder/pkg"
+	"github.com/docker/distribution/reference"
+	"github.com/spf13/cobra"
+)
+
+var RegistryTagsCmd = &cobra.Command{
+	Use:  "tags <IMAGE>",
+	Args: cobra.ExactArgs(1),
+	RunE: listRegistryTags,
+}
+
+var TagRegex string
+
+func init() {
+	RegistryCmd.AddCommand(RegistryTagsCmd)
+	RegistryTagsCmd.Flags().StringVar(&TagRegex, "regex", "", "only list tags matching this regex")
+}
+
+func listRegistryTags(cmd *cobra.Command, args []string) error {
+	named, err := reference.ParseNormalizedNamed(args[0])
+	if err != nil {
+		return err
+	}
+	named = reference.TrimNamed(named)
+
+	rcli := abd.NewRegistryClient()
+	tags, err := rcli.Tags(named)
+	if err != nil {
+		return err
+	}
+	if TagRegex != "" {
+		r, err := abd.MakeRegex(TagRegex)
+		if err != nil {
+			return err
+		}
+		tags = abd.FilterTags(tags, r)
+	}
+
+	if len(tags) == 0 {
+		fmt.Printf("No tags found for %v\n", reference.FamiliarString(named))
+		return nil
+	}
+
+	fmt.Println("Tags found:")
+	for _, tag := range tags {
+		fmt.Printf("  - %v:%v\n", reference.FamiliarString(named), tag)
+	}
+	return nil
+}
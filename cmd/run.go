@@ -0,0 +1,210 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	abd "github.com/GoogleCloudPlatform/k8s-addon-builder/pkg"
+	"github.com/docker/docker/client"
+	"github.com/spf13/cobra"
+)
+
+var RunCmd = &cobra.Command{
+	Use:   "run [STEP]",
+	Short: "run the release pipeline of a project file (ply.yaml)",
+	Long: `Run the steps of the release pipeline described by a project file, in order:
+
+  clone            clone source.repo at source.rev into source.dir ('ply git clone')
+  build            run build.command in source.dir
+  label            add labels to the images ('ply docker-regex label-images')
+  tag-suffix       append tagSuffix to their tags ('ply docker-regex tag-suffix append')
+  set-path-prefix  move them under pathPrefix ('ply docker-regex set-path-prefix')
+  push             push them, checking push.policy ('ply docker-regex push'),
+                   then copy them to push.registries ('ply registry copy')
+
+The images pushed are those matching push.images, which defaults to the images
+under pathPrefix with tagSuffix (or to images without pathPrefix). With a
+pathPrefix but no tagSuffix, push.images must be set.
+
+Steps that are not configured are skipped. With STEP, only that step is run.
+Environment variables ($VAR or ${VAR}) in the values of the file are
+substituted, and must be set; $$ is a literal $. Example:
+
+  source:
+    repo: https://github.com/org/addon
+    rev: ${ADDON_VERSION}
+  build:
+    command: make images
+  images: ^addon-
+  labels:
+    org.opencontainers.image.source: https://github.com/org/addon
+  tagSuffix: gke.${BUILD_NUMBER}
+  pathPrefix: gcr.io/${PROJECT_ID}/addons
+  push:
+    enabled: true
+    policy: policy.yaml
+    registries: [us-docker.pkg.dev/${PROJECT_ID}/addons]`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runProject,
+}
+
+var ProjectFilePath string
+
+func init() {
+	PlyCmd.AddCommand(RunCmd)
+	RunCmd.Flags().StringVarP(&ProjectFilePath, "file", "f", abd.ProjectFile, "project file")
+}
+
+func runProject(cmd *cobra.Command, args []string) error {
+	project, err := abd.LoadProject(ProjectFilePath)
+	if err != nil {
+		return err
+	}
+
+	steps := abd.ProjectSteps
+	if len(args) == 1 {
+		if !isProjectStep(args[0]) {
+			return fmt.Errorf("unknown step %q (must be one of %v)", args[0], strings.Join(abd.ProjectSteps, ", "))
+		}
+		if !project.Configured(args[0]) {
+			return fmt.Errorf("step %v is not configured in %v", args[0], ProjectFilePath)
+		}
+		steps = args[:1]
+	}
+
+	for _, step := range steps {
+		if !project.Configured(step) {
+			continue
+		}
+		fmt.Printf("==> %v\n", step)
+		if err := runProjectStep(project, step); err != nil {
+			return fmt.Errorf("step %v: %v", step, err)
+		}
+	}
+	return nil
+}
+
+func isProjectStep(step string) bool {
+	for _, s := range abd.ProjectSteps {
+		if s == step {
+			return true
+		}
+	}
+	return false
+}
+
+// runProjectStep runs a step with the implementation of the matching
+// subcommand, setting its flags from the project.
+func runProjectStep(project *abd.Project, step string) error {
+	Platform = project.Platform
+	switch step {
+	case abd.StepClone:
+		Dir = project.SourceDir()
+		Rev = project.Source.Rev
+		if _, err := os.Stat(Dir); err == nil {
+			fmt.Printf("%v already exists; not cloning again\n", Dir)
+			return checkout(GitCloneCmd, Dir)
+		}
+		return cloneAndCheckout(GitCloneCmd, []string{project.Source.Repo})
+
+	case abd.StepBuild:
+		ecmd := exec.Command("sh", "-c", project.Build.Command)
+		ecmd.Dir = project.SourceDir()
+		ecmd.Stdout = os.Stdout
+		ecmd.Stderr = os.Stderr
+		return ecmd.Run()
+
+	case abd.StepLabel:
+		Labels = make([]string, 0)
+		for k, v := range project.Labels {
+			Labels = append(Labels, k+"="+v)
+		}
+		sort.Strings(Labels)
+		return labelImages(DockerRegexLabelImagesCmd, []string{project.Images})
+
+	case abd.StepTagSuffix:
+		r, err := abd.MakeRegex(project.Images)
+		if err != nil {
+			return err
+		}
+		dcli, err := client.NewClientWithOpts(client.FromEnv)
+		if err != nil {
+			return err
+		}
+		return abd.EditTagSuffix(dcli, project.TagSuffix, true, r, Platform)
+
+	case abd.StepSetPathPrefix:
+		return setRegistryWrapper(DockerRegexSetPathPrefixCmd, []string{project.Images, project.PathPrefix})
+
+	case abd.StepPush:
+		PushPolicyFile = project.Push.Policy
+		if err := pushWrapper(DockerRegexPushCmd, []string{project.PushImages()}); err != nil {
+			return err
+		}
+		return copyToRegistries(project)
+	}
+	return fmt.Errorf("unknown step %q", step)
+}
+
+// copyToRegistries copies the pushed images to the other registries of the
+// project, under the same last path element and tag.
+func copyToRegistries(project *abd.Project) error {
+	if len(project.Push.Registries) == 0 {
+		return nil
+	}
+	r, err := abd.MakeRegex(project.PushImages())
+	if err != nil {
+		return err
+	}
+	dcli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return err
+	}
+	found, err := abd.FindImages(dcli, r)
+	if err != nil {
+		return err
+	}
+	found, err = found.FilterPlatform(Platform)
+	if err != nil {
+		return err
+	}
+
+	rcli := abd.NewRegistryClient()
+	for _, name := range found.SortedNames() {
+		src, tag, err := abd.ParseImageReference(name)
+		if err != nil {
+			return err
+		}
+		_, lp, err := splitLastPath(src.Name())
+		if err != nil {
+			return err
+		}
+		for _, registry := range project.Push.Registries {
+			dst, err := parseRepository(registry + "/" + lp)
+			if err != nil {
+				return err
+			}
+			if _, err := rcli.Copy(src, tag, dst, tag); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
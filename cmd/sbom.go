@@ -0,0 +1,165 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	abd "github.com/GoogleCloudPlatform/k8s-addon-builder/pkg"
+	"github.com/docker/docker/client"
+	"github.com/spf13/cobra"
+)
+
+var SBOMCmd = &cobra.Command{
+	Use:   "sbom <IMAGE|REGEX>",
+	Short: "generate SPDX and CycloneDX software bills of materials for images",
+	Long: `Generate software bills of materials for the local images matching a regex.
+
+Go binaries are identified by their embedded build info (main module, module
+dependencies and Go version); OS packages are read from the dpkg and apk
+databases. One document per format is written for each image. With --attach,
+the documents are also added to the image in a new layer under /sbom, and
+referenced by the com.google.k8s-addon-builder.sbom.* labels.`,
+	Args: cobra.ExactArgs(1),
+	RunE: generateSBOMs,
+}
+
+var SBOMFormats []string
+var SBOMOutputDir string
+var SBOMAttach bool
+
+// Labels pointing at the SBOM documents added to images by --attach.
+const (
+	sbomDir            = "/sbom"
+	sbomLabelSPDX      = "com.google.k8s-addon-builder.sbom.spdx"
+	sbomLabelCycloneDX = "com.google.k8s-addon-builder.sbom.cyclonedx"
+)
+
+func init() {
+	PlyCmd.AddCommand(SBOMCmd)
+	SBOMCmd.Flags().StringSliceVar(&SBOMFormats, "format", []string{"spdx", "cyclonedx"}, "document formats to produce (spdx, cyclonedx)")
+	SBOMCmd.Flags().StringVarP(&SBOMOutputDir, "output-dir", "o", ".", "directory to write the documents to")
+	SBOMCmd.Flags().BoolVar(&SBOMAttach, "attach", false, "also add the documents to each image in a new layer")
+	addSourceDateEpochFlag(SBOMCmd)
+}
+
+func generateSBOMs(cmd *cobra.Command, args []string) error {
+	r, err := abd.MakeRegex(args[0])
+	if err != nil {
+		return err
+	}
+	for _, format := range SBOMFormats {
+		if format != "spdx" && format != "cyclonedx" {
+			return fmt.Errorf("unknown SBOM format '%v' (must be spdx or cyclonedx)", format)
+		}
+	}
+	created := time.Now().UTC()
+	if epoch, err := abd.ParseSourceDateEpoch(SourceDateEpoch); err != nil {
+		return err
+	} else if epoch != nil {
+		created = *epoch
+	}
+	if err := os.MkdirAll(SBOMOutputDir, 0755); err != nil {
+		return err
+	}
+
+	dcli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return err
+	}
+	found, err := abd.FindImages(dcli, r)
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		fmt.Printf("No images match regex %v\n", args[0])
+		return nil
+	}
+
+	archive, err := abd.SaveImages(dcli, found.SortedNames())
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	for i, m := range archive.Manifest {
+		config, err := archive.ReadConfig(m)
+		if err != nil {
+			return err
+		}
+		contents, err := archive.ScanImage(m)
+		if err != nil {
+			return err
+		}
+
+		attached := make(map[string][]byte)
+		labels := make(map[string]string)
+		for _, name := range m.RepoTags {
+			info := abd.SBOMInfo{
+				ImageName: name,
+				ImageID:   abd.Digest(config),
+				Tool:      "ply-" + VersionGit,
+				Created:   created,
+			}
+			fmt.Printf("%v: %v packages\n", name, len(contents.Packages))
+
+			for _, format := range SBOMFormats {
+				var doc []byte
+				var ext, label string
+				if format == "spdx" {
+					doc, err = abd.SPDXDocument(info, contents)
+					ext, label = ".spdx.json", sbomLabelSPDX
+				} else {
+					doc, err = abd.CycloneDXDocument(info, contents)
+					ext, label = ".cdx.json", sbomLabelCycloneDX
+				}
+				if err != nil {
+					return err
+				}
+
+				path := filepath.Join(SBOMOutputDir, abd.SafeFileName(name)+ext)
+				if err := ioutil.WriteFile(path, doc, 0644); err != nil {
+					return err
+				}
+				fmt.Printf("  - wrote %v\n", path)
+
+				// Images with several tags get a single set of documents,
+				// named after the first tag.
+				inImage := sbomDir + "/sbom" + ext
+				if _, ok := attached[inImage]; !ok {
+					attached[inImage] = doc
+					labels[label] = inImage
+				}
+			}
+		}
+
+		if SBOMAttach {
+			if err := archive.AppendLayer(i, attached, labels, "ply sbom --attach", created); err != nil {
+				return err
+			}
+		}
+	}
+
+	if SBOMAttach {
+		fmt.Println("Attaching SBOMs to images:")
+		found.ShowPretty()
+		return archive.Load(dcli)
+	}
+	return nil
+}
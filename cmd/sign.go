@@ -0,0 +1,113 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	abd "github.com/GoogleCloudPlatform/k8s-addon-builder/pkg"
+	"github.com/docker/docker/client"
+	"github.com/spf13/cobra"
+)
+
+var SignCmd = &cobra.Command{
+	Use:   "sign <REGEX>",
+	Short: "sign pushed images matching a regex with a local key",
+	Long: `Sign the images matching a regex with an ECDSA or ed25519 private key read
+from a PEM file.
+
+The images must have been pushed: what is signed is the digest their tag
+resolves to in the registry, in a cosign-compatible simple signing payload.
+Signatures are pushed next to the image under the tag sha256-<hex>.sig (where
+cosign looks for them), and/or written to --output-dir.`,
+	Args: cobra.ExactArgs(1),
+	RunE: signImages,
+}
+
+var SignKey string
+var SignOutputDir string
+var SignUpload bool
+
+func init() {
+	PlyCmd.AddCommand(SignCmd)
+	SignCmd.Flags().StringVar(&SignKey, "key", "", "PEM file of the private key (required)")
+	SignCmd.MarkFlagRequired("key")
+	SignCmd.Flags().StringVar(&SignOutputDir, "output-dir", "", "also write the signatures to this directory")
+	SignCmd.Flags().BoolVar(&SignUpload, "upload", true, "push the signatures to the registry")
+}
+
+func signImages(cmd *cobra.Command, args []string) error {
+	r, err := abd.MakeRegex(args[0])
+	if err != nil {
+		return err
+	}
+	key, err := abd.LoadPrivateKey(SignKey)
+	if err != nil {
+		return err
+	}
+
+	dcli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return err
+	}
+	found, err := abd.FindImages(dcli, r)
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		fmt.Printf("No images match regex %v\n", args[0])
+		return nil
+	}
+
+	rcli := abd.NewRegistryClient()
+	signed := make(map[string]bool)
+	fmt.Println("Signing images:")
+	for _, name := range found.SortedNames() {
+		named, ref, err := abd.ParseImageReference(name)
+		if err != nil {
+			return err
+		}
+		desc, err := rcli.HeadManifest(named, ref)
+		if err != nil {
+			return err
+		}
+		if desc == nil {
+			return fmt.Errorf("%v not found in its registry (push it first)", name)
+		}
+		image := abd.ImageString(named, desc.Digest)
+		// Tags of the same image need a single signature.
+		if signed[image] {
+			continue
+		}
+		signed[image] = true
+
+		sig, err := abd.SignImage(key, named, desc.Digest)
+		if err != nil {
+			return err
+		}
+		if SignUpload {
+			if err := rcli.PushSignature(named, desc.Digest, sig); err != nil {
+				return err
+			}
+		}
+		if SignOutputDir != "" {
+			if err := abd.WriteSignatureFiles(SignOutputDir, named, desc.Digest, sig); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("  - %v (%v)\n", image, name)
+	}
+	return nil
+}
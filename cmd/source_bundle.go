@@ -0,0 +1,102 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+
+	abd "github.com/GoogleCloudPlatform/k8s-addon-builder/pkg"
+	"github.com/docker/docker/client"
+	"github.com/spf13/cobra"
+)
+
+var SourceBundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "add a source bundle of a git repository to images",
+	Long: `Bundle the source code committed at HEAD of a git repository into
+/source.tar.xz, in a new layer of each image matching a regex (where
+extract_source.sh finds it).
+
+Ignored files are left out; vendored modules are included even if vendor/ is
+not committed. The bundle is reproducible: its files are stamped with the
+commit time (or --source-date-epoch), as is the new layer.`,
+	Args: cobra.NoArgs,
+	RunE: bundleSource,
+}
+
+var SourceRepoDir string
+var SourceImageRegex string
+
+func init() {
+	SourceCmd.AddCommand(SourceBundleCmd)
+	SourceBundleCmd.Flags().StringVar(&SourceRepoDir, "repo", ".", "directory of the git repository")
+	SourceBundleCmd.Flags().StringVar(&SourceImageRegex, "image", "", "regex of the images to add the bundle to (required)")
+	SourceBundleCmd.MarkFlagRequired("image")
+	addSourceDateEpochFlag(SourceBundleCmd)
+}
+
+func bundleSource(cmd *cobra.Command, args []string) error {
+	r, err := abd.MakeRegex(SourceImageRegex)
+	if err != nil {
+		return err
+	}
+	source, err := abd.ReadGitSource(SourceRepoDir)
+	if err != nil {
+		return err
+	}
+	created := source.Time
+	if epoch, err := abd.ParseSourceDateEpoch(SourceDateEpoch); err != nil {
+		return err
+	} else if epoch != nil {
+		created = *epoch
+	}
+
+	dcli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return err
+	}
+	found, err := abd.FindImages(dcli, r)
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		fmt.Printf("No images match regex %v\n", SourceImageRegex)
+		return nil
+	}
+
+	var bundle bytes.Buffer
+	if err := abd.WriteSourceBundle(&bundle, SourceRepoDir, created); err != nil {
+		return err
+	}
+	fmt.Printf("Bundled source of commit %v (%v bytes)\n", source.Commit, bundle.Len())
+
+	archive, err := abd.SaveImages(dcli, found.SortedNames())
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	files := map[string][]byte{abd.SourceBundlePath: bundle.Bytes()}
+	for i := range archive.Manifest {
+		if err := archive.AppendLayer(i, files, nil, "ply source bundle "+source.Commit, created); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("Adding source bundle to images:")
+	found.ShowPretty()
+	return archive.Load(dcli)
+}
@@ -0,0 +1,121 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto"
+	"fmt"
+	"strings"
+
+	abd "github.com/GoogleCloudPlatform/k8s-addon-builder/pkg"
+	"github.com/spf13/cobra"
+)
+
+var VerifyCmd = &cobra.Command{
+	Use:   "verify <IMAGE>",
+	Short: "verify the signatures of an image against a set of public keys",
+	Long: `Verify that the digest IMAGE resolves to in its registry is signed by (one of,
+or with --require-all every one of) the given public keys.
+
+Signatures are read from the registry (as pushed by 'ply sign' or cosign), or
+from --signature-dir if given. A signature only counts if it names the
+repository of IMAGE, so that signatures copied along with an image from another
+repository are rejected. The command fails unless the check passes.`,
+	Args: cobra.ExactArgs(1),
+	RunE: verifyImage,
+}
+
+var VerifyKeys []string
+var VerifySignatureDir string
+var VerifyRequireAll bool
+
+func init() {
+	PlyCmd.AddCommand(VerifyCmd)
+	VerifyCmd.Flags().StringSliceVar(&VerifyKeys, "key", nil, "PEM file of a trusted public key (required, can be repeated)")
+	VerifyCmd.MarkFlagRequired("key")
+	VerifyCmd.Flags().StringVar(&VerifySignatureDir, "signature-dir", "", "read signatures from this directory instead of the registry")
+	VerifyCmd.Flags().BoolVar(&VerifyRequireAll, "require-all", false, "require a signature from every key instead of any")
+}
+
+func verifyImage(cmd *cobra.Command, args []string) error {
+	keys := make(map[string]crypto.PublicKey)
+	for _, path := range VerifyKeys {
+		key, err := abd.LoadPublicKey(path)
+		if err != nil {
+			return err
+		}
+		keys[path] = key
+	}
+
+	named, ref, err := abd.ParseImageReference(args[0])
+	if err != nil {
+		return err
+	}
+	rcli := abd.NewRegistryClient()
+	digest := ref
+	if !abd.IsDigest(ref) {
+		desc, err := rcli.HeadManifest(named, ref)
+		if err != nil {
+			return err
+		}
+		if desc == nil {
+			return fmt.Errorf("image %v not found", abd.ImageString(named, ref))
+		}
+		digest = desc.Digest
+	}
+	image := abd.ImageString(named, digest)
+
+	var sigs []abd.Signature
+	if VerifySignatureDir != "" {
+		sigs, err = abd.ReadSignatureFiles(VerifySignatureDir, named, digest)
+	} else {
+		sigs, err = rcli.Signatures(named, digest)
+	}
+	if err != nil {
+		return err
+	}
+	if len(sigs) == 0 {
+		return fmt.Errorf("no signatures found for %v", image)
+	}
+
+	verified := make(map[string]bool)
+	for _, sig := range sigs {
+		for _, path := range VerifyKeys {
+			if verified[path] {
+				continue
+			}
+			if err := sig.Verify(keys[path], named, digest); err != nil {
+				continue
+			}
+			verified[path] = true
+			fmt.Printf("%v: signed by %v\n", image, path)
+		}
+	}
+
+	missing := make([]string, 0)
+	for _, path := range VerifyKeys {
+		if !verified[path] {
+			missing = append(missing, path)
+		}
+	}
+	if len(verified) == 0 {
+		return fmt.Errorf("no valid signature of %v by any of %v", image, strings.Join(VerifyKeys, ", "))
+	}
+	if VerifyRequireAll && len(missing) > 0 {
+		return fmt.Errorf("%v is not signed by %v", image, strings.Join(missing, ", "))
+	}
+	fmt.Printf("%v: verified\n", image)
+	return nil
+}
@@ -6,10 +6,14 @@ require (
 	github.com/containerd/containerd v1.5.2 // indirect
 	github.com/docker/distribution v2.7.1+incompatible
 	github.com/docker/docker v20.10.7+incompatible
-	github.com/docker/go-connections v0.4.0 // indirect
+	github.com/docker/go-connections v0.4.0
+	github.com/docker/go-units v0.4.0
+	github.com/go-git/go-git/v5 v5.11.0
 	github.com/gorilla/mux v1.8.0 // indirect
 	github.com/moby/term v0.0.0-20210610120745-9d4ed1856297 // indirect
 	github.com/morikuni/aec v1.0.0 // indirect
 	github.com/spf13/cobra v1.1.3
-	github.com/go-git/go-git/v5 v5.11.0
+	github.com/ulikunitz/xz v0.5.11
+	golang.org/x/mod v0.14.0
+	gopkg.in/yaml.v3 v3.0.1
 )
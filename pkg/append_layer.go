@@ -0,0 +1,164 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AppendLayer adds a layer holding the given files (keyed by absolute path)
+// on top of an image of the archive, and merges labels into its config. The
+// layer is built deterministically: entries are sorted, owned by root and
+// stamped with the creation time, which is also recorded in the new history
+// entry. Parent directories are created as needed. Call Load() afterwards to
+// update the image in the daemon.
+func (a *ImageArchive) AppendLayer(i int, files map[string][]byte, labels map[string]string, createdBy string, created time.Time) error {
+	tmp := a.Path("layer.tar.tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	err = writeLayer(io.MultiWriter(f, h), files, created)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	diffID := "sha256:" + hex.EncodeToString(h.Sum(nil))
+	name := strings.TrimPrefix(diffID, "sha256:") + "/layer.tar"
+	if err := os.MkdirAll(filepath.Dir(a.Path(name)), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, a.Path(name)); err != nil {
+		return err
+	}
+
+	m := a.Manifest[i]
+	raw, err := a.ReadConfig(m)
+	if err != nil {
+		return err
+	}
+	config, err := appendLayerToConfig(raw, diffID, labels, createdBy, created)
+	if err != nil {
+		return fmt.Errorf("could not update config of %v: %v", strings.Join(m.RepoTags, ", "), err)
+	}
+	configName, err := a.writeConfig(config)
+	if err != nil {
+		return err
+	}
+
+	a.Manifest[i].Config = configName
+	a.Manifest[i].Layers = append(append([]string{}, m.Layers...), name)
+	return nil
+}
+
+func writeLayer(w io.Writer, files map[string][]byte, created time.Time) error {
+	contents := make(map[string][]byte)
+	dirs := make(map[string]bool)
+	for p, content := range files {
+		p = path.Clean("/" + p)
+		contents[p] = content
+		for dir := path.Dir(p); dir != "/"; dir = path.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	paths := make([]string, 0)
+	for p := range contents {
+		paths = append(paths, p)
+	}
+	for dir := range dirs {
+		paths = append(paths, dir+"/")
+	}
+	sort.Strings(paths)
+
+	tw := tar.NewWriter(w)
+	for _, p := range paths {
+		hdr := &tar.Header{
+			Name:     strings.TrimPrefix(p, "/"),
+			ModTime:  created,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Format:   tar.FormatPAX,
+		}
+		if strings.HasSuffix(p, "/") {
+			hdr.Typeflag = tar.TypeDir
+			hdr.Mode = 0755
+		} else {
+			hdr.Size = int64(len(contents[p]))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(contents[p]); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func appendLayerToConfig(raw []byte, diffID string, labels map[string]string, createdBy string, created time.Time) ([]byte, error) {
+	var config map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, err
+	}
+	timestamp := created.UTC().Format(time.RFC3339Nano)
+
+	rootfs, ok := config["rootfs"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("config has no rootfs")
+	}
+	diffIDs, _ := rootfs["diff_ids"].([]interface{})
+	rootfs["diff_ids"] = append(diffIDs, diffID)
+
+	history, _ := config["history"].([]interface{})
+	config["history"] = append(history, map[string]interface{}{
+		"created":    timestamp,
+		"created_by": createdBy,
+		"comment":    "ply",
+	})
+	config["created"] = timestamp
+
+	if len(labels) > 0 {
+		runConfig, ok := config["config"].(map[string]interface{})
+		if !ok || runConfig == nil {
+			runConfig = make(map[string]interface{})
+			config["config"] = runConfig
+		}
+		existing, ok := runConfig["Labels"].(map[string]interface{})
+		if !ok || existing == nil {
+			existing = make(map[string]interface{})
+			runConfig["Labels"] = existing
+		}
+		for k, v := range labels {
+			existing[k] = v
+		}
+	}
+
+	return json.Marshal(config)
+}
@@ -0,0 +1,301 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/client"
+)
+
+// ImageArchive is the unpacked form of a "docker save" tarball. The tarball is
+// extracted into a temporary directory so that configs and layers can be read
+// (and rewritten) before the images are optionally loaded back into the
+// daemon.
+type ImageArchive struct {
+	Dir      string
+	Manifest []ArchiveManifest
+}
+
+// ArchiveManifest is a single entry of the manifest.json file found at the
+// root of a "docker save" tarball. All paths are relative to the archive root.
+type ArchiveManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// SaveImages exports the given images from the daemon and unpacks them into a
+// temporary directory. Callers must call Close() on the returned archive.
+func SaveImages(dcli *client.Client, names []string) (*ImageArchive, error) {
+	stream, err := dcli.ImageSave(context.Background(), names)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	return ReadImageArchive(stream)
+}
+
+// ReadImageArchive unpacks a "docker save" tarball into a temporary directory.
+// Callers must call Close() on the returned archive.
+func ReadImageArchive(r io.Reader) (*ImageArchive, error) {
+	dir, err := ioutil.TempDir("", "ply-image-")
+	if err != nil {
+		return nil, err
+	}
+	archive := &ImageArchive{Dir: dir}
+
+	if err := untar(r, dir); err != nil {
+		archive.Close()
+		return nil, err
+	}
+
+	manifest, err := ioutil.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		archive.Close()
+		return nil, err
+	}
+	if err := json.Unmarshal(manifest, &archive.Manifest); err != nil {
+		archive.Close()
+		return nil, fmt.Errorf("could not parse manifest.json: %v", err)
+	}
+	return archive, nil
+}
+
+// Close removes the temporary directory backing the archive.
+func (a *ImageArchive) Close() error {
+	return os.RemoveAll(a.Dir)
+}
+
+// Path returns the absolute path of a file inside the archive.
+func (a *ImageArchive) Path(name string) string {
+	return filepath.Join(a.Dir, filepath.FromSlash(name))
+}
+
+// Find returns the manifest entry of an image by name. Names are compared in
+// their familiar form with a tag ("foo" is "foo:latest"). Images that match no
+// name are looked up by image ID (their config digest), with or without the
+// "sha256:" prefix and possibly abbreviated like "docker images" shows them.
+func (a *ImageArchive) Find(name string) (ArchiveManifest, bool) {
+	if wanted, err := reference.ParseNormalizedNamed(name); err == nil {
+		for _, m := range a.Manifest {
+			for _, repoTag := range m.RepoTags {
+				named, err := reference.ParseNormalizedNamed(repoTag)
+				if err == nil && reference.TagNameOnly(named).String() == reference.TagNameOnly(wanted).String() {
+					return m, true
+				}
+			}
+		}
+	}
+	match := imageIDRegex.FindStringSubmatch(name)
+	if match == nil {
+		return ArchiveManifest{}, false
+	}
+	for _, m := range a.Manifest {
+		if strings.HasPrefix(m.ID(), "sha256:"+match[1]) {
+			return m, true
+		}
+	}
+	return ArchiveManifest{}, false
+}
+
+var imageIDRegex = regexp.MustCompile(`^(?:sha256:)?([a-f0-9]{12,64})$`)
+
+// ID returns the image ID of a manifest entry, which is the digest of its
+// config. The config is named after its digest: "<hex>.json" in the legacy
+// layout and "blobs/sha256/<hex>" in the OCI one.
+func (m ArchiveManifest) ID() string {
+	return "sha256:" + strings.TrimSuffix(path.Base(m.Config), ".json")
+}
+
+// ReadConfig returns the raw image config JSON for a manifest entry.
+func (a *ImageArchive) ReadConfig(m ArchiveManifest) ([]byte, error) {
+	return ioutil.ReadFile(a.Path(m.Config))
+}
+
+// Load writes out manifest.json and loads every image in the archive back into
+// the daemon. Images are (re)tagged according to the RepoTags of each
+// manifest entry.
+func (a *ImageArchive) Load(dcli *client.Client) error {
+	manifest, err := json.Marshal(a.Manifest)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(a.Path("manifest.json"), manifest, 0644); err != nil {
+		return err
+	}
+	// The "repositories" file is a legacy format that would override the tags
+	// recorded in manifest.json.
+	if err := os.RemoveAll(a.Path("repositories")); err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(tarDir(pw, a.Dir))
+	}()
+
+	ctx := context.Background()
+	resp, err := dcli.ImageLoad(ctx, pr, true)
+	if err != nil {
+		pr.Close()
+		return err
+	}
+	return PrintStream(ctx, resp.Body)
+}
+
+func untar(r io.Reader, dir string) error {
+	// Layers shared between images are stored as symlinks to the first copy.
+	// They are resolved into regular files once everything is extracted so
+	// that callers can treat every layer as a regular file.
+	links := make(map[string]string)
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("invalid path %q in image archive", hdr.Name)
+		}
+		path := filepath.Join(dir, name)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			f, err := os.Create(path)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			target := filepath.Join(filepath.Dir(path), filepath.FromSlash(hdr.Linkname))
+			if rel, err := filepath.Rel(dir, target); err != nil || strings.HasPrefix(rel, "..") {
+				return fmt.Errorf("invalid link %q in image archive", hdr.Name)
+			}
+			links[path] = target
+		}
+	}
+
+	for path, target := range links {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := copyFile(target, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tarDir writes the contents of dir as an uncompressed tarball. Entries are
+// written in lexical order.
+func tarDir(w io.Writer, dir string) error {
+	tw := tar.NewWriter(w)
+	var paths []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(tw, f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tw.Close()
+}
+
+func copyFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,157 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultBuilderImage is the addon-builder image (see build.sh) that runs the
+// steps of generated Cloud Build configs.
+const DefaultBuilderImage = "gcr.io/gke-release-staging/addon-builder:1.19"
+
+// CloudBuild is a Google Cloud Build config (cloudbuild.yaml) [1]. Only the
+// fields ply generates and runs are modeled.
+//
+// [1]: https://cloud.google.com/build/docs/build-config-file-schema
+type CloudBuild struct {
+	Steps         []CloudBuildStep  `yaml:"steps"`
+	Substitutions map[string]string `yaml:"substitutions,omitempty"`
+	Timeout       string            `yaml:"timeout,omitempty"`
+}
+
+// CloudBuildStep is a build step: a container run from an image.
+type CloudBuildStep struct {
+	Name       string   `yaml:"name"`
+	ID         string   `yaml:"id,omitempty"`
+	Entrypoint string   `yaml:"entrypoint,omitempty"`
+	Args       []string `yaml:"args,omitempty"`
+	Env        []string `yaml:"env,omitempty"`
+	Dir        string   `yaml:"dir,omitempty"`
+	// WaitFor lists the IDs of the steps to wait for; "-" means none. By
+	// default (or when empty) a step waits for all the previous ones.
+	WaitFor []string `yaml:"waitFor,omitempty"`
+}
+
+// CloudBuildSubstitutions are the substitutions Cloud Build provides. Other
+// substitutions must be user-defined, with names starting with "_".
+var CloudBuildSubstitutions = map[string]bool{
+	"PROJECT_ID":                true,
+	"PROJECT_NUMBER":            true,
+	"BUILD_ID":                  true,
+	"LOCATION":                  true,
+	"TRIGGER_NAME":              true,
+	"COMMIT_SHA":                true,
+	"REVISION_ID":               true,
+	"SHORT_SHA":                 true,
+	"REPO_NAME":                 true,
+	"REPO_FULL_NAME":            true,
+	"BRANCH_NAME":               true,
+	"TAG_NAME":                  true,
+	"REF_NAME":                  true,
+	"SERVICE_ACCOUNT_EMAIL":     true,
+	"TRIGGER_BUILD_CONFIG_PATH": true,
+}
+
+// ProjectVariables returns the environment variables a project file refers
+// to.
+func ProjectVariables(path string) ([]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	vars := make([]string, 0)
+	os.Expand(string(content), func(name string) string {
+		if !seen[name] {
+			seen[name] = true
+			vars = append(vars, name)
+		}
+		return ""
+	})
+	sort.Strings(vars)
+	return vars, nil
+}
+
+// GenerateCloudBuild returns a Cloud Build config that runs the configured
+// steps of a project one after the other, each with "ply run STEP" in the
+// builder image. The variables the project file refers to are passed to
+// every step in its environment: from the Cloud Build substitution of the
+// same name if there is one (e.g. PROJECT_ID), or else from a user-defined
+// substitution "_NAME". User-defined substitutions default to the value of
+// their variable in defaults, or to "" (never to the environment of the
+// generator, which may hold anything).
+func GenerateCloudBuild(project *Project, projectFile string, vars []string, image string, defaults map[string]string) *CloudBuild {
+	build := &CloudBuild{Steps: make([]CloudBuildStep, 0)}
+	env := []string{"BUILD_ID=$BUILD_ID", "PROJECT_ID=$PROJECT_ID"}
+	for _, name := range vars {
+		switch {
+		case name == "BUILD_ID" || name == "PROJECT_ID":
+		case CloudBuildSubstitutions[name]:
+			env = append(env, name+"=$"+name)
+		default:
+			substitution := name
+			if !strings.HasPrefix(substitution, "_") {
+				substitution = "_" + name
+			}
+			if build.Substitutions == nil {
+				build.Substitutions = make(map[string]string)
+			}
+			build.Substitutions[substitution] = defaults[name]
+			env = append(env, name+"=${"+substitution+"}")
+		}
+	}
+
+	previous := "-"
+	for _, step := range ProjectSteps {
+		if !project.Configured(step) {
+			continue
+		}
+		args := []string{"run", step}
+		if projectFile != ProjectFile {
+			args = append(args, "--file", projectFile)
+		}
+		build.Steps = append(build.Steps, CloudBuildStep{
+			Name:       image,
+			ID:         step,
+			Entrypoint: "ply",
+			Args:       args,
+			Env:        env,
+			WaitFor:    []string{previous},
+		})
+		previous = step
+	}
+	return build
+}
+
+// Marshal renders the config as YAML.
+func (b *CloudBuild) Marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(b); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
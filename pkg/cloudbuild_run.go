@@ -0,0 +1,402 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/docker/pkg/stdcopy"
+	"gopkg.in/yaml.v3"
+)
+
+// LoadCloudBuild reads a Cloud Build config. Fields ply does not run (e.g.
+// images or options) are ignored.
+func LoadCloudBuild(filePath string) (*CloudBuild, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var b CloudBuild
+	if err := yaml.NewDecoder(f).Decode(&b); err != nil {
+		return nil, fmt.Errorf("%v: %v", filePath, err)
+	}
+	ids := make(map[string]bool)
+	for i, step := range b.Steps {
+		if step.Name == "" {
+			return nil, fmt.Errorf("%v: step %v has no name (image)", filePath, i)
+		}
+		for _, id := range step.WaitFor {
+			if id != "-" && !ids[id] {
+				return nil, fmt.Errorf("%v: step %v waits for %q, which is not a previous step", filePath, b.stepName(i), id)
+			}
+		}
+		if step.ID != "" {
+			if ids[step.ID] {
+				return nil, fmt.Errorf("%v: duplicate step id %q", filePath, step.ID)
+			}
+			ids[step.ID] = true
+		}
+	}
+	return &b, nil
+}
+
+// stepName names a step in logs the way Cloud Build does.
+func (b *CloudBuild) stepName(i int) string {
+	if b.Steps[i].ID != "" {
+		return fmt.Sprintf("Step #%v - %q", i, b.Steps[i].ID)
+	}
+	return fmt.Sprintf("Step #%v", i)
+}
+
+// NewBuildID returns a random build ID, formatted like Cloud Build's (a UUID).
+func NewBuildID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// BuiltinSubstitutions returns the values of the substitutions Cloud Build
+// provides for a build of a workspace. The commit substitutions are set if the
+// workspace is a git repository.
+func BuiltinSubstitutions(workspace, projectID, buildID string) map[string]string {
+	values := map[string]string{
+		"PROJECT_ID": projectID,
+		"BUILD_ID":   buildID,
+		"LOCATION":   "global",
+	}
+	if source, err := ReadGitSource(workspace); err == nil && len(source.Commit) >= 7 {
+		values["COMMIT_SHA"] = source.Commit
+		values["REVISION_ID"] = source.Commit
+		values["SHORT_SHA"] = source.Commit[:7]
+	}
+	return values
+}
+
+// substitutionRegex matches what Cloud Build substitutes: "$$" (an escaped
+// "$"), "${NAME}" and "$NAME".
+var substitutionRegex = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)\}|\$([A-Za-z_][A-Za-z0-9_]*)`)
+
+// substitute replaces the substitutions of a string. Unknown names are an
+// error, as in Cloud Build.
+func substitute(s string, values map[string]string) (string, error) {
+	var err error
+	result := substitutionRegex.ReplaceAllStringFunc(s, func(match string) string {
+		if match == "$$" {
+			return "$"
+		}
+		name := strings.Trim(match, "${}")
+		value, ok := values[name]
+		if !ok && err == nil {
+			err = fmt.Errorf("unknown substitution %v in %q (write $$ for a literal $)", match, s)
+		}
+		return value
+	})
+	return result, err
+}
+
+// Substitute returns the build with the substitutions of its steps replaced,
+// from the substitutions of the config, overridden by the given values.
+func (b *CloudBuild) Substitute(values map[string]string) (*CloudBuild, error) {
+	all := make(map[string]string)
+	for k, v := range b.Substitutions {
+		all[k] = v
+	}
+	for k, v := range values {
+		all[k] = v
+	}
+
+	result := &CloudBuild{Substitutions: all, Timeout: b.Timeout}
+	for i, step := range b.Steps {
+		var err error
+		sub := func(s string) string {
+			var r string
+			if err == nil {
+				r, err = substitute(s, all)
+			}
+			return r
+		}
+		subAll := func(ss []string) []string {
+			if ss == nil {
+				return nil
+			}
+			r := make([]string, len(ss))
+			for j, s := range ss {
+				r[j] = sub(s)
+			}
+			return r
+		}
+		s := CloudBuildStep{
+			Name:       sub(step.Name),
+			ID:         step.ID,
+			Entrypoint: sub(step.Entrypoint),
+			Args:       subAll(step.Args),
+			Env:        subAll(step.Env),
+			Dir:        sub(step.Dir),
+			WaitFor:    step.WaitFor,
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", b.stepName(i), err)
+		}
+		result.Steps = append(result.Steps, s)
+	}
+	return result, nil
+}
+
+// dependencies returns the steps each step waits for: those of its waitFor,
+// none for "-", or all the previous ones by default. An empty waitFor is the
+// default, as in Cloud Build, where an empty list cannot be told from none.
+func (b *CloudBuild) dependencies() [][]int {
+	index := make(map[string]int)
+	deps := make([][]int, len(b.Steps))
+	for i, step := range b.Steps {
+		deps[i] = make([]int, 0)
+		switch {
+		case len(step.WaitFor) == 0:
+			for j := 0; j < i; j++ {
+				deps[i] = append(deps[i], j)
+			}
+		default:
+			for _, id := range step.WaitFor {
+				if id != "-" {
+					deps[i] = append(deps[i], index[id])
+				}
+			}
+		}
+		if step.ID != "" {
+			index[step.ID] = i
+		}
+	}
+	return deps
+}
+
+// runSteps runs every step once all the steps it waits for succeeded, in
+// parallel when they allow it. Once a step fails, no other step is started;
+// the error of the first failure is returned.
+func (b *CloudBuild) runSteps(run func(i int) error) error {
+	deps := b.dependencies()
+	done := make([]chan struct{}, len(b.Steps))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+
+	var mu sync.Mutex
+	var firstErr error
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+
+	var wg sync.WaitGroup
+	for i := range b.Steps {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer close(done[i])
+			for _, j := range deps[i] {
+				<-done[j]
+			}
+			if failed() {
+				return
+			}
+			if err := run(i); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("%v: %v", b.stepName(i), err)
+				}
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// CloudBuildRunner runs Cloud Build steps locally with the Docker daemon: each
+// step runs in a container of its image, with the workspace mounted on
+// /workspace and the Docker socket mounted so that steps can use Docker, as
+// in Cloud Build.
+type CloudBuildRunner struct {
+	dcli *client.Client
+	// Workspace is the absolute path of the directory mounted on /workspace.
+	Workspace string
+	// Env is added to the environment of every step (before the step's own
+	// env).
+	Env []string
+	// Out receives the logs of the steps, each line prefixed with the step.
+	Out io.Writer
+	mu  sync.Mutex
+}
+
+// NewCloudBuildRunner returns a runner of steps in a workspace.
+func NewCloudBuildRunner(dcli *client.Client, workspace string, env []string) *CloudBuildRunner {
+	return &CloudBuildRunner{dcli: dcli, Workspace: workspace, Env: env, Out: os.Stdout}
+}
+
+// Run runs the steps of a build (after substitution), following their
+// waitFor dependencies.
+func (r *CloudBuildRunner) Run(b *CloudBuild) error {
+	// Pull the images first, so that the logs of parallel pulls do not mix.
+	pulled := make(map[string]bool)
+	for _, step := range b.Steps {
+		if pulled[step.Name] {
+			continue
+		}
+		pulled[step.Name] = true
+		if err := r.pullImage(step.Name); err != nil {
+			return err
+		}
+	}
+	return b.runSteps(func(i int) error {
+		return r.runStep(b.stepName(i), b.Steps[i])
+	})
+}
+
+// pullImage pulls an image unless it is already present.
+func (r *CloudBuildRunner) pullImage(image string) error {
+	ctx := context.Background()
+	if _, _, err := r.dcli.ImageInspectWithRaw(ctx, image); err == nil {
+		return nil
+	} else if !client.IsErrNotFound(err) {
+		return err
+	}
+	fmt.Fprintf(r.Out, "Pulling %v\n", image)
+	resp, err := r.dcli.ImagePull(ctx, image, types.ImagePullOptions{})
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+	return jsonmessage.DisplayJSONMessagesStream(resp, ioutil.Discard, 0, false, nil)
+}
+
+func (r *CloudBuildRunner) runStep(name string, step CloudBuildStep) error {
+	ctx := context.Background()
+	config := &container.Config{
+		Image:      step.Name,
+		Cmd:        step.Args,
+		Env:        append(append([]string{}, r.Env...), step.Env...),
+		WorkingDir: path.Join("/workspace", step.Dir),
+	}
+	if path.IsAbs(step.Dir) {
+		config.WorkingDir = step.Dir
+	}
+	if step.Entrypoint != "" {
+		config.Entrypoint = []string{step.Entrypoint}
+	}
+	hostConfig := &container.HostConfig{
+		Binds: []string{
+			r.Workspace + ":/workspace",
+			"/var/run/docker.sock:/var/run/docker.sock",
+		},
+	}
+	created, err := r.dcli.ContainerCreate(ctx, config, hostConfig, nil, nil, "")
+	if err != nil {
+		return err
+	}
+	defer r.dcli.ContainerRemove(ctx, created.ID, types.ContainerRemoveOptions{Force: true})
+
+	r.logf(name, "Starting %v %v", step.Name, strings.Join(append(config.Entrypoint, step.Args...), " "))
+	if err := r.dcli.ContainerStart(ctx, created.ID, types.ContainerStartOptions{}); err != nil {
+		return err
+	}
+	logs, err := r.dcli.ContainerLogs(ctx, created.ID, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true, Follow: true})
+	if err != nil {
+		return err
+	}
+	out := &prefixWriter{runner: r, prefix: name}
+	_, err = stdcopy.StdCopy(out, out, logs)
+	logs.Close()
+	out.flush()
+	if err != nil {
+		return err
+	}
+
+	statusCh, errCh := r.dcli.ContainerWait(ctx, created.ID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		return err
+	case status := <-statusCh:
+		if status.StatusCode != 0 {
+			return fmt.Errorf("exited with status %v", status.StatusCode)
+		}
+	}
+	r.logf(name, "Finished")
+	return nil
+}
+
+func (r *CloudBuildRunner) logf(prefix, format string, a ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fmt.Fprintf(r.Out, "%v: %v\n", prefix, fmt.Sprintf(format, a...))
+}
+
+// prefixWriter writes the complete lines written to it to the output of a
+// runner, prefixed, so that the logs of parallel steps do not mix mid-line.
+type prefixWriter struct {
+	runner *CloudBuildRunner
+	prefix string
+	buf    bytes.Buffer
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for {
+		line, err := w.buf.ReadString('\n')
+		if err != nil {
+			// Keep the incomplete line for later.
+			w.buf.Reset()
+			w.buf.WriteString(line)
+			return len(p), nil
+		}
+		w.runner.logf(w.prefix, "%v", strings.TrimSuffix(line, "\n"))
+	}
+}
+
+func (w *prefixWriter) flush() {
+	if w.buf.Len() > 0 {
+		w.runner.logf(w.prefix, "%v", w.buf.String())
+		w.buf.Reset()
+	}
+}
+
+// SortedSubstitutions lists substitutions as sorted NAME=VALUE pairs.
+func SortedSubstitutions(values map[string]string) []string {
+	pairs := make([]string, 0)
+	for k, v := range values {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
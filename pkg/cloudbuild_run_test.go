@@ -0,0 +1,240 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestSubstitute(t *testing.T) {
+	values := map[string]string{"PROJECT_ID": "p", "_VERSION": "v1"}
+	tests := []struct {
+		s       string
+		want    string
+		wantErr bool
+	}{
+		{s: "gcr.io/$PROJECT_ID/addon:${_VERSION}", want: "gcr.io/p/addon:v1"},
+		{s: "${PROJECT_ID}${_VERSION}", want: "pv1"},
+		{s: "echo $$HOME $$$PROJECT_ID", want: "echo $HOME $p"},
+		{s: "$ 1", want: "$ 1"},
+		{s: "$UNKNOWN", wantErr: true},
+		{s: "${_UNKNOWN}", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := substitute(tt.s, values)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("substitute(%q) error = %v, want error: %v", tt.s, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("substitute(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestCloudBuildSubstitute(t *testing.T) {
+	build := &CloudBuild{
+		Substitutions: map[string]string{"_VERSION": "v1", "_REGION": "us"},
+		Steps: []CloudBuildStep{{
+			Name:    "gcr.io/$PROJECT_ID/builder",
+			ID:      "build",
+			Args:    []string{"make", "VERSION=$_VERSION", "REGION=${_REGION}"},
+			Env:     []string{"HOME=$$HOME"},
+			WaitFor: []string{"-"},
+		}},
+	}
+	tests := []struct {
+		name    string
+		values  map[string]string
+		want    []CloudBuildStep
+		wantErr bool
+	}{
+		{
+			name:   "config substitutions overridden by values",
+			values: map[string]string{"PROJECT_ID": "p", "_VERSION": "v2"},
+			want: []CloudBuildStep{{
+				Name:    "gcr.io/p/builder",
+				ID:      "build",
+				Args:    []string{"make", "VERSION=v2", "REGION=us"},
+				Env:     []string{"HOME=$HOME"},
+				WaitFor: []string{"-"},
+			}},
+		},
+		{
+			name:    "unknown substitution",
+			values:  map[string]string{"_VERSION": "v2"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := build.Substitute(tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Substitute() error = %v, want error: %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got.Steps, tt.want) {
+				t.Errorf("Substitute() steps = %+v, want %+v", got.Steps, tt.want)
+			}
+			if got.Substitutions["_REGION"] != "us" || build.Steps[0].Name != "gcr.io/$PROJECT_ID/builder" {
+				t.Errorf("Substitute() = %+v, modified %+v", got, build)
+			}
+		})
+	}
+}
+
+func TestCloudBuildDependencies(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps string
+		want  [][]int
+	}{
+		{
+			name:  "previous steps by default",
+			steps: "- {name: a}\n- {name: b}\n- {name: c}\n",
+			want:  [][]int{{}, {0}, {0, 1}},
+		},
+		{
+			name:  "empty waitFor is the default",
+			steps: "- {name: a}\n- {name: b, waitFor: []}\n",
+			want:  [][]int{{}, {0}},
+		},
+		{
+			name:  "start immediately",
+			steps: "- {name: a}\n- {name: b, waitFor: ['-']}\n",
+			want:  [][]int{{}, {}},
+		},
+		{
+			name:  "ids",
+			steps: "- {name: a, id: a}\n- {name: b, id: b, waitFor: ['-']}\n- {name: c, waitFor: [b]}\n- {name: d, waitFor: [a, b]}\n",
+			want:  [][]int{{}, {}, {1}, {0, 1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var build CloudBuild
+			if err := yaml.Unmarshal([]byte("steps:\n"+tt.steps), &build); err != nil {
+				t.Fatal(err)
+			}
+			if got := build.dependencies(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("dependencies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCloudBuildRunSteps(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps string
+		// fail is the step that fails, if any.
+		fail int
+		// parallel are steps that must run at the same time.
+		parallel []string
+		wantRun  []string
+		wantErr  string
+	}{
+		{
+			name:    "in order",
+			steps:   "- {name: a, id: a}\n- {name: b, id: b}\n- {name: c, id: c}\n",
+			fail:    -1,
+			wantRun: []string{"a", "b", "c"},
+		},
+		{
+			name: "parallel",
+			// b and c both start once a is done; d waits for both.
+			steps:    "- {name: a, id: a}\n- {name: b, id: b, waitFor: [a]}\n- {name: c, id: c, waitFor: [a]}\n- {name: d, id: d, waitFor: [b, c]}\n",
+			fail:     -1,
+			parallel: []string{"b", "c"},
+			wantRun:  []string{"a", "b", "c", "d"},
+		},
+		{
+			name:    "failure stops dependents",
+			steps:   "- {name: a, id: a}\n- {name: b, id: b}\n- {name: c, id: c}\n",
+			fail:    1,
+			wantRun: []string{"a", "b"},
+			wantErr: `Step #1 - "b": failed`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var build CloudBuild
+			if err := yaml.Unmarshal([]byte("steps:\n"+tt.steps), &build); err != nil {
+				t.Fatal(err)
+			}
+			deps := build.dependencies()
+			started := make(map[string]chan struct{})
+			for _, id := range tt.parallel {
+				started[id] = make(chan struct{})
+			}
+
+			var mu sync.Mutex
+			var run []string
+			finished := make(map[int]bool)
+			err := build.runSteps(func(i int) error {
+				if c, ok := started[build.Steps[i].ID]; ok {
+					close(c)
+					for _, id := range tt.parallel {
+						select {
+						case <-started[id]:
+						case <-time.After(5 * time.Second):
+							t.Errorf("step %v did not run while step %v did", id, build.Steps[i].ID)
+						}
+					}
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				for _, j := range deps[i] {
+					if !finished[j] {
+						t.Errorf("step %v started before step %v finished", i, j)
+					}
+				}
+				run = append(run, build.Steps[i].ID)
+				finished[i] = true
+				if i == tt.fail {
+					return errors.New("failed")
+				}
+				return nil
+			})
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("runSteps() = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("runSteps() = %v, want %v", err, tt.wantErr)
+			}
+			// Steps that may run in parallel run in any order.
+			got := make(map[string]bool)
+			for _, id := range run {
+				got[id] = true
+			}
+			want := make(map[string]bool)
+			for _, id := range tt.wantRun {
+				want[id] = true
+			}
+			if !reflect.DeepEqual(got, want) || len(run) != len(tt.wantRun) {
+				t.Errorf("ran %v, want %v", run, tt.wantRun)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGenerateCloudBuild(t *testing.T) {
+	project := &Project{Build: ProjectBuild{Command: "make"}, Images: "^addon-", TagSuffix: "gke.1"}
+	tests := []struct {
+		name              string
+		vars              []string
+		defaults          map[string]string
+		wantSubstitutions map[string]string
+		wantEnv           []string
+	}{
+		{
+			name:    "no variables",
+			wantEnv: []string{"BUILD_ID=$BUILD_ID", "PROJECT_ID=$PROJECT_ID"},
+		},
+		{
+			name:    "cloud build substitutions",
+			vars:    []string{"COMMIT_SHA", "PROJECT_ID"},
+			wantEnv: []string{"BUILD_ID=$BUILD_ID", "PROJECT_ID=$PROJECT_ID", "COMMIT_SHA=$COMMIT_SHA"},
+		},
+		{
+			name:              "user-defined substitutions default to empty",
+			vars:              []string{"ADDON_VERSION", "_REGION"},
+			wantSubstitutions: map[string]string{"_ADDON_VERSION": "", "_REGION": ""},
+			wantEnv:           []string{"BUILD_ID=$BUILD_ID", "PROJECT_ID=$PROJECT_ID", "ADDON_VERSION=${_ADDON_VERSION}", "_REGION=${_REGION}"},
+		},
+		{
+			name:              "explicit defaults",
+			vars:              []string{"ADDON_VERSION", "_REGION"},
+			defaults:          map[string]string{"ADDON_VERSION": "v1.2.3"},
+			wantSubstitutions: map[string]string{"_ADDON_VERSION": "v1.2.3", "_REGION": ""},
+			wantEnv:           []string{"BUILD_ID=$BUILD_ID", "PROJECT_ID=$PROJECT_ID", "ADDON_VERSION=${_ADDON_VERSION}", "_REGION=${_REGION}"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The environment of the generator must not leak into the
+			// config.
+			t.Setenv("ADDON_VERSION", "local")
+			t.Setenv("_REGION", "local")
+
+			build := GenerateCloudBuild(project, "addon.yaml", tt.vars, DefaultBuilderImage, tt.defaults)
+			if !reflect.DeepEqual(build.Substitutions, tt.wantSubstitutions) {
+				t.Errorf("substitutions = %v, want %v", build.Substitutions, tt.wantSubstitutions)
+			}
+			ids := make([]string, 0)
+			for _, step := range build.Steps {
+				ids = append(ids, step.ID)
+				if !reflect.DeepEqual(step.Env, tt.wantEnv) {
+					t.Errorf("env of step %v = %v, want %v", step.ID, step.Env, tt.wantEnv)
+				}
+				if want := []string{"run", step.ID, "--file", "addon.yaml"}; !reflect.DeepEqual(step.Args, want) {
+					t.Errorf("args of step %v = %v, want %v", step.ID, step.Args, want)
+				}
+			}
+			if want := []string{StepBuild, StepTagSuffix}; !reflect.DeepEqual(ids, want) {
+				t.Errorf("steps = %v, want %v", ids, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Credentials for a registry. Both fields are empty for anonymous access.
+type Credentials struct {
+	Username string
+	Secret   string
+}
+
+// dockerConfig is the subset of the docker CLI's config.json that deals with
+// registry credentials.
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth string `json:"auth"`
+	} `json:"auths"`
+	CredHelpers map[string]string `json:"credHelpers"`
+	CredsStore  string            `json:"credsStore"`
+}
+
+// dockerConfigPath returns the location of the docker CLI's config.json,
+// honoring $DOCKER_CONFIG.
+func dockerConfigPath() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".docker", "config.json")
+}
+
+// LookupCredentials finds the credentials for a registry host the same way the
+// docker CLI does: a per-registry credential helper ("credHelpers") takes
+// precedence over the default credential store ("credsStore"), which takes
+// precedence over static "auths" entries. Missing configuration results in
+// anonymous (empty) credentials rather than an error.
+func LookupCredentials(host string) (Credentials, error) {
+	path := dockerConfigPath()
+	if path == "" {
+		return Credentials{}, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Credentials{}, nil
+	} else if err != nil {
+		return Credentials{}, err
+	}
+
+	var config dockerConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return Credentials{}, fmt.Errorf("could not parse %v: %v", path, err)
+	}
+
+	// Docker Hub credentials are stored under the legacy index URL.
+	serverURL := host
+	if host == "registry-1.docker.io" {
+		serverURL = "https://index.docker.io/v1/"
+	}
+
+	if helper, ok := config.CredHelpers[host]; ok {
+		return credentialHelperGet(helper, serverURL)
+	}
+	if config.CredsStore != "" {
+		return credentialHelperGet(config.CredsStore, serverURL)
+	}
+	for server, auth := range config.Auths {
+		if server != serverURL && strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://") != host {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return Credentials{}, fmt.Errorf("invalid auth for %v in %v: %v", server, path, err)
+		}
+		userAndSecret := strings.SplitN(string(decoded), ":", 2)
+		if len(userAndSecret) != 2 {
+			return Credentials{}, fmt.Errorf("invalid auth for %v in %v (must be of the form 'user:secret')", server, path)
+		}
+		return Credentials{Username: userAndSecret[0], Secret: userAndSecret[1]}, nil
+	}
+	return Credentials{}, nil
+}
+
+// credentialHelperGet runs "docker-credential-<helper> get" as described in
+// [1].
+//
+// [1]: https://github.com/docker/docker-credential-helpers
+func credentialHelperGet(helper, serverURL string) (Credentials, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stdout.String() + stderr.String())
+		if strings.Contains(msg, "credentials not found") {
+			return Credentials{}, nil
+		}
+		return Credentials{}, fmt.Errorf("credential helper %v failed for %v: %v: %v", helper, serverURL, err, msg)
+	}
+
+	var creds struct {
+		Username string
+		Secret   string
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return Credentials{}, fmt.Errorf("could not parse output of credential helper %v: %v", helper, err)
+	}
+	return Credentials{Username: creds.Username, Secret: creds.Secret}, nil
+}
@@ -30,7 +30,7 @@ import (
 	"github.com/spf13/cobra"
 )
 
-func EditTagSuffixWrapper(cmd *cobra.Command, args []string, appendOrRemove bool) error {
+func EditTagSuffixWrapper(cmd *cobra.Command, args []string, appendOrRemove bool, platform string) error {
 	tagSuffix := args[1]
 
 	if tagSuffix == "" {
@@ -47,7 +47,7 @@ func EditTagSuffixWrapper(cmd *cobra.Command, args []string, appendOrRemove bool
 		return err
 	}
 
-	return editTagSuffix(dcli, tagSuffix, appendOrRemove, r)
+	return EditTagSuffix(dcli, tagSuffix, appendOrRemove, r, platform)
 }
 
 func GetImageAndTag(repoTag string) (string, string, error) {
@@ -91,6 +91,25 @@ func repoTagExists(dcli *client.Client, needle string) bool {
 	return false
 }
 
+// AppendTagSuffix returns the tag with "-<tagSuffix>" appended to it. If the
+// tag should be left alone instead, the returned skip reason is non-empty.
+func AppendTagSuffix(tag string, tagSuffix string) (string, string, error) {
+	// Skip implicit "latest" tag. Images should not be named
+	// "latest-<suffix>" (or seen another way, have a "latest-" tag
+	// prefix).
+	if tag == "latest" {
+		return "", fmt.Sprintf("avoid tagging '%v-%v'", tag, tagSuffix), nil
+	}
+	if strings.HasSuffix(tag, "-"+tagSuffix) {
+		return "", fmt.Sprintf("already has suffix '-%v'", tagSuffix), nil
+	}
+	var newTag string = tag + "-" + tagSuffix
+	if !isValidTag(newTag) {
+		return "", "", fmt.Errorf("new tag %v is invalid", newTag)
+	}
+	return newTag, "", nil
+}
+
 type TagOp struct {
 	From string
 	To   string
@@ -101,21 +120,14 @@ func appendTag(tagOps []TagOp, dcli *client.Client, tagSuffix string, repoTag st
 	if err != nil {
 		return tagOps, err
 	}
-	// Skip implicit "latest" tag. Images should not be named
-	// "latest-<suffix>" (or seen another way, have a "latest-" tag
-	// prefix).
-	if tag == "latest" {
-		fmt.Printf("skipping %v (avoid tagging '%v-%v')\n", repoTag, tag, tagSuffix)
-		return tagOps, nil
+	newTag, skipReason, err := AppendTagSuffix(tag, tagSuffix)
+	if err != nil {
+		return tagOps, err
 	}
-	if strings.HasSuffix(repoTag, "-"+tagSuffix) {
-		fmt.Printf("skipping %v (already has suffix '-%v')\n", repoTag, tagSuffix)
+	if skipReason != "" {
+		fmt.Printf("skipping %v (%v)\n", repoTag, skipReason)
 		return tagOps, nil
 	}
-	var newTag string = tag + "-" + tagSuffix
-	if !isValidTag(newTag) {
-		return tagOps, fmt.Errorf("new tag %v is invalid", newTag)
-	}
 	var newRepoTag string = imageName + ":" + newTag
 	if repoTagExists(dcli, newRepoTag) {
 		fmt.Printf("skipping %v (already suffixed to '-%v')\n", repoTag, tagSuffix)
@@ -140,38 +152,37 @@ func removeTag(tagOps []TagOp, dcli *client.Client, tagSuffix string, repoTag st
 	return tagOps, nil
 }
 
-func mkTaggingOperations(dcli *client.Client, tagSuffix string, r *regexp.Regexp, appendOrRemove bool) ([]TagOp, error) {
+func mkTaggingOperations(dcli *client.Client, tagSuffix string, r *regexp.Regexp, appendOrRemove bool, platform string) ([]TagOp, error) {
 	images, err := FindImages(dcli, r)
 	if err != nil {
 		return nil, err
 	}
+	images, err = images.FilterPlatform(platform)
+	if err != nil {
+		return nil, err
+	}
 
 	tagOps := make([]TagOp, 0)
-	for _, image := range images {
-		// Skip untagged (dangling) images.
-		if image.RepoTags[0] == "<none>:<none>" {
-			continue
+	// The images are keyed by their matching tags, so an image with several
+	// of them is visited once per tag.
+	for _, repoTag := range images.SortedNames() {
+		if appendOrRemove {
+			tagOps, err = appendTag(tagOps, dcli, tagSuffix, repoTag)
+		} else {
+			tagOps, err = removeTag(tagOps, dcli, tagSuffix, repoTag)
 		}
-		for _, repoTag := range image.RepoTags {
-			if !r.MatchString(repoTag) {
-				continue
-			}
-			if appendOrRemove {
-				tagOps, err = appendTag(tagOps, dcli, tagSuffix, repoTag)
-			} else {
-				tagOps, err = removeTag(tagOps, dcli, tagSuffix, repoTag)
-			}
-			if err != nil {
-				return nil, err
-			}
+		if err != nil {
+			return nil, err
 		}
 	}
 
 	return tagOps, nil
 }
 
-func editTagSuffix(dcli *client.Client, tagSuffix string, appendOrRemove bool, r *regexp.Regexp) error {
-	ops, err := mkTaggingOperations(dcli, tagSuffix, r, appendOrRemove)
+// EditTagSuffix appends a suffix to (or removes it from) the tags of the
+// images matching a regex.
+func EditTagSuffix(dcli *client.Client, tagSuffix string, appendOrRemove bool, r *regexp.Regexp, platform string) error {
+	ops, err := mkTaggingOperations(dcli, tagSuffix, r, appendOrRemove, platform)
 	if err != nil {
 		return err
 	}
@@ -182,7 +193,9 @@ func editTagSuffix(dcli *client.Client, tagSuffix string, appendOrRemove bool, r
 	}
 
 	for _, op := range ops {
-		return MoveTag(dcli, op)
+		if err := MoveTag(dcli, op); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -207,7 +220,15 @@ func MoveTag(dcli *client.Client, tagOp TagOp) error {
 	return nil
 }
 
-type ImageMap map[string]types.ImageSummary
+// Image is an image found in the daemon, along with the platform it was built
+// for and the user it runs as (which the image list API does not report).
+type Image struct {
+	types.ImageSummary
+	Platform Platform
+	User     string
+}
+
+type ImageMap map[string]Image
 
 func FindImages(dcli *client.Client, r *regexp.Regexp) (ImageMap, error) {
 	found := make(ImageMap)
@@ -220,16 +241,78 @@ func FindImages(dcli *client.Client, r *regexp.Regexp) (ImageMap, error) {
 		if len(image.RepoTags) == 0 || image.RepoTags[0] == "<none>:<none>" {
 			continue
 		}
+		var inspected *Image
 		for _, repoTag := range image.RepoTags {
-			if r.MatchString(repoTag) {
-				found[repoTag] = image
+			if !r.MatchString(repoTag) {
+				continue
+			}
+			if inspected == nil {
+				inspect, _, err := dcli.ImageInspectWithRaw(context.Background(), image.ID)
+				if err != nil {
+					return nil, err
+				}
+				inspected = &Image{
+					ImageSummary: image,
+					Platform:     Platform{Architecture: inspect.Architecture, OS: inspect.Os, Variant: inspect.Variant},
+				}
+				if inspect.Config != nil {
+					inspected.User = inspect.Config.User
+				}
 			}
+			found[repoTag] = *inspected
 		}
 	}
 
 	return found, nil
 }
 
+// ParsePlatform parses a platform of the form "os/arch[/variant]", as used by
+// "docker build --platform".
+func ParsePlatform(platform string) (Platform, error) {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return Platform{}, fmt.Errorf("invalid platform '%v' (must be of the form 'os/arch[/variant]')", platform)
+	}
+	for _, part := range parts {
+		if part == "" {
+			return Platform{}, fmt.Errorf("invalid platform '%v' (must be of the form 'os/arch[/variant]')", platform)
+		}
+	}
+	p := Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+// Matches reports whether an image built for p satisfies the wanted platform.
+// The variant is only compared if the wanted platform specifies one.
+func (p Platform) Matches(wanted Platform) bool {
+	if p.OS != wanted.OS || p.Architecture != wanted.Architecture {
+		return false
+	}
+	return wanted.Variant == "" || p.Variant == wanted.Variant
+}
+
+// FilterPlatform returns the images built for the given platform (of the form
+// "os/arch[/variant]"). An empty platform matches every image.
+func (images ImageMap) FilterPlatform(platform string) (ImageMap, error) {
+	if platform == "" {
+		return images, nil
+	}
+	wanted, err := ParsePlatform(platform)
+	if err != nil {
+		return nil, err
+	}
+	filtered := make(ImageMap)
+	for name, image := range images {
+		if image.Platform.Matches(wanted) {
+			filtered[name] = image
+		}
+	}
+	return filtered, nil
+}
+
 func BuildImage(dcli *client.Client, dockerFileContents []byte, labels map[string]string, tags []string) error {
 	buf := new(bytes.Buffer)
 	tw := tar.NewWriter(buf)
@@ -301,6 +384,15 @@ func MakeRegex(regex string) (*regexp.Regexp, error) {
 	return regexp.Compile(regex)
 }
 
+var unsafeFileCharsRegex = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// SafeFileName turns a name such as an image reference into a file name, by
+// replacing the characters other than letters, digits, '.', '_' and '-' with
+// '_'.
+func SafeFileName(name string) string {
+	return unsafeFileCharsRegex.ReplaceAllString(name, "_")
+}
+
 func (images ImageMap) SortedNames() []string {
 	imageNames := make([]string, 0)
 	for imageName, _ := range images {
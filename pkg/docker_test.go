@@ -0,0 +1,169 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// fakeDaemon serves the parts of the Docker Engine API used to list, inspect,
+// tag and untag images. images maps image IDs to their tags.
+type fakeDaemon struct {
+	mu     sync.Mutex
+	images map[string][]string
+}
+
+var daemonPathRegex = regexp.MustCompile(`^/v[0-9.]+/images/(.+?)(/json|/tag)?$`)
+
+func (d *fakeDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if r.URL.Path == "/_ping" {
+		return
+	}
+	m := daemonPathRegex.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	name, action := m[1], m[2]
+	if name == "json" && r.Method == http.MethodGet {
+		var summaries []types.ImageSummary
+		for id, tags := range d.images {
+			summaries = append(summaries, types.ImageSummary{ID: id, RepoTags: tags})
+		}
+		json.NewEncoder(w).Encode(summaries)
+		return
+	}
+	id := d.find(name)
+	if id == "" {
+		http.Error(w, `{"message": "no such image"}`, http.StatusNotFound)
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && action == "/json":
+		json.NewEncoder(w).Encode(types.ImageInspect{ID: id, Architecture: "amd64", Os: "linux"})
+	case r.Method == http.MethodPost && action == "/tag":
+		d.images[id] = append(d.images[id], r.URL.Query().Get("repo")+":"+r.URL.Query().Get("tag"))
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodDelete && action == "":
+		var tags []string
+		for _, tag := range d.images[id] {
+			if tag != name {
+				tags = append(tags, tag)
+			}
+		}
+		d.images[id] = tags
+		json.NewEncoder(w).Encode([]types.ImageDeleteResponseItem{{Untagged: name}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// find returns the ID of the image with the ID or tag name.
+func (d *fakeDaemon) find(name string) string {
+	for id, tags := range d.images {
+		if id == name {
+			return id
+		}
+		for _, tag := range tags {
+			if tag == name {
+				return id
+			}
+		}
+	}
+	return ""
+}
+
+func (d *fakeDaemon) tags() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var tags []string
+	for _, t := range d.images {
+		tags = append(tags, t...)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+func TestEditTagSuffix(t *testing.T) {
+	tests := []struct {
+		name           string
+		images         map[string][]string
+		regex          string
+		appendOrRemove bool
+		want           []string
+	}{
+		{
+			name: "append to every matching tag",
+			images: map[string][]string{
+				"sha256:1": {"addon-controller:v1", "addon-controller:latest"},
+				"sha256:2": {"addon-webhook:v1"},
+				"sha256:3": {"other:v1"},
+			},
+			regex:          "^addon-",
+			appendOrRemove: true,
+			want:           []string{"addon-controller:latest", "addon-controller:v1-gke.1", "addon-webhook:v1-gke.1", "other:v1"},
+		},
+		{
+			name: "append skips suffixed tags",
+			images: map[string][]string{
+				"sha256:1": {"addon-controller:v1-gke.1"},
+				"sha256:2": {"addon-webhook:v1", "addon-webhook:v1-gke.1"},
+			},
+			regex:          "^addon-",
+			appendOrRemove: true,
+			want:           []string{"addon-controller:v1-gke.1", "addon-webhook:v1", "addon-webhook:v1-gke.1"},
+		},
+		{
+			name: "remove from every matching tag",
+			images: map[string][]string{
+				"sha256:1": {"addon-controller:v1-gke.1"},
+				"sha256:2": {"addon-webhook:v1-gke.1"},
+				"sha256:3": {"addon-other:v1"},
+			},
+			regex: "^addon-",
+			want:  []string{"addon-controller:v1", "addon-other:v1", "addon-webhook:v1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			daemon := &fakeDaemon{images: tt.images}
+			server := httptest.NewServer(daemon)
+			defer server.Close()
+			dcli, err := client.NewClientWithOpts(client.WithHost("tcp://"+strings.TrimPrefix(server.URL, "http://")), client.WithVersion("1.41"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := EditTagSuffix(dcli, "gke.1", tt.appendOrRemove, regexp.MustCompile(tt.regex), ""); err != nil {
+				t.Fatal(err)
+			}
+			if got := daemon.tags(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tags = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
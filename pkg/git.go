@@ -0,0 +1,87 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// GitSource identifies the commit checked out in a git clone (as made by 'ply
+// git clone').
+type GitSource struct {
+	// URL is the URL of the "origin" remote, if any, without credentials.
+	URL    string
+	Commit string
+	// Time is the committer time of the commit.
+	Time time.Time
+}
+
+// ReadGitSource returns the commit at HEAD of the git repository in dir.
+func ReadGitSource(dir string) (*GitSource, error) {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not open git repository %v: %v", dir, err)
+	}
+	commit, err := headCommit(repo)
+	if err != nil {
+		return nil, err
+	}
+	source := &GitSource{
+		Commit: commit.Hash.String(),
+		Time:   commit.Committer.When.UTC(),
+	}
+	if remote, err := repo.Remote(git.DefaultRemoteName); err == nil && len(remote.Config().URLs) > 0 {
+		source.URL = redactGitURL(remote.Config().URLs[0])
+	}
+	return source, nil
+}
+
+// redactGitURL removes the credentials from a remote URL: the userinfo of
+// HTTP URLs (where tokens are often passed as the username) and the password
+// of others. SCP-like SSH addresses ("git@host:path") have none.
+func redactGitURL(remote string) string {
+	u, err := url.Parse(remote)
+	if err != nil || u.Scheme == "" || u.User == nil {
+		return remote
+	}
+	if u.Scheme == "http" || u.Scheme == "https" {
+		u.User = nil
+	} else {
+		u.User = url.User(u.User.Username())
+	}
+	return u.String()
+}
+
+func headCommit(repo *git.Repository) (*object.Commit, error) {
+	head, err := repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve HEAD: %v", err)
+	}
+	return repo.CommitObject(head.Hash())
+}
+
+// RepoName returns the name of a repository from its URL, i.e. its last path
+// element without the ".git" suffix; "git clone" uses it as the directory to
+// clone into.
+func RepoName(repoURL string) string {
+	parts := strings.Split(repoURL, "/")
+	return strings.TrimSuffix(parts[len(parts)-1], ".git")
+}
@@ -0,0 +1,419 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+)
+
+// GitAuth is the authentication used to access a git remote, and where it
+// comes from (e.g. "GIT_TOKEN" or "~/.netrc") to explain failures.
+type GitAuth struct {
+	Method transport.AuthMethod
+	Source string
+}
+
+// GitAuthProvider returns the authentication for a remote, or nil if it has
+// none to offer.
+type GitAuthProvider func(endpoint *transport.Endpoint) (*GitAuth, error)
+
+// GitAuthOptions configures the default authentication providers.
+type GitAuthOptions struct {
+	// SSHKey is a private key file for SSH remotes. The SSH agent and the
+	// default keys in ~/.ssh are used otherwise.
+	SSHKey string
+	// CredentialHelper is a git credential helper [1] to get HTTP credentials
+	// from, instead of the ones set in the git config ("credential.helper").
+	//
+	// [1]: https://git-scm.com/docs/gitcredentials
+	CredentialHelper string
+}
+
+// Providers returns the authentication providers, in the order they are
+// tried:
+//
+//   - SSH remotes: the SSHKey file (with the passphrase in
+//     $GIT_SSH_KEY_PASSWORD), the SSH agent, then ~/.ssh/id_{ed25519,ecdsa,rsa}.
+//   - HTTP remotes: $GIT_TOKEN (with $GIT_USERNAME if the server needs one)
+//     or $GIT_USERNAME/$GIT_PASSWORD if the host is in $GIT_TOKEN_HOST, then
+//     the ~/.netrc (or $NETRC) entry of the host, then the credential helpers
+//     (a helper that fails has no credentials).
+func (o GitAuthOptions) Providers() []GitAuthProvider {
+	return []GitAuthProvider{
+		o.sshAuth,
+		envAuth,
+		netrcAuth,
+		o.credentialHelperAuth,
+	}
+}
+
+// ResolveGitAuth returns the authentication of the first provider that has
+// one for the remote at url, or nil to access it anonymously. Passwords are
+// not sent over plain HTTP, except to the local host.
+func ResolveGitAuth(url string, providers []GitAuthProvider) (*GitAuth, error) {
+	endpoint, err := transport.NewEndpoint(url)
+	if err != nil {
+		return nil, err
+	}
+	for _, provider := range providers {
+		auth, err := provider(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		if auth == nil {
+			continue
+		}
+		if _, basic := auth.Method.(*http.BasicAuth); basic && endpoint.Protocol == "http" && !isLocalHost(endpoint.Host) {
+			return nil, fmt.Errorf("%v: refusing to send the credentials from %v over plain HTTP; use https", url, auth.Source)
+		}
+		return auth, nil
+	}
+	return nil, nil
+}
+
+// remoteAuth resolves the authentication of a remote when it is needed: HTTP
+// remotes are accessed anonymously until they refuse, so that public
+// repositories never get credentials meant for others. SSH remotes, and the
+// hosts of $GIT_TOKEN_HOST, which are listed to get credentials, get them
+// from the start.
+type remoteAuth struct {
+	url       string
+	providers []GitAuthProvider
+	auth      *GitAuth
+	resolved  bool
+}
+
+func newRemoteAuth(url string, providers []GitAuthProvider) *remoteAuth {
+	return &remoteAuth{url: url, providers: providers}
+}
+
+// do runs an operation on the remote, anonymously first for most HTTP
+// remotes, then with the credentials of the providers if the remote refuses
+// anonymous access. Hosts such as GitHub report private repositories as not
+// found, rather than requiring authentication.
+func (a *remoteAuth) do(op func(method transport.AuthMethod) error) error {
+	if a == nil {
+		return op(nil)
+	}
+	if !a.resolved {
+		if endpoint, err := transport.NewEndpoint(a.url); err == nil && (!isHTTP(endpoint) || envAuthHost(endpoint)) {
+			if err := a.resolve(); err != nil {
+				return err
+			}
+		}
+	}
+	err := op(authMethod(a.current()))
+	if a.resolved || !anonymousRefused(err) {
+		return err
+	}
+	if err := a.resolve(); err != nil {
+		return err
+	}
+	if a.auth == nil {
+		return err
+	}
+	return op(a.auth.Method)
+}
+
+// anonymousRefused reports whether an anonymous operation may succeed with
+// credentials.
+func anonymousRefused(err error) bool {
+	return errors.Is(err, transport.ErrAuthenticationRequired) ||
+		errors.Is(err, transport.ErrAuthorizationFailed) ||
+		errors.Is(err, transport.ErrRepositoryNotFound)
+}
+
+func (a *remoteAuth) resolve() error {
+	auth, err := ResolveGitAuth(a.url, a.providers)
+	if err != nil {
+		return err
+	}
+	a.auth, a.resolved = auth, true
+	return nil
+}
+
+// current returns the authentication used so far, nil if anonymous.
+func (a *remoteAuth) current() *GitAuth {
+	if a == nil {
+		return nil
+	}
+	return a.auth
+}
+
+// authError explains an authentication failure with a remote.
+func authError(url string, auth *GitAuth, err error) error {
+	failed := errors.Is(err, transport.ErrAuthenticationRequired) ||
+		errors.Is(err, transport.ErrAuthorizationFailed) ||
+		strings.Contains(err.Error(), "unable to authenticate")
+	// Hosts such as GitHub report private repositories as not found to
+	// anonymous users.
+	if auth == nil && errors.Is(err, transport.ErrRepositoryNotFound) {
+		failed = true
+	}
+	if !failed {
+		return fmt.Errorf("%v: %w", url, err)
+	}
+	if auth == nil {
+		return fmt.Errorf("%v: %v (no credentials found: set GIT_TOKEN or GIT_USERNAME/GIT_PASSWORD along with GIT_TOKEN_HOST, add the host to ~/.netrc, configure a git credential helper, or use an SSH key or agent)", url, err)
+	}
+	return fmt.Errorf("%v: %v (with the credentials from %v)", url, err, auth.Source)
+}
+
+func isSSH(endpoint *transport.Endpoint) bool {
+	return endpoint.Protocol == "ssh"
+}
+
+func isHTTP(endpoint *transport.Endpoint) bool {
+	return endpoint.Protocol == "http" || endpoint.Protocol == "https"
+}
+
+func (o GitAuthOptions) sshAuth(endpoint *transport.Endpoint) (*GitAuth, error) {
+	if !isSSH(endpoint) {
+		return nil, nil
+	}
+	user := endpoint.User
+	if user == "" {
+		user = "git"
+	}
+	if o.SSHKey != "" {
+		keys, err := ssh.NewPublicKeysFromFile(user, o.SSHKey, os.Getenv("GIT_SSH_KEY_PASSWORD"))
+		if err != nil {
+			return nil, fmt.Errorf("could not read SSH key %v: %v", o.SSHKey, err)
+		}
+		return &GitAuth{Method: keys, Source: o.SSHKey}, nil
+	}
+	if os.Getenv("SSH_AUTH_SOCK") != "" {
+		agent, err := ssh.NewSSHAgentAuth(user)
+		if err != nil {
+			return nil, fmt.Errorf("could not use the SSH agent: %v", err)
+		}
+		return &GitAuth{Method: agent, Source: "the SSH agent"}, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, nil
+	}
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		path := filepath.Join(home, ".ssh", name)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		keys, err := ssh.NewPublicKeysFromFile(user, path, os.Getenv("GIT_SSH_KEY_PASSWORD"))
+		if err != nil {
+			return nil, fmt.Errorf("could not read SSH key %v: %v", path, err)
+		}
+		return &GitAuth{Method: keys, Source: path}, nil
+	}
+	return nil, nil
+}
+
+// envAuth returns the credentials of the environment, for the hosts listed
+// (as HOST or HOST:PORT, comma-separated) in $GIT_TOKEN_HOST only.
+func envAuth(endpoint *transport.Endpoint) (*GitAuth, error) {
+	if !isHTTP(endpoint) || !envAuthHost(endpoint) {
+		return nil, nil
+	}
+	username := os.Getenv("GIT_USERNAME")
+	if token := os.Getenv("GIT_TOKEN"); token != "" {
+		// Servers that take tokens as passwords accept any username but
+		// need one.
+		if username == "" {
+			username = "git"
+		}
+		return &GitAuth{Method: &http.BasicAuth{Username: username, Password: token}, Source: "GIT_TOKEN"}, nil
+	}
+	if password := os.Getenv("GIT_PASSWORD"); username != "" && password != "" {
+		return &GitAuth{Method: &http.BasicAuth{Username: username, Password: password}, Source: "GIT_USERNAME/GIT_PASSWORD"}, nil
+	}
+	return nil, nil
+}
+
+func envAuthHost(endpoint *transport.Endpoint) bool {
+	for _, host := range strings.Split(os.Getenv("GIT_TOKEN_HOST"), ",") {
+		host = strings.TrimSpace(host)
+		if host != "" && (host == endpoint.Host || host == fmt.Sprintf("%v:%v", endpoint.Host, endpoint.Port)) {
+			return true
+		}
+	}
+	return false
+}
+
+func netrcAuth(endpoint *transport.Endpoint) (*GitAuth, error) {
+	if !isHTTP(endpoint) {
+		return nil, nil
+	}
+	path := os.Getenv("NETRC")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		path = filepath.Join(home, ".netrc")
+	}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	login, password, ok := lookupNetrc(string(content), endpoint.Host)
+	if !ok {
+		return nil, nil
+	}
+	return &GitAuth{Method: &http.BasicAuth{Username: login, Password: password}, Source: path}, nil
+}
+
+// lookupNetrc returns the login and password of a machine in a netrc file
+// [1], or of its "default" entry.
+//
+// [1]: https://www.gnu.org/software/inetutils/manual/html_node/The-_002enetrc-file.html
+func lookupNetrc(content, host string) (login, password string, ok bool) {
+	type entry struct {
+		login, password string
+	}
+	entries := make(map[string]*entry)
+	var current *entry
+	tokens := strings.Fields(content)
+	for i := 0; i < len(tokens); i++ {
+		var value string
+		if i+1 < len(tokens) {
+			value = tokens[i+1]
+		}
+		switch tokens[i] {
+		case "machine":
+			current = &entry{}
+			if _, seen := entries[value]; !seen {
+				entries[value] = current
+			}
+			i++
+		case "default":
+			current = &entry{}
+			entries[""] = current
+		case "login", "password", "account":
+			if current != nil && tokens[i] == "login" {
+				current.login = value
+			} else if current != nil && tokens[i] == "password" {
+				current.password = value
+			}
+			i++
+		case "macdef":
+			// Macro bodies end with an empty line, which Fields loses, and
+			// the default entry must come last anyway.
+			i = len(tokens)
+		}
+	}
+	for _, name := range []string{host, ""} {
+		if e, found := entries[name]; found {
+			return e.login, e.password, true
+		}
+	}
+	return "", "", false
+}
+
+// credentialHelpers returns the credential helpers set in the system and
+// global git config, in the order git tries them.
+func credentialHelpers() []string {
+	helpers := make([]string, 0)
+	for _, scope := range []config.Scope{config.SystemScope, config.GlobalScope} {
+		cfg, err := config.LoadConfig(scope)
+		if err != nil {
+			continue
+		}
+		for _, helper := range cfg.Raw.Section("credential").Options.GetAll("helper") {
+			if helper == "" {
+				// An empty helper resets the list.
+				helpers = helpers[:0]
+				continue
+			}
+			helpers = append(helpers, helper)
+		}
+	}
+	return helpers
+}
+
+func (o GitAuthOptions) credentialHelperAuth(endpoint *transport.Endpoint) (*GitAuth, error) {
+	if !isHTTP(endpoint) {
+		return nil, nil
+	}
+	helpers := credentialHelpers()
+	if o.CredentialHelper != "" {
+		helpers = []string{o.CredentialHelper}
+	}
+	for _, helper := range helpers {
+		// Like git, carry on without the credentials of a helper that
+		// fails.
+		username, password, err := credentialHelperFill(helper, endpoint)
+		if err == nil && password != "" {
+			return &GitAuth{
+				Method: &http.BasicAuth{Username: username, Password: password},
+				Source: "credential helper " + helper,
+			}, nil
+		}
+	}
+	return nil, nil
+}
+
+// credentialHelperFill runs "git-credential-<helper> get" (or the shell
+// command of a "!command" helper, or the helper itself if it is a path) and
+// returns the credentials it answers with, as git does.
+func credentialHelperFill(helper string, endpoint *transport.Endpoint) (username, password string, err error) {
+	var cmd *exec.Cmd
+	switch {
+	case strings.HasPrefix(helper, "!"):
+		cmd = exec.Command("sh", "-c", helper[1:]+" get")
+	case filepath.IsAbs(helper):
+		cmd = exec.Command("sh", "-c", helper+" get")
+	default:
+		cmd = exec.Command("sh", "-c", "git-credential-"+helper+" get")
+	}
+	host := endpoint.Host
+	if endpoint.Port != 0 {
+		host = fmt.Sprintf("%v:%v", host, endpoint.Port)
+	}
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("protocol=%v\nhost=%v\n\n", endpoint.Protocol, host))
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", "", fmt.Errorf("credential helper %v failed for %v: %v: %v", helper, host, err, strings.TrimSpace(stderr.String()))
+	}
+	scanner := bufio.NewScanner(&stdout)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "username":
+			username = kv[1]
+		case "password":
+			password = kv[1]
+		}
+	}
+	return username, password, nil
+}
@@ -0,0 +1,204 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+func TestEnvAuth(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		url    string
+		hosts  string
+		source string
+	}{
+		{name: "no host", url: "https://github.com/org/repo"},
+		{name: "other host", url: "https://github.com/org/repo", hosts: "git.example.com"},
+		{name: "host", url: "https://github.com/org/repo", hosts: "git.example.com, github.com", source: "GIT_TOKEN"},
+		{name: "host and port", url: "https://git.example.com:8443/repo", hosts: "git.example.com:8443", source: "GIT_TOKEN"},
+		{name: "other port", url: "https://git.example.com:8443/repo", hosts: "git.example.com:443"},
+		{name: "ssh", url: "git@github.com:org/repo", hosts: "github.com"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("GIT_TOKEN", "token")
+			t.Setenv("GIT_TOKEN_HOST", test.hosts)
+			endpoint, err := transport.NewEndpoint(test.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			auth, err := envAuth(endpoint)
+			if err != nil {
+				t.Fatal(err)
+			}
+			source := ""
+			if auth != nil {
+				source = auth.Source
+			}
+			if source != test.source {
+				t.Errorf("envAuth(%v) = %q, want %q", test.url, source, test.source)
+			}
+		})
+	}
+}
+
+func TestResolveGitAuth(t *testing.T) {
+	basic := func(endpoint *transport.Endpoint) (*GitAuth, error) {
+		return &GitAuth{Method: &http.BasicAuth{Username: "user", Password: "password"}, Source: "test"}, nil
+	}
+	none := func(endpoint *transport.Endpoint) (*GitAuth, error) {
+		return nil, nil
+	}
+	failingHelper := GitAuthOptions{CredentialHelper: "!exit 1"}.credentialHelperAuth
+
+	for _, test := range []struct {
+		name      string
+		url       string
+		providers []GitAuthProvider
+		source    string
+		wantErr   bool
+	}{
+		{name: "https", url: "https://github.com/org/repo", providers: []GitAuthProvider{none, basic}, source: "test"},
+		{name: "http", url: "http://git.example.com/repo", providers: []GitAuthProvider{basic}, wantErr: true},
+		{name: "http localhost", url: "http://localhost:8080/repo", providers: []GitAuthProvider{basic}, source: "test"},
+		{name: "http loopback", url: "http://127.0.0.1:8080/repo", providers: []GitAuthProvider{basic}, source: "test"},
+		{name: "failing credential helper", url: "https://github.com/org/repo", providers: []GitAuthProvider{failingHelper}},
+		{name: "none", url: "https://github.com/org/repo", providers: []GitAuthProvider{none}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			auth, err := ResolveGitAuth(test.url, test.providers)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("ResolveGitAuth(%v) = %v, want an error", test.url, auth)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			source := ""
+			if auth != nil {
+				source = auth.Source
+			}
+			if source != test.source {
+				t.Errorf("ResolveGitAuth(%v) = %q, want %q", test.url, source, test.source)
+			}
+		})
+	}
+}
+
+func TestRemoteAuth(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		url  string
+		// errs are the errors of the successive attempts.
+		errs          []error
+		wantAttempts  int
+		wantResolved  int
+		wantAuth      bool
+		wantErr       bool
+		withoutCreds  bool
+		secondAttempt bool
+		// tokenHost is $GIT_TOKEN_HOST.
+		tokenHost string
+	}{
+		{name: "public", url: "https://github.com/org/repo", errs: []error{nil}, wantAttempts: 1},
+		{name: "not found", url: "https://github.com/org/repo", errs: []error{transport.ErrRepositoryNotFound, transport.ErrRepositoryNotFound}, wantAttempts: 2, wantResolved: 1, wantAuth: true, wantErr: true},
+		{name: "private reported as not found", url: "https://github.com/org/repo", errs: []error{transport.ErrRepositoryNotFound, nil}, wantAttempts: 2, wantResolved: 1, wantAuth: true},
+		{name: "not found without credentials", url: "https://github.com/org/repo", errs: []error{transport.ErrRepositoryNotFound}, wantAttempts: 1, wantResolved: 1, wantErr: true, withoutCreds: true},
+		{name: "forbidden", url: "https://github.com/org/repo", errs: []error{transport.ErrAuthorizationFailed, nil}, wantAttempts: 2, wantResolved: 1, wantAuth: true},
+		{name: "other error", url: "https://github.com/org/repo", errs: []error{errors.New("connection refused")}, wantAttempts: 1, wantErr: true},
+		{name: "token host", url: "https://github.com/org/repo", errs: []error{nil}, wantAttempts: 1, wantResolved: 1, wantAuth: true, tokenHost: "github.com"},
+		{name: "other token host", url: "https://gitlab.com/org/repo", errs: []error{nil}, wantAttempts: 1, tokenHost: "github.com"},
+		{name: "private", url: "https://github.com/org/repo", errs: []error{transport.ErrAuthenticationRequired, nil}, wantAttempts: 2, wantResolved: 1, wantAuth: true},
+		{name: "no credentials", url: "https://github.com/org/repo", errs: []error{transport.ErrAuthenticationRequired}, wantAttempts: 1, wantResolved: 1, wantErr: true, withoutCreds: true},
+		{name: "ssh", url: "git@github.com:org/repo", errs: []error{nil}, wantAttempts: 1, wantResolved: 1, wantAuth: true},
+		{name: "second operation", url: "https://github.com/org/repo", errs: []error{transport.ErrAuthenticationRequired, nil, nil}, wantAttempts: 3, wantResolved: 1, wantAuth: true, secondAttempt: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("GIT_TOKEN_HOST", test.tokenHost)
+			resolved := 0
+			provider := func(endpoint *transport.Endpoint) (*GitAuth, error) {
+				resolved++
+				if test.withoutCreds {
+					return nil, nil
+				}
+				return &GitAuth{Method: &http.BasicAuth{Username: "user", Password: "password"}, Source: "test"}, nil
+			}
+			auth := newRemoteAuth(test.url, []GitAuthProvider{provider})
+
+			attempts := 0
+			var methods []transport.AuthMethod
+			op := func(method transport.AuthMethod) error {
+				err := test.errs[attempts]
+				attempts++
+				methods = append(methods, method)
+				return err
+			}
+			err := auth.do(op)
+			if test.secondAttempt && err == nil {
+				err = auth.do(op)
+			}
+			if (err != nil) != test.wantErr {
+				t.Fatalf("do() = %v, want error: %v", err, test.wantErr)
+			}
+			if attempts != test.wantAttempts {
+				t.Errorf("got %v attempts, want %v", attempts, test.wantAttempts)
+			}
+			if resolved != test.wantResolved {
+				t.Errorf("providers asked %v times, want %v", resolved, test.wantResolved)
+			}
+			if got := auth.current() != nil; got != test.wantAuth {
+				t.Errorf("current() = %v, want credentials: %v", auth.current(), test.wantAuth)
+			}
+			upFront := test.tokenHost != "" && strings.Contains(test.url, "//"+test.tokenHost+"/")
+			if strings.HasPrefix(test.url, "https:") && !upFront && methods[0] != nil {
+				t.Errorf("first attempt used %v, want anonymous", methods[0])
+			}
+			if test.wantErr && !errors.Is(err, test.errs[len(test.errs)-1]) {
+				t.Errorf("do() = %v, want %v", err, test.errs[len(test.errs)-1])
+			}
+		})
+	}
+}
+
+func TestLookupNetrc(t *testing.T) {
+	content := `machine github.com login alice password secret
+machine git.example.com
+  login bob
+  password hunter2
+default login anonymous password guest
+`
+	for _, test := range []struct {
+		host, login, password string
+	}{
+		{"github.com", "alice", "secret"},
+		{"git.example.com", "bob", "hunter2"},
+		{"gitlab.com", "anonymous", "guest"},
+	} {
+		login, password, ok := lookupNetrc(content, test.host)
+		if !ok || login != test.login || password != test.password {
+			t.Errorf("lookupNetrc(%v) = %v, %v, %v, want %v, %v", test.host, login, password, ok, test.login, test.password)
+		}
+	}
+	if _, _, ok := lookupNetrc("machine github.com login alice password secret", "gitlab.com"); ok {
+		t.Errorf("lookupNetrc(gitlab.com) found an entry without a default")
+	}
+}
@@ -0,0 +1,543 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// GitCloneOptions configures CloneRepository and CheckoutRevision.
+type GitCloneOptions struct {
+	// Rev is the revision to check out, as understood by "git rev-parse": a
+	// branch (of the origin remote if there is no local one), a tag, a full or
+	// abbreviated commit hash, or any of these followed by "~N", "^N" or
+	// "^{}". It defaults to the remote HEAD.
+	Rev string
+	// Depth truncates the history to that many commits (0 for all of it).
+	Depth int
+	// SingleBranch only fetches the branch (or tag) that is checked out.
+	SingleBranch bool
+	// Filter is a partial clone filter, e.g. "blob:none". go-git cannot make
+	// partial clones, so these are made with the git binary, given the HTTP
+	// credentials of Auth and its SSH key (which must not need a passphrase).
+	Filter string
+	// SparsePaths restricts the checkout to these directories.
+	SparsePaths []string
+	Auth        GitAuthOptions
+}
+
+var hashRegex = regexp.MustCompile("^[a-fA-F0-9]{40}$")
+var hashPrefixRegex = regexp.MustCompile("^[a-fA-F0-9]{4,40}$")
+
+// IsHash reports whether rev is a full commit hash.
+func IsHash(rev string) bool {
+	return hashRegex.MatchString(rev)
+}
+
+// revisionName returns the branch, tag or hash a revision starts from, e.g.
+// "v1.2.3" for "v1.2.3^{}".
+func revisionName(rev string) string {
+	if i := strings.IndexAny(rev, "^~@:"); i >= 0 {
+		return rev[:i]
+	}
+	return rev
+}
+
+// isRefName reports whether a revision is just the name of a branch or tag.
+func isRefName(rev string) bool {
+	return rev != "" && revisionName(rev) == rev && !hashPrefixRegex.MatchString(rev)
+}
+
+// CloneRepository clones a git repository into dir and checks out
+// opts.Rev. If that fails, dir is left as it was: removed if the clone
+// created it, emptied if it was empty.
+func CloneRepository(url, dir string, opts GitCloneOptions) error {
+	entries, statErr := ioutil.ReadDir(dir)
+	err := cloneRepository(url, dir, opts)
+	if err != nil {
+		if os.IsNotExist(statErr) {
+			os.RemoveAll(dir)
+		} else if statErr == nil && len(entries) == 0 {
+			emptyDir(dir)
+		}
+	}
+	return err
+}
+
+func emptyDir(dir string) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		os.RemoveAll(filepath.Join(dir, entry.Name()))
+	}
+}
+
+func cloneRepository(url, dir string, opts GitCloneOptions) error {
+	if opts.Filter != "" {
+		return cloneWithGit(url, dir, opts)
+	}
+	auth := newRemoteAuth(url, opts.Auth.Providers())
+	cloneOptions := &git.CloneOptions{
+		URL:          url,
+		Depth:        opts.Depth,
+		SingleBranch: opts.SingleBranch,
+		// Checked out below, possibly sparsely.
+		NoCheckout: true,
+	}
+	if isRefName(opts.Rev) {
+		// Clone the branch or tag itself, which matters for shallow and
+		// single-branch clones.
+		remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+			Name: git.DefaultRemoteName,
+			URLs: []string{url},
+		})
+		var err error
+		if cloneOptions.ReferenceName, err = remoteReference(remote, auth, opts.Rev); err != nil {
+			return err
+		}
+	}
+	var repo *git.Repository
+	err := auth.do(func(method transport.AuthMethod) error {
+		cloneOptions.Auth = method
+		var err error
+		repo, err = git.PlainClone(dir, false, cloneOptions)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not clone %v", authError(url, auth.current(), err))
+	}
+	if opts.Rev == "" || cloneOptions.ReferenceName.IsBranch() {
+		// HEAD is the branch to check out.
+		head, err := repo.Head()
+		if err != nil {
+			return fmt.Errorf("could not resolve HEAD: %v", err)
+		}
+		return checkout(repo, &git.CheckoutOptions{Branch: head.Name()}, opts)
+	}
+	return checkoutRevision(repo, auth, opts)
+}
+
+// CheckoutRevision checks out opts.Rev in the git repository in dir, fetching
+// it from the origin remote if it is not there (e.g. in a shallow clone).
+func CheckoutRevision(dir string, opts GitCloneOptions) error {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return fmt.Errorf("could not open git repository %v: %v", dir, err)
+	}
+	if opts.Rev == "" {
+		return nil
+	}
+	var auth *remoteAuth
+	if remote, err := repo.Remote(git.DefaultRemoteName); err == nil && len(remote.Config().URLs) > 0 {
+		auth = newRemoteAuth(remote.Config().URLs[0], opts.Auth.Providers())
+	}
+	return checkoutRevision(repo, auth, opts)
+}
+
+func checkoutRevision(repo *git.Repository, auth *remoteAuth, opts GitCloneOptions) error {
+	fmt.Println("checking out revision:", opts.Rev)
+	hash, err := resolveRevision(repo, opts.Rev)
+	if err != nil {
+		if err := fetchRevision(repo, auth, opts); err != nil {
+			return err
+		}
+		if hash, err = resolveRevision(repo, opts.Rev); err != nil {
+			return fmt.Errorf("could not resolve revision %v: %v", opts.Rev, err)
+		}
+	}
+	// Stay on a local branch rather than detaching HEAD.
+	branch := plumbing.NewBranchReferenceName(opts.Rev)
+	if ref, err := repo.Reference(branch, true); err == nil && ref.Hash() == hash {
+		return checkout(repo, &git.CheckoutOptions{Branch: branch}, opts)
+	}
+	return checkout(repo, &git.CheckoutOptions{Hash: hash}, opts)
+}
+
+// resolveRevision returns the commit a revision points to, peeling tags. Like
+// "git checkout", it falls back to the branches of the origin remote.
+func resolveRevision(repo *git.Repository, rev string) (plumbing.Hash, error) {
+	hash, err := repo.ResolveRevision(plumbing.Revision(rev))
+	if err == nil {
+		return *hash, nil
+	}
+	if remoteHash, remoteErr := repo.ResolveRevision(plumbing.Revision(git.DefaultRemoteName + "/" + rev)); remoteErr == nil {
+		return *remoteHash, nil
+	}
+	return plumbing.ZeroHash, err
+}
+
+// fetchRevision fetches the branch, tag or commit a revision starts from.
+func fetchRevision(repo *git.Repository, auth *remoteAuth, opts GitCloneOptions) error {
+	name := revisionName(opts.Rev)
+	if IsHash(name) {
+		// Fetch just that commit, which servers only allow if they advertise
+		// allow-reachable-sha1-in-want (or allow-tip-sha1-in-want).
+		refSpec := config.RefSpec(fmt.Sprintf("%v:refs/ply/rev", name))
+		err := fetch(repo, auth, refSpec, opts.Depth)
+		if errors.Is(err, git.ErrExactSHA1NotSupported) {
+			return fmt.Errorf("commit %v is not in the clone and the server does not allow fetching it by hash; clone without --depth/--single-branch", name)
+		}
+		return err
+	}
+	if hashPrefixRegex.MatchString(name) {
+		return fmt.Errorf("revision %v is not in the clone, and abbreviated hashes cannot be fetched; use the full hash", opts.Rev)
+	}
+
+	remote, err := repo.Remote(git.DefaultRemoteName)
+	if err != nil {
+		return fmt.Errorf("revision %v is not in the repository, which has no %v remote to fetch it from", opts.Rev, git.DefaultRemoteName)
+	}
+	ref, err := remoteReference(remote, auth, name)
+	if err != nil {
+		return err
+	}
+	switch {
+	case ref.IsBranch():
+		return fetch(repo, auth, config.RefSpec(fmt.Sprintf("+%v:%v", ref, plumbing.NewRemoteReferenceName(git.DefaultRemoteName, name))), opts.Depth)
+	case ref.IsTag():
+		return fetch(repo, auth, config.RefSpec(fmt.Sprintf("+%v:%v", ref, ref)), opts.Depth)
+	}
+	return fmt.Errorf("revision %v not found: %v is not a branch or tag of %v", opts.Rev, name, remote.Config().URLs[0])
+}
+
+// remoteReference returns the branch or tag of a remote with the given name,
+// or "" if there is none.
+func remoteReference(remote *git.Remote, auth *remoteAuth, name string) (plumbing.ReferenceName, error) {
+	var refs []*plumbing.Reference
+	err := auth.do(func(method transport.AuthMethod) error {
+		var err error
+		refs, err = remote.List(&git.ListOptions{Auth: method})
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not list the references of %v", authError(remote.Config().URLs[0], auth.current(), err))
+	}
+	for _, candidate := range []plumbing.ReferenceName{plumbing.NewBranchReferenceName(name), plumbing.NewTagReferenceName(name)} {
+		for _, ref := range refs {
+			if ref.Name() == candidate {
+				return candidate, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+func fetch(repo *git.Repository, auth *remoteAuth, refSpec config.RefSpec, depth int) error {
+	err := auth.do(func(method transport.AuthMethod) error {
+		return repo.Fetch(&git.FetchOptions{
+			RefSpecs: []config.RefSpec{refSpec},
+			Depth:    depth,
+			Auth:     method,
+		})
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return fmt.Errorf("could not fetch %v: %w", refSpec, authError(git.DefaultRemoteName, auth.current(), err))
+	}
+	return nil
+}
+
+func authMethod(auth *GitAuth) transport.AuthMethod {
+	if auth == nil {
+		return nil
+	}
+	return auth.Method
+}
+
+func checkout(repo *git.Repository, checkoutOptions *git.CheckoutOptions, opts GitCloneOptions) error {
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	if len(opts.SparsePaths) == 0 {
+		return worktree.Checkout(checkoutOptions)
+	}
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return err
+	}
+	if len(idx.Entries) == 0 {
+		return sparseCheckout(repo, worktree, checkoutOptions, opts.SparsePaths)
+	}
+	// go-git removes the files outside of the directories from an existing
+	// checkout.
+	sparse := *checkoutOptions
+	sparse.SparseCheckoutDirectories = opts.SparsePaths
+	return worktree.Checkout(&sparse)
+}
+
+// sparseCheckout checks out only the files of some directories into the
+// empty worktree of a fresh clone. The other files are in the index, marked
+// skip-worktree like "git sparse-checkout" does. go-git cannot do this itself:
+// its sparse checkout only works on a worktree that has the files, as it
+// removes them.
+func sparseCheckout(repo *git.Repository, worktree *git.Worktree, checkoutOptions *git.CheckoutOptions, dirs []string) error {
+	// Only move HEAD.
+	head := *checkoutOptions
+	head.Keep = true
+	if err := worktree.Checkout(&head); err != nil {
+		return err
+	}
+	ref, err := repo.Head()
+	if err != nil {
+		return err
+	}
+	commit, err := repo.CommitObject(ref.Hash())
+	if err != nil {
+		return err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+
+	idx := &index.Index{Version: 2}
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	for {
+		name, entry, err := walker.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if entry.Mode == filemode.Dir {
+			continue
+		}
+		e := &index.Entry{Name: name, Hash: entry.Hash, Mode: entry.Mode}
+		if !inDirs(name, dirs) {
+			// Skip-worktree entries need version 3.
+			e.SkipWorktree = true
+			idx.Version = 3
+		} else if err := checkoutFile(repo, worktree, name, entry, e); err != nil {
+			return fmt.Errorf("could not check out %v: %v", name, err)
+		}
+		idx.Entries = append(idx.Entries, e)
+	}
+	return repo.Storer.SetIndex(idx)
+}
+
+// inDirs reports whether a path is in one of the directories.
+func inDirs(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		dir = strings.Trim(dir, "/")
+		if path == dir || strings.HasPrefix(path, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkoutFile writes a file (or symlink, or the directory of a submodule) of
+// a tree to the worktree, and records its size and time in its index entry.
+func checkoutFile(repo *git.Repository, worktree *git.Worktree, name string, entry object.TreeEntry, e *index.Entry) error {
+	fs := worktree.Filesystem
+	if entry.Mode == filemode.Submodule {
+		return fs.MkdirAll(name, 0755)
+	}
+	blob, err := repo.BlobObject(entry.Hash)
+	if err != nil {
+		return err
+	}
+	r, err := blob.Reader()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if entry.Mode == filemode.Symlink {
+		target, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if err := fs.Symlink(string(target), name); err != nil {
+			return err
+		}
+	} else {
+		mode, err := entry.Mode.ToOSFileMode()
+		if err != nil {
+			return err
+		}
+		f, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, r); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	info, err := fs.Lstat(name)
+	if err != nil {
+		return err
+	}
+	e.ModifiedAt = info.ModTime()
+	e.Size = uint32(info.Size())
+	return nil
+}
+
+// cloneWithGit clones with the git binary, for the options go-git lacks.
+func cloneWithGit(url, dir string, opts GitCloneOptions) error {
+	auth, err := newGitCommandAuth(url, opts.Auth)
+	if err != nil {
+		return err
+	}
+	args := []string{"clone", "--filter=" + opts.Filter}
+	if opts.Depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(opts.Depth))
+	}
+	if opts.SingleBranch {
+		args = append(args, "--single-branch")
+	}
+	if isRefName(opts.Rev) {
+		args = append(args, "--branch", opts.Rev)
+	}
+	if len(opts.SparsePaths) > 0 {
+		args = append(args, "--sparse")
+	}
+	if err := runGit("", auth, append(args, "--end-of-options", url, dir)...); err != nil {
+		return err
+	}
+	if len(opts.SparsePaths) > 0 {
+		if err := runGit(dir, auth, append([]string{"sparse-checkout", "set", "--end-of-options"}, opts.SparsePaths...)...); err != nil {
+			return err
+		}
+	}
+	if opts.Rev == "" || isRefName(opts.Rev) {
+		return nil
+	}
+	fmt.Println("checking out revision:", opts.Rev)
+	rev := opts.Rev
+	if runGit(dir, auth, "rev-parse", "--verify", "--quiet", "--end-of-options", rev+"^{commit}") != nil {
+		name := revisionName(rev)
+		if !IsHash(name) && hashPrefixRegex.MatchString(name) {
+			return fmt.Errorf("revision %v is not in the clone, and abbreviated hashes cannot be fetched; use the full hash", opts.Rev)
+		}
+		args := []string{"fetch"}
+		if opts.Depth > 0 {
+			args = append(args, "--depth", strconv.Itoa(opts.Depth))
+		}
+		if err := runGit(dir, auth, append(args, "--end-of-options", git.DefaultRemoteName, name)...); err != nil {
+			return err
+		}
+		rev = "FETCH_HEAD" + strings.TrimPrefix(rev, name)
+	}
+	// git checkout does not take --end-of-options, but a hash is not an
+	// option.
+	hash, err := gitOutput(dir, auth, "rev-parse", "--verify", "--end-of-options", rev+"^{commit}")
+	if err != nil {
+		return err
+	}
+	return runGit(dir, auth, "checkout", "--quiet", hash)
+}
+
+// gitCredentialHelper is a credential helper answering with the credentials
+// in the environment of git, so that they are not in its arguments.
+const gitCredentialHelper = `!f() { test "$1" = get && printf 'username=%s\npassword=%s\n' "$PLY_GIT_USERNAME" "$PLY_GIT_PASSWORD"; }; f`
+
+// newGitCommandAuth returns the git config options and environment variables
+// that make the git binary use the credentials of the providers for the
+// remote at url: HTTP credentials through a credential helper for that
+// remote only (git still tries anonymously first), and the SSH key.
+func newGitCommandAuth(url string, opts GitAuthOptions) (*gitCommandAuth, error) {
+	commandAuth := &gitCommandAuth{}
+	endpoint, err := transport.NewEndpoint(url)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case isHTTP(endpoint):
+		auth, err := ResolveGitAuth(url, opts.Providers())
+		if err != nil {
+			return nil, err
+		}
+		basic, ok := authMethod(auth).(*http.BasicAuth)
+		if !ok {
+			return commandAuth, nil
+		}
+		host := endpoint.Host
+		if endpoint.Port != 0 {
+			host = fmt.Sprintf("%v:%v", host, endpoint.Port)
+		}
+		// The providers include the helpers of the git config, which are
+		// reset so that they do not take precedence.
+		commandAuth.config = []string{
+			"credential.helper=",
+			fmt.Sprintf("credential.%v://%v.helper=%v", endpoint.Protocol, host, gitCredentialHelper),
+		}
+		commandAuth.env = []string{"PLY_GIT_USERNAME=" + basic.Username, "PLY_GIT_PASSWORD=" + basic.Password}
+	case isSSH(endpoint) && opts.SSHKey != "":
+		commandAuth.env = []string{"GIT_SSH_COMMAND=ssh -o IdentitiesOnly=yes -i " + shellQuote(opts.SSHKey)}
+	}
+	return commandAuth, nil
+}
+
+// gitCommandAuth is the authentication of the git binary.
+type gitCommandAuth struct {
+	// config are "name=value" config options.
+	config []string
+	env    []string
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func runGit(dir string, auth *gitCommandAuth, args ...string) error {
+	_, err := gitOutput(dir, auth, args...)
+	return err
+}
+
+// gitOutput runs git and returns its trimmed standard output.
+func gitOutput(dir string, auth *gitCommandAuth, args ...string) (string, error) {
+	var config []string
+	for _, option := range auth.config {
+		config = append(config, "-c", option)
+	}
+	cmd := exec.Command("git", append(config, args...)...)
+	cmd.Dir = dir
+	// Fail rather than prompt for credentials that were not found.
+	cmd.Env = append(append(os.Environ(), "GIT_TERMINAL_PROMPT=0"), auth.env...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %v: %v: %v", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
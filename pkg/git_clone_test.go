@@ -0,0 +1,446 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// newTestGitRepo creates a git repository with a commit per set of files,
+// tagging the first one "v1" and the last one "v1.2.3" (an annotated tag),
+// and a "release" branch with a commit adding RELEASE to the first one. It
+// returns the URL of the repository.
+func newTestGitRepo(t *testing.T, commits ...map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := func(i int) *object.Signature {
+		return &object.Signature{Name: "ply", Email: "ply@example.com", When: time.Unix(int64(i), 0)}
+	}
+	commit := func(i int, files map[string]string) plumbing.Hash {
+		for name, content := range files {
+			path := filepath.Join(dir, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := worktree.Add(name); err != nil {
+				t.Fatal(err)
+			}
+		}
+		hash, err := worktree.Commit("commit", &git.CommitOptions{Author: signature(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	var first, last plumbing.Hash
+	for i, files := range commits {
+		last = commit(i, files)
+		if i == 0 {
+			first = last
+			if _, err := repo.CreateTag("v1", first, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := repo.CreateTag("v1.2.3", last, &git.CreateTagOptions{Tagger: signature(len(commits)), Message: "v1.2.3"}); err != nil {
+		t.Fatal(err)
+	}
+	release := plumbing.NewBranchReferenceName("release")
+	if err := worktree.Checkout(&git.CheckoutOptions{Branch: release, Hash: first, Create: true}); err != nil {
+		t.Fatal(err)
+	}
+	commit(len(commits), map[string]string{"RELEASE": "release"})
+	if err := worktree.Checkout(&git.CheckoutOptions{Branch: plumbing.Master}); err != nil {
+		t.Fatal(err)
+	}
+	return "file://" + dir
+}
+
+// worktreeFiles lists the files of a worktree, and its HEAD.
+func worktreeFiles(t *testing.T, dir string) ([]string, *plumbing.Reference) {
+	t.Helper()
+	files := make([]string, 0)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files, head
+}
+
+func TestCloneRepositorySparse(t *testing.T) {
+	url := newTestGitRepo(t,
+		map[string]string{"README": "r", "a/x/f": "1", "ab/f": "2", "b/f": "3"},
+		map[string]string{"a/g": "4", "b/g": "5"},
+	)
+	tests := []struct {
+		name       string
+		opts       GitCloneOptions
+		wantFiles  []string
+		wantBranch bool
+	}{
+		{
+			name:       "one directory",
+			opts:       GitCloneOptions{SparsePaths: []string{"a"}},
+			wantFiles:  []string{"a/g", "a/x/f"},
+			wantBranch: true,
+		},
+		{
+			name:       "two directories",
+			opts:       GitCloneOptions{SparsePaths: []string{"a/x/", "b"}},
+			wantFiles:  []string{"a/x/f", "b/f", "b/g"},
+			wantBranch: true,
+		},
+		{
+			name:      "tag",
+			opts:      GitCloneOptions{Rev: "v1", SparsePaths: []string{"b"}},
+			wantFiles: []string{"b/f"},
+		},
+		{
+			name:      "relative revision",
+			opts:      GitCloneOptions{Rev: "HEAD~1", SparsePaths: []string{"a"}},
+			wantFiles: []string{"a/x/f"},
+		},
+		{
+			name:       "shallow",
+			opts:       GitCloneOptions{Depth: 1, SparsePaths: []string{"ab"}},
+			wantFiles:  []string{"ab/f"},
+			wantBranch: true,
+		},
+		{
+			name:       "not sparse",
+			opts:       GitCloneOptions{},
+			wantFiles:  []string{"README", "a/g", "a/x/f", "ab/f", "b/f", "b/g"},
+			wantBranch: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "clone")
+			if err := CloneRepository(url, dir, tt.opts); err != nil {
+				t.Fatal(err)
+			}
+			files, head := worktreeFiles(t, dir)
+			if !reflect.DeepEqual(files, tt.wantFiles) {
+				t.Errorf("files = %v, want %v", files, tt.wantFiles)
+			}
+			if head.Name().IsBranch() != tt.wantBranch {
+				t.Errorf("HEAD = %v, want a branch: %v", head.Name(), tt.wantBranch)
+			}
+
+			// git sees the files outside of the directories as skipped, not
+			// deleted. (go-git's status does not support skip-worktree
+			// entries.)
+			if _, err := exec.LookPath("git"); err != nil {
+				return
+			}
+			cmd := exec.Command("git", "status", "--porcelain")
+			cmd.Dir = dir
+			out, err := cmd.CombinedOutput()
+			if err != nil {
+				t.Fatalf("git status: %v: %s", err, out)
+			}
+			if len(out) > 0 {
+				t.Errorf("git status:\n%s", out)
+			}
+		})
+	}
+}
+
+func TestCloneRepositoryRevisions(t *testing.T) {
+	url := newTestGitRepo(t,
+		map[string]string{"f": "1"},
+		map[string]string{"g": "2"},
+	)
+	repo, err := git.PlainOpen(strings.TrimPrefix(url, "file://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolve := func(rev string) plumbing.Hash {
+		hash, err := repo.ResolveRevision(plumbing.Revision(rev))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *hash
+	}
+	first, last, release := resolve("v1"), resolve("master"), resolve("release")
+
+	tests := []struct {
+		name       string
+		opts       GitCloneOptions
+		wantFiles  []string
+		wantHead   plumbing.Hash
+		wantBranch bool
+	}{
+		{
+			name:      "annotated tag",
+			opts:      GitCloneOptions{Rev: "v1.2.3"},
+			wantFiles: []string{"f", "g"},
+			wantHead:  last,
+		},
+		{
+			name:      "shallow annotated tag",
+			opts:      GitCloneOptions{Rev: "v1.2.3", Depth: 1},
+			wantFiles: []string{"f", "g"},
+			wantHead:  last,
+		},
+		{
+			name:      "peeled annotated tag",
+			opts:      GitCloneOptions{Rev: "v1.2.3^{}"},
+			wantFiles: []string{"f", "g"},
+			wantHead:  last,
+		},
+		{
+			name:      "abbreviated hash",
+			opts:      GitCloneOptions{Rev: first.String()[:7]},
+			wantFiles: []string{"f"},
+			wantHead:  first,
+		},
+		{
+			name:       "remote branch",
+			opts:       GitCloneOptions{Rev: "release"},
+			wantFiles:  []string{"RELEASE", "f"},
+			wantHead:   release,
+			wantBranch: true,
+		},
+		{
+			name:      "remote branch ancestor",
+			opts:      GitCloneOptions{Rev: "release~1"},
+			wantFiles: []string{"f"},
+			wantHead:  first,
+		},
+	}
+	_, gitErr := exec.LookPath("git")
+	for _, tt := range tests {
+		for _, withGit := range []bool{false, true} {
+			name := tt.name
+			opts := tt.opts
+			if withGit {
+				name += " with git"
+				opts.Filter = "blob:none"
+			}
+			t.Run(name, func(t *testing.T) {
+				if withGit && gitErr != nil {
+					t.Skip("git is not installed")
+				}
+				dir := filepath.Join(t.TempDir(), "clone")
+				if err := CloneRepository(url, dir, opts); err != nil {
+					t.Fatal(err)
+				}
+				files, head := worktreeFiles(t, dir)
+				if !reflect.DeepEqual(files, tt.wantFiles) {
+					t.Errorf("files = %v, want %v", files, tt.wantFiles)
+				}
+				if head.Hash() != tt.wantHead {
+					t.Errorf("HEAD = %v, want %v", head.Hash(), tt.wantHead)
+				}
+				if head.Name().IsBranch() != tt.wantBranch {
+					t.Errorf("HEAD = %v, want a branch: %v", head.Name(), tt.wantBranch)
+				}
+			})
+		}
+	}
+}
+
+func TestCloneRepositoryFailure(t *testing.T) {
+	url := newTestGitRepo(t,
+		map[string]string{"a/f": "1"},
+		map[string]string{"a/g": "2"},
+	)
+	repo, err := git.PlainOpen(strings.TrimPrefix(url, "file://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := repo.ResolveRevision("v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// go-git's file transport ignores the depth, so only git clones can
+	// miss a commit here.
+	abbreviated := first.String()[:7]
+	marker := filepath.Join(t.TempDir(), "marker")
+
+	tests := []struct {
+		name    string
+		opts    GitCloneOptions
+		git     bool
+		wantErr string
+	}{
+		{
+			name:    "unknown revision",
+			opts:    GitCloneOptions{Rev: "nonexistent"},
+			wantErr: "nonexistent",
+		},
+		{
+			name:    "abbreviated hash with git",
+			opts:    GitCloneOptions{Rev: abbreviated, Depth: 1, Filter: "blob:none"},
+			git:     true,
+			wantErr: "abbreviated hashes cannot be fetched",
+		},
+		{
+			// Not a ref name, so that the name is fetched.
+			name:    "option as revision",
+			opts:    GitCloneOptions{Rev: "--upload-pack=touch " + marker + ";~0", Depth: 1, Filter: "blob:none"},
+			git:     true,
+			wantErr: "upload-pack",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := exec.LookPath("git"); tt.git && err != nil {
+				t.Skip("git is not installed")
+			}
+			for _, existing := range []bool{false, true} {
+				dir := filepath.Join(t.TempDir(), "clone")
+				if existing {
+					if err := os.Mkdir(dir, 0755); err != nil {
+						t.Fatal(err)
+					}
+				}
+				err := CloneRepository(url, dir, tt.opts)
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("CloneRepository() = %v, want an error with %q", err, tt.wantErr)
+				}
+				// The clone is cleaned up.
+				entries, statErr := ioutil.ReadDir(dir)
+				if existing && (statErr != nil || len(entries) > 0) {
+					t.Errorf("%v is not left empty: %v, %v", dir, entries, statErr)
+				} else if !existing && !os.IsNotExist(statErr) {
+					t.Errorf("%v is not removed: %v", dir, statErr)
+				}
+				if _, err := os.Stat(marker); err == nil {
+					t.Fatalf("the revision was run as a git option")
+				}
+			}
+		})
+	}
+}
+
+// newTestGitServer serves a test git repository over HTTP with the git
+// binary, requiring user:password for everything, and returns its URL.
+func newTestGitServer(t *testing.T, url string) string {
+	t.Helper()
+	execPath, err := exec.Command("git", "--exec-path").Output()
+	if err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := strings.TrimPrefix(url, "file://")
+	backend := &cgi.Handler{
+		Path: filepath.Join(strings.TrimSpace(string(execPath)), "git-http-backend"),
+		Env: []string{
+			"GIT_PROJECT_ROOT=" + filepath.Dir(dir),
+			"GIT_HTTP_EXPORT_ALL=1",
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=uploadpack.allowFilter",
+			"GIT_CONFIG_VALUE_0=true",
+		},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "password" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		backend.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL + "/" + filepath.Base(dir)
+}
+
+func TestCloneRepositoryHTTPAuth(t *testing.T) {
+	url := newTestGitServer(t, newTestGitRepo(t,
+		map[string]string{"a/f": "1"},
+		map[string]string{"a/g": "2"},
+	))
+	// Keep the git config of the user out of the clones.
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	helper := "!f() { echo username=user; echo password=password; }; f"
+
+	tests := []struct {
+		name    string
+		opts    GitCloneOptions
+		want    []string
+		wantErr bool
+	}{
+		{name: "go-git", opts: GitCloneOptions{Auth: GitAuthOptions{CredentialHelper: helper}}, want: []string{"a/f", "a/g"}},
+		{name: "go-git without credentials", opts: GitCloneOptions{Auth: GitAuthOptions{CredentialHelper: "!exit 1"}}, wantErr: true},
+		{name: "git", opts: GitCloneOptions{Filter: "blob:none", Auth: GitAuthOptions{CredentialHelper: helper}}, want: []string{"a/f", "a/g"}},
+		{name: "git fetching a revision", opts: GitCloneOptions{Rev: "v1^{}", Depth: 1, Filter: "blob:none", Auth: GitAuthOptions{CredentialHelper: helper}}, want: []string{"a/f"}},
+		{name: "git without credentials", opts: GitCloneOptions{Filter: "blob:none", Auth: GitAuthOptions{CredentialHelper: "!exit 1"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "clone")
+			err := CloneRepository(url, dir, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CloneRepository() = %v, want error: %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			files, _ := worktreeFiles(t, dir)
+			if !reflect.DeepEqual(files, tt.want) {
+				t.Errorf("files = %v, want %v", files, tt.want)
+			}
+		})
+	}
+}
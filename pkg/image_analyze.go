@@ -0,0 +1,246 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	units "github.com/docker/go-units"
+)
+
+// ImageAnalysis describes where the bytes of an image go.
+type ImageAnalysis struct {
+	Layers []LayerAnalysis
+	// Size is the total size of the files of all layers, including the
+	// wasted bytes.
+	Size int64
+	// Largest are the largest files of the final filesystem.
+	Largest []LayerFile
+	// Wasted are the files that were overwritten or deleted by a later layer,
+	// so that their bytes are shipped but never seen.
+	Wasted      []WastedFile
+	WastedBytes int64
+}
+
+// LayerAnalysis describes a layer of an image.
+type LayerAnalysis struct {
+	// CreatedBy is the instruction that created the layer, from the image
+	// history.
+	CreatedBy string
+	Size      int64
+	Files     int
+}
+
+// WastedFile is a path whose content lower layers wrote for nothing.
+type WastedFile struct {
+	Path string
+	// Size is the sum of the sizes of the hidden versions of the file.
+	Size int64
+	// Count is the number of hidden versions of the file.
+	Count int
+}
+
+// Efficiency returns the share of the bytes of the image that are visible in
+// its final filesystem, between 0 and 1.
+func (a *ImageAnalysis) Efficiency() float64 {
+	if a.Size == 0 {
+		return 1
+	}
+	return float64(a.Size-a.WastedBytes) / float64(a.Size)
+}
+
+// AnalyzeImage walks the layers of an image of the archive to compute their
+// sizes and the bytes wasted by files that later layers overwrite or delete.
+// At most top files are listed as the largest and most wasted ones.
+func (a *ImageArchive) AnalyzeImage(m ArchiveManifest, top int) (*ImageAnalysis, error) {
+	createdBy, err := a.layerHistory(m)
+	if err != nil {
+		return nil, err
+	}
+
+	analysis := &ImageAnalysis{}
+	fs := make(map[string]LayerFile)
+	wasted := make(map[string]*WastedFile)
+	hide := func(f LayerFile) {
+		if f.Size == 0 {
+			return
+		}
+		w, ok := wasted[f.Path]
+		if !ok {
+			w = &WastedFile{Path: f.Path}
+			wasted[f.Path] = w
+		}
+		w.Size += f.Size
+		w.Count++
+		analysis.WastedBytes += f.Size
+	}
+	// hideTree hides a path (if self is set) and everything below it that
+	// came from lower layers.
+	hideTree := func(p string, layer int, self bool) {
+		if f, ok := fs[p]; self && ok && f.Layer < layer {
+			hide(f)
+			delete(fs, p)
+		}
+		prefix := strings.TrimSuffix(p, "/") + "/"
+		for other, f := range fs {
+			if strings.HasPrefix(other, prefix) && f.Layer < layer {
+				hide(f)
+				delete(fs, other)
+			}
+		}
+	}
+
+	for i, layer := range m.Layers {
+		l := LayerAnalysis{CreatedBy: createdBy[i]}
+		err := WalkLayer(a.Path(layer), func(hdr *tar.Header, p string, r io.Reader) error {
+			dir, base := path.Split(p)
+			switch {
+			case base == whiteoutOpaque:
+				hideTree(path.Clean(dir), i, false)
+				return nil
+			case strings.HasPrefix(base, whiteoutPrefix):
+				hideTree(path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)), i, true)
+				return nil
+			}
+			if f, ok := fs[p]; ok {
+				hide(f)
+			}
+			fs[p] = LayerFile{
+				Path:     p,
+				Layer:    i,
+				Size:     hdr.Size,
+				Mode:     hdr.FileInfo().Mode(),
+				Typeflag: hdr.Typeflag,
+				Linkname: hdr.Linkname,
+			}
+			l.Size += hdr.Size
+			l.Files++
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		analysis.Layers = append(analysis.Layers, l)
+		analysis.Size += l.Size
+	}
+
+	analysis.Largest = make([]LayerFile, 0)
+	for _, f := range fs {
+		if f.Size > 0 {
+			analysis.Largest = append(analysis.Largest, f)
+		}
+	}
+	sort.Slice(analysis.Largest, func(i, j int) bool {
+		x, y := analysis.Largest[i], analysis.Largest[j]
+		return x.Size > y.Size || x.Size == y.Size && x.Path < y.Path
+	})
+	if len(analysis.Largest) > top {
+		analysis.Largest = analysis.Largest[:top]
+	}
+
+	analysis.Wasted = make([]WastedFile, 0)
+	for _, w := range wasted {
+		analysis.Wasted = append(analysis.Wasted, *w)
+	}
+	sort.Slice(analysis.Wasted, func(i, j int) bool {
+		x, y := analysis.Wasted[i], analysis.Wasted[j]
+		return x.Size > y.Size || x.Size == y.Size && x.Path < y.Path
+	})
+	if len(analysis.Wasted) > top {
+		analysis.Wasted = analysis.Wasted[:top]
+	}
+	return analysis, nil
+}
+
+// layerHistory returns the instruction that created each layer of an image.
+// History entries of instructions that created no layer (e.g. ENV) are
+// skipped.
+func (a *ImageArchive) layerHistory(m ArchiveManifest) ([]string, error) {
+	raw, err := a.ReadConfig(m)
+	if err != nil {
+		return nil, err
+	}
+	var config struct {
+		History []struct {
+			CreatedBy  string `json:"created_by"`
+			EmptyLayer bool   `json:"empty_layer"`
+		} `json:"history"`
+	}
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return nil, err
+	}
+	createdBy := make([]string, len(m.Layers))
+	i := 0
+	for _, h := range config.History {
+		if h.EmptyLayer {
+			continue
+		}
+		if i == len(createdBy) {
+			break
+		}
+		createdBy[i] = h.CreatedBy
+		i++
+	}
+	return createdBy, nil
+}
+
+// shortInstruction makes a history entry readable: the shell wrapper Docker
+// adds is removed and long instructions are truncated.
+func shortInstruction(createdBy string, max int) string {
+	s := strings.TrimSpace(createdBy)
+	s = strings.TrimPrefix(s, "/bin/sh -c ")
+	s = strings.TrimSpace(strings.TrimPrefix(s, "#(nop) "))
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) > max {
+		s = s[:max-3] + "..."
+	}
+	return s
+}
+
+func humanSize(size int64) string {
+	return units.HumanSize(float64(size))
+}
+
+// Print writes a human-readable report of the analysis.
+func (a *ImageAnalysis) Print(w io.Writer) {
+	fmt.Fprintf(w, "  Size: %v, wasted: %v, efficiency: %.1f%%\n", humanSize(a.Size), humanSize(a.WastedBytes), 100*a.Efficiency())
+
+	fmt.Fprintln(w, "  Layers:")
+	for i, l := range a.Layers {
+		fmt.Fprintf(w, "    %3d %10v  %v\n", i, humanSize(l.Size), shortInstruction(l.CreatedBy, 80))
+	}
+
+	fmt.Fprintln(w, "  Largest files:")
+	for _, f := range a.Largest {
+		fmt.Fprintf(w, "    %10v  %v (layer %v)\n", humanSize(f.Size), f.Path, f.Layer)
+	}
+
+	if len(a.Wasted) > 0 {
+		fmt.Fprintln(w, "  Wasted bytes (overwritten or deleted files):")
+		for _, f := range a.Wasted {
+			versions := ""
+			if f.Count > 1 {
+				versions = fmt.Sprintf(" (%v versions)", f.Count)
+			}
+			fmt.Fprintf(w, "    %10v  %v%v\n", humanSize(f.Size), f.Path, versions)
+		}
+	}
+}
@@ -0,0 +1,444 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// ImageDiff lists what differs between two images.
+type ImageDiff struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	FromID string `json:"fromId"`
+	ToID   string `json:"toId"`
+	// Config lists the differences in the image config (the container.Config
+	// that containers of the images start with).
+	Config []ConfigChange `json:"config"`
+	Layers LayerChanges   `json:"layers"`
+	// Files lists the files that differ, if files were compared.
+	Files []FileChange `json:"files,omitempty"`
+}
+
+// ConfigChange is a setting of the image config that differs. Key is set for
+// settings made of several entries (e.g. Env, Labels, ExposedPorts or
+// Healthcheck). From or To is empty if the setting (or entry) is only in one
+// image. Values other than strings are in JSON.
+type ConfigChange struct {
+	Field string `json:"field"`
+	Key   string `json:"key,omitempty"`
+	From  string `json:"from,omitempty"`
+	To    string `json:"to,omitempty"`
+}
+
+// LayerChanges compares the layers (by diff ID) of two images. Layers stack,
+// so they are compared in order: the images share the layers up to the first
+// one that differs, and none after it.
+type LayerChanges struct {
+	// Common are the layers both images start with.
+	Common []string `json:"common"`
+	// Diverged is the index of the first layer that differs, or -1 if the
+	// images have the same layers.
+	Diverged int `json:"diverged"`
+	// Removed and Added are the layers from Diverged on.
+	Removed []string `json:"removed"`
+	Added   []string `json:"added"`
+}
+
+// Kinds of FileChange.
+const (
+	FileAdded    = "added"
+	FileRemoved  = "removed"
+	FileModified = "modified"
+)
+
+// FileChange is a file that differs between the final filesystems of two
+// images.
+type FileChange struct {
+	Path   string `json:"path"`
+	Change string `json:"change"`
+}
+
+// Equal reports whether the images have the same config and layers.
+func (d *ImageDiff) Equal() bool {
+	return len(d.Config) == 0 && d.LayersEqual()
+}
+
+// LayersEqual reports whether the images have the same layers.
+func (d *ImageDiff) LayersEqual() bool {
+	return d.Layers.Diverged < 0
+}
+
+// DiffImages compares two images of the daemon: every setting of their config
+// and their layers. With files, the images are also exported to compare the
+// files of their final filesystems; only files coming from layers that are not
+// shared are read.
+func DiffImages(dcli *client.Client, from, to string, files bool) (*ImageDiff, error) {
+	a, _, err := dcli.ImageInspectWithRaw(context.Background(), from)
+	if err != nil {
+		return nil, err
+	}
+	b, _, err := dcli.ImageInspectWithRaw(context.Background(), to)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &ImageDiff{From: from, To: to, FromID: a.ID, ToID: b.ID}
+	if d.Config, err = diffConfigs(a.Config, b.Config); err != nil {
+		return nil, err
+	}
+	d.Layers = diffLayers(a.RootFS.Layers, b.RootFS.Layers)
+
+	if files && a.ID != b.ID && !d.LayersEqual() {
+		archive, err := SaveImages(dcli, []string{from, to})
+		if err != nil {
+			return nil, err
+		}
+		defer archive.Close()
+		// Images are found by ID, which works whatever they were named by
+		// (e.g. a digest, which "docker save" does not record).
+		if d.Files, err = archive.diffFiles(a.ID, b.ID); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// diffConfigs compares every field of two image configs. Env and Labels are
+// compared by variable and label, and the other fields holding objects (e.g.
+// ExposedPorts, Volumes or Healthcheck) by key.
+func diffConfigs(from, to *container.Config) ([]ConfigChange, error) {
+	if from == nil {
+		from = &container.Config{}
+	}
+	if to == nil {
+		to = &container.Config{}
+	}
+	a, err := configFields(from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := configFields(to)
+	if err != nil {
+		return nil, err
+	}
+	fields := make([]string, 0)
+	for field := range a {
+		fields = append(fields, field)
+	}
+	for field := range b {
+		if _, ok := a[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	changes := make([]ConfigChange, 0)
+	for _, field := range fields {
+		switch {
+		case field == "Env":
+			changes = append(changes, diffEntries(field, from.Env, to.Env)...)
+		case field == "Labels":
+			changes = append(changes, diffLabels(from.Labels, to.Labels)...)
+		case isJSONObject(a[field]) || isJSONObject(b[field]):
+			changes = append(changes, diffMaps(field, objectEntries(a[field]), objectEntries(b[field]))...)
+		default:
+			changes = append(changes, diffValue(field, jsonValue(a[field]), jsonValue(b[field]))...)
+		}
+	}
+	return changes, nil
+}
+
+// configFields returns the fields of a config by name, as JSON.
+func configFields(config *container.Config) (map[string]json.RawMessage, error) {
+	fields := make(map[string]json.RawMessage)
+	raw, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	return fields, json.Unmarshal(raw, &fields)
+}
+
+func isJSONObject(raw json.RawMessage) bool {
+	return strings.HasPrefix(string(raw), "{")
+}
+
+// objectEntries returns the entries of a JSON object, with their values as
+// jsonValue strings.
+func objectEntries(raw json.RawMessage) map[string]string {
+	entries := make(map[string]string)
+	if !isJSONObject(raw) {
+		return entries
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return entries
+	}
+	for k, v := range fields {
+		entries[k] = jsonValue(v)
+	}
+	return entries
+}
+
+// jsonValue turns a JSON value into a readable string: the string itself for
+// strings, "" for null (or a missing value) and the JSON otherwise.
+func jsonValue(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	if string(raw) == "null" {
+		return ""
+	}
+	return string(raw)
+}
+
+func diffValue(field, from, to string) []ConfigChange {
+	if from == to {
+		return nil
+	}
+	return []ConfigChange{{Field: field, From: from, To: to}}
+}
+
+// diffEntries compares KEY=VALUE lists.
+func diffEntries(field string, from, to []string) []ConfigChange {
+	split := func(entries []string) map[string]string {
+		m := make(map[string]string)
+		for _, e := range entries {
+			kv := strings.SplitN(e, "=", 2)
+			m[kv[0]] = e[len(kv[0]):]
+		}
+		return m
+	}
+	changes := diffMaps(field, split(from), split(to))
+	for i := range changes {
+		// Keep the values readable: "=value" becomes "value".
+		changes[i].From = strings.TrimPrefix(changes[i].From, "=")
+		changes[i].To = strings.TrimPrefix(changes[i].To, "=")
+	}
+	return changes
+}
+
+func diffLabels(from, to map[string]string) []ConfigChange {
+	return diffMaps("Labels", from, to)
+}
+
+func diffMaps(field string, from, to map[string]string) []ConfigChange {
+	keys := make([]string, 0)
+	for k := range from {
+		keys = append(keys, k)
+	}
+	for k := range to {
+		if _, ok := from[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	changes := make([]ConfigChange, 0)
+	for _, k := range keys {
+		a, inFrom := from[k]
+		b, inTo := to[k]
+		if inFrom && inTo && a == b {
+			continue
+		}
+		changes = append(changes, ConfigChange{Field: field, Key: k, From: a, To: b})
+	}
+	return changes
+}
+
+func diffLayers(from, to []string) LayerChanges {
+	i := 0
+	for i < len(from) && i < len(to) && from[i] == to[i] {
+		i++
+	}
+	changes := LayerChanges{
+		Common:   append(make([]string, 0), from[:i]...),
+		Diverged: i,
+		Removed:  append(make([]string, 0), from[i:]...),
+		Added:    append(make([]string, 0), to[i:]...),
+	}
+	if i == len(from) && i == len(to) {
+		changes.Diverged = -1
+	}
+	return changes
+}
+
+// diffIDs returns the diff ID of each layer of an image, from its config.
+func (a *ImageArchive) diffIDs(m ArchiveManifest) ([]string, error) {
+	raw, err := a.ReadConfig(m)
+	if err != nil {
+		return nil, err
+	}
+	var config struct {
+		RootFS struct {
+			DiffIDs []string `json:"diff_ids"`
+		} `json:"rootfs"`
+	}
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return nil, err
+	}
+	if len(config.RootFS.DiffIDs) != len(m.Layers) {
+		return nil, fmt.Errorf("config of %v lists %v layers instead of %v", strings.Join(m.RepoTags, ", "), len(config.RootFS.DiffIDs), len(m.Layers))
+	}
+	return config.RootFS.DiffIDs, nil
+}
+
+// diffFiles compares the final filesystems of two images of the archive.
+// Files that come from the same layer in both are the same; others are
+// compared by type, mode, link target and content.
+func (a *ImageArchive) diffFiles(from, to string) ([]FileChange, error) {
+	type side struct {
+		m       ArchiveManifest
+		fs      map[string]LayerFile
+		diffIDs []string
+		hashes  map[string]string
+	}
+	sides := make([]*side, 0)
+	for _, name := range []string{from, to} {
+		m, ok := a.Find(name)
+		if !ok {
+			return nil, fmt.Errorf("image %v not found in the exported images", name)
+		}
+		fs, err := a.Filesystem(m)
+		if err != nil {
+			return nil, err
+		}
+		diffIDs, err := a.diffIDs(m)
+		if err != nil {
+			return nil, err
+		}
+		sides = append(sides, &side{m: m, fs: fs, diffIDs: diffIDs, hashes: make(map[string]string)})
+	}
+	af, bf := sides[0], sides[1]
+
+	paths := make([]string, 0)
+	for p := range af.fs {
+		paths = append(paths, p)
+	}
+	for p := range bf.fs {
+		if _, ok := af.fs[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	// First pass: find the files whose content has to be compared.
+	toHash := [][]LayerFile{{}, {}}
+	for _, p := range paths {
+		fa, inA := af.fs[p]
+		fb, inB := bf.fs[p]
+		if inA && inB && af.diffIDs[fa.Layer] != bf.diffIDs[fb.Layer] &&
+			fa.Typeflag == fb.Typeflag && fa.Mode == fb.Mode && fa.Linkname == fb.Linkname &&
+			fa.Size == fb.Size && fa.Mode.IsRegular() {
+			toHash[0] = append(toHash[0], fa)
+			toHash[1] = append(toHash[1], fb)
+		}
+	}
+	for i, s := range sides {
+		err := a.ReadFiles(s.m, toHash[i], func(f LayerFile, r io.Reader) error {
+			h := sha256.New()
+			if _, err := io.Copy(h, r); err != nil {
+				return err
+			}
+			s.hashes[f.Path] = hex.EncodeToString(h.Sum(nil))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	changes := make([]FileChange, 0)
+	for _, p := range paths {
+		fa, inA := af.fs[p]
+		fb, inB := bf.fs[p]
+		switch {
+		case !inB:
+			changes = append(changes, FileChange{p, FileRemoved})
+		case !inA:
+			changes = append(changes, FileChange{p, FileAdded})
+		case af.diffIDs[fa.Layer] == bf.diffIDs[fb.Layer]:
+			// Same layer, same file.
+		case fa.Typeflag != fb.Typeflag || fa.Mode != fb.Mode || fa.Linkname != fb.Linkname || fa.Size != fb.Size:
+			changes = append(changes, FileChange{p, FileModified})
+		case fa.Mode.IsRegular() && af.hashes[p] != bf.hashes[p]:
+			changes = append(changes, FileChange{p, FileModified})
+		}
+	}
+	return changes, nil
+}
+
+// Print writes a human-readable form of the diff.
+func (d *ImageDiff) Print(w io.Writer) {
+	fmt.Fprintf(w, "--- %v (%v)\n", d.From, d.FromID)
+	fmt.Fprintf(w, "+++ %v (%v)\n", d.To, d.ToID)
+	if d.Equal() {
+		fmt.Fprintln(w, "Images are identical")
+		return
+	}
+
+	fmt.Fprintln(w, "Config:")
+	if len(d.Config) == 0 {
+		fmt.Fprintln(w, "  (no changes)")
+	}
+	for _, c := range d.Config {
+		name := c.Field
+		if c.Key != "" {
+			name += " " + c.Key
+		}
+		switch {
+		case c.To == "" && c.Key != "":
+			fmt.Fprintf(w, "  - %v: %v\n", name, c.From)
+		case c.From == "" && c.Key != "":
+			fmt.Fprintf(w, "  + %v: %v\n", name, c.To)
+		default:
+			fmt.Fprintf(w, "  ~ %v: %q -> %q\n", name, c.From, c.To)
+		}
+	}
+
+	if d.LayersEqual() {
+		fmt.Fprintf(w, "Layers: %v in common\n", len(d.Layers.Common))
+	} else {
+		fmt.Fprintf(w, "Layers: %v in common, diverging at layer %v\n", len(d.Layers.Common), d.Layers.Diverged)
+	}
+	for _, l := range d.Layers.Removed {
+		fmt.Fprintf(w, "  - %v\n", l)
+	}
+	for _, l := range d.Layers.Added {
+		fmt.Fprintf(w, "  + %v\n", l)
+	}
+
+	if d.Files != nil {
+		fmt.Fprintln(w, "Files:")
+		if len(d.Files) == 0 {
+			fmt.Fprintln(w, "  (no changes)")
+		}
+		marks := map[string]string{FileAdded: "+", FileRemoved: "-", FileModified: "~"}
+		for _, f := range d.Files {
+			fmt.Fprintf(w, "  %v %v\n", marks[f.Change], f.Path)
+		}
+	}
+}
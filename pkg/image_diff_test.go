@@ -0,0 +1,217 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+)
+
+func TestDiffConfigs(t *testing.T) {
+	base := func() *container.Config {
+		return &container.Config{
+			Env:          []string{"PATH=/bin", "HOME=/root"},
+			Labels:       map[string]string{"version": "1"},
+			Cmd:          []string{"/addon"},
+			ExposedPorts: nat.PortSet{"8080/tcp": {}},
+		}
+	}
+	tests := []struct {
+		name string
+		// from makes the first config (nil if there is none), and change
+		// turns a copy of it into the second one.
+		from   func() *container.Config
+		change func(c *container.Config)
+		want   []ConfigChange
+	}{
+		{
+			name:   "same",
+			from:   base,
+			change: func(c *container.Config) {},
+			want:   []ConfigChange{},
+		},
+		{
+			name:   "env",
+			from:   base,
+			change: func(c *container.Config) { c.Env = []string{"PATH=/usr/bin", "LANG=C"} },
+			want: []ConfigChange{
+				{Field: "Env", Key: "HOME", From: "/root"},
+				{Field: "Env", Key: "LANG", To: "C"},
+				{Field: "Env", Key: "PATH", From: "/bin", To: "/usr/bin"},
+			},
+		},
+		{
+			name:   "labels",
+			from:   base,
+			change: func(c *container.Config) { c.Labels["version"] = "2" },
+			want:   []ConfigChange{{Field: "Labels", Key: "version", From: "1", To: "2"}},
+		},
+		{
+			name:   "cmd and user",
+			from:   base,
+			change: func(c *container.Config) { c.Cmd = []string{"/addon", "-v"}; c.User = "nobody" },
+			want: []ConfigChange{
+				{Field: "Cmd", From: `["/addon"]`, To: `["/addon","-v"]`},
+				{Field: "User", To: "nobody"},
+			},
+		},
+		{
+			name: "exposed ports and volumes",
+			from: base,
+			change: func(c *container.Config) {
+				c.ExposedPorts = nat.PortSet{"9090/tcp": {}}
+				c.Volumes = map[string]struct{}{"/data": {}}
+			},
+			want: []ConfigChange{
+				{Field: "ExposedPorts", Key: "8080/tcp", From: "{}"},
+				{Field: "ExposedPorts", Key: "9090/tcp", To: "{}"},
+				{Field: "Volumes", Key: "/data", To: "{}"},
+			},
+		},
+		{
+			name: "stop signal, shell and onbuild",
+			from: base,
+			change: func(c *container.Config) {
+				c.StopSignal = "SIGINT"
+				c.Shell = []string{"/bin/bash", "-c"}
+				c.OnBuild = []string{"RUN make"}
+			},
+			want: []ConfigChange{
+				{Field: "OnBuild", To: `["RUN make"]`},
+				{Field: "Shell", To: `["/bin/bash","-c"]`},
+				{Field: "StopSignal", To: "SIGINT"},
+			},
+		},
+		{
+			name: "healthcheck",
+			from: func() *container.Config {
+				return &container.Config{Healthcheck: &container.HealthConfig{Test: []string{"CMD", "true"}, Retries: 3}}
+			},
+			change: func(c *container.Config) {
+				c.Healthcheck = &container.HealthConfig{Test: []string{"CMD", "false"}, Retries: 3}
+			},
+			want: []ConfigChange{{Field: "Healthcheck", Key: "Test", From: `["CMD","true"]`, To: `["CMD","false"]`}},
+		},
+		{
+			name:   "no config",
+			from:   nil,
+			change: func(c *container.Config) { c.Env = []string{"A=b"}; c.Tty = true },
+			want: []ConfigChange{
+				{Field: "Env", Key: "A", To: "b"},
+				{Field: "Tty", From: "false", To: "true"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var from *container.Config
+			to := &container.Config{}
+			if tt.from != nil {
+				from, to = tt.from(), tt.from()
+			}
+			tt.change(to)
+			got, err := diffConfigs(from, to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffConfigs() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffLayers(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to []string
+		want     LayerChanges
+	}{
+		{
+			name: "same",
+			from: []string{"a", "b"},
+			to:   []string{"a", "b"},
+			want: LayerChanges{Common: []string{"a", "b"}, Diverged: -1, Removed: []string{}, Added: []string{}},
+		},
+		{
+			name: "layer added",
+			from: []string{"a", "b"},
+			to:   []string{"a", "b", "c"},
+			want: LayerChanges{Common: []string{"a", "b"}, Diverged: 2, Removed: []string{}, Added: []string{"c"}},
+		},
+		{
+			name: "layer changed",
+			from: []string{"a", "b", "c"},
+			to:   []string{"a", "x", "c"},
+			want: LayerChanges{Common: []string{"a"}, Diverged: 1, Removed: []string{"b", "c"}, Added: []string{"x", "c"}},
+		},
+		{
+			// The same layers in another order make another filesystem.
+			name: "layers reordered",
+			from: []string{"a", "b"},
+			to:   []string{"b", "a"},
+			want: LayerChanges{Common: []string{}, Diverged: 0, Removed: []string{"a", "b"}, Added: []string{"b", "a"}},
+		},
+		{
+			name: "no layers",
+			want: LayerChanges{Common: []string{}, Diverged: -1, Removed: []string{}, Added: []string{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffLayers(tt.from, tt.to); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffLayers() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImageArchiveFind(t *testing.T) {
+	const legacyID = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	const ociID = "sha256:fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+	archive := &ImageArchive{Manifest: []ArchiveManifest{
+		{Config: legacyID[len("sha256:"):] + ".json", RepoTags: []string{"gcr.io/addon:v1", "addon:latest"}},
+		{Config: "blobs/sha256/" + ociID[len("sha256:"):]},
+	}}
+	tests := []struct {
+		name   string
+		image  string
+		wantID string
+	}{
+		{name: "tag", image: "gcr.io/addon:v1", wantID: legacyID},
+		{name: "familiar name", image: "addon", wantID: legacyID},
+		{name: "docker.io name", image: "docker.io/library/addon:latest", wantID: legacyID},
+		{name: "image ID", image: ociID, wantID: ociID},
+		{name: "image ID without algorithm", image: legacyID[len("sha256:"):], wantID: legacyID},
+		{name: "short image ID", image: "fedcba987654", wantID: ociID},
+		{name: "too short image ID", image: "fedcba98"},
+		{name: "unknown tag", image: "gcr.io/addon:v2"},
+		{name: "unknown image ID", image: "sha256:aaaaaaaaaaaa"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, ok := archive.Find(tt.image)
+			if ok != (tt.wantID != "") {
+				t.Fatalf("Find(%v) found: %v, want %v", tt.image, ok, tt.wantID != "")
+			}
+			if ok && m.ID() != tt.wantID {
+				t.Errorf("Find(%v) = %v, want %v", tt.image, m.ID(), tt.wantID)
+			}
+		})
+	}
+}
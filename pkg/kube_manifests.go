@@ -0,0 +1,561 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/docker/distribution/reference"
+	"gopkg.in/yaml.v3"
+)
+
+// podSpecPaths lists, by kind, the path to the pod spec of Kubernetes
+// objects that run containers.
+var podSpecPaths = map[string][]string{
+	"Pod":         {"spec"},
+	"PodTemplate": {"template", "spec"},
+	"Deployment":  {"spec", "template", "spec"},
+	"DaemonSet":   {"spec", "template", "spec"},
+	"StatefulSet": {"spec", "template", "spec"},
+	"ReplicaSet":  {"spec", "template", "spec"},
+	"Job":         {"spec", "template", "spec"},
+	"CronJob":     {"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+// containerFields are the fields of a pod spec that list containers.
+var containerFields = []string{"containers", "initContainers", "ephemeralContainers"}
+
+// KubeImageRef is an image reference found in a Kubernetes manifest.
+type KubeImageRef struct {
+	File   string
+	Line   int
+	Column int
+	// Object is the "Kind/name" of the object referencing the image.
+	Object string
+	Image  string
+	node   *yaml.Node
+}
+
+func (r KubeImageRef) String() string {
+	return fmt.Sprintf("%v:%v: %v", r.File, r.Line, r.Object)
+}
+
+// KubeImageChange is the rewrite of an image reference.
+type KubeImageChange struct {
+	KubeImageRef
+	New string
+}
+
+// FindKubeManifests returns the YAML files (.yaml or .yml) below a directory,
+// skipping hidden directories.
+func FindKubeManifests(dir string) ([]string, error) {
+	files := make([]string, 0)
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if p != dir && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ext := filepath.Ext(p); ext == ".yaml" || ext == ".yml" {
+			files = append(files, p)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// FindKubeImages returns the image references of the containers (including
+// init and ephemeral containers) of the objects of a multi-document YAML
+// manifest. Objects of kind List are searched too.
+func FindKubeImages(file string, content []byte) ([]KubeImageRef, error) {
+	refs := make([]KubeImageRef, 0)
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		var doc yaml.Node
+		err := decoder.Decode(&doc)
+		if err == io.EOF {
+			sort.SliceStable(refs, func(i, j int) bool {
+				return refs[i].Line < refs[j].Line || refs[i].Line == refs[j].Line && refs[i].Column < refs[j].Column
+			})
+			return refs, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("%v: %v", file, err)
+		}
+		if len(doc.Content) > 0 {
+			refs = findObjectImages(refs, file, doc.Content[0])
+		}
+	}
+}
+
+// FindKubeImagesInDir returns the image references of the manifests of a
+// directory (see FindKubeManifests).
+func FindKubeImagesInDir(dir string) ([]KubeImageRef, error) {
+	files, err := FindKubeManifests(dir)
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]KubeImageRef, 0)
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		found, err := FindKubeImages(file, content)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, found...)
+	}
+	return refs, nil
+}
+
+func findObjectImages(refs []KubeImageRef, file string, object *yaml.Node) []KubeImageRef {
+	if object.Kind != yaml.MappingNode {
+		return refs
+	}
+	kind := scalarValue(mappingValue(object, "kind"))
+	if kind == "List" {
+		if items := mappingValue(object, "items"); items != nil && items.Kind == yaml.SequenceNode {
+			for _, item := range items.Content {
+				refs = findObjectImages(refs, file, item)
+			}
+		}
+		return refs
+	}
+	path, ok := podSpecPaths[kind]
+	if !ok {
+		return refs
+	}
+	spec := object
+	for _, key := range path {
+		if spec = mappingValue(spec, key); spec == nil {
+			return refs
+		}
+	}
+	name := kind + "/" + scalarValue(mappingValue(mappingValue(object, "metadata"), "name"))
+	for _, field := range containerFields {
+		containers := mappingValue(spec, field)
+		if containers == nil || containers.Kind != yaml.SequenceNode {
+			continue
+		}
+		for _, container := range containers.Content {
+			image := mappingValue(container, "image")
+			if image == nil || image.Kind != yaml.ScalarNode {
+				continue
+			}
+			refs = append(refs, KubeImageRef{
+				File:   file,
+				Line:   image.Line,
+				Column: image.Column,
+				Object: name,
+				Image:  image.Value,
+				node:   image,
+			})
+		}
+	}
+	return refs
+}
+
+// mappingValue returns the value of a key of a YAML mapping, or nil.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func scalarValue(node *yaml.Node) string {
+	if node == nil || node.Kind != yaml.ScalarNode {
+		return ""
+	}
+	return node.Value
+}
+
+// RewriteKubeImages rewrites the image references of a manifest (see
+// FindKubeImages) for which fn returns a new reference. Only the references
+// themselves are replaced, keeping their quoting, so the formatting and
+// comments of the file are preserved.
+func RewriteKubeImages(file string, content []byte, fn func(ref KubeImageRef) (string, bool)) ([]byte, []KubeImageChange, error) {
+	refs, err := FindKubeImages(file, content)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	type edit struct {
+		start, end int
+		text       string
+	}
+	edits := make([]edit, 0)
+	changes := make([]KubeImageChange, 0)
+	for _, ref := range refs {
+		image, ok := fn(ref)
+		if !ok || image == ref.Image {
+			continue
+		}
+		var quote string
+		switch ref.node.Style {
+		case 0:
+		case yaml.DoubleQuotedStyle:
+			quote = `"`
+		case yaml.SingleQuotedStyle:
+			quote = `'`
+		default:
+			return nil, nil, fmt.Errorf("%v:%v: cannot rewrite image %q written as a block scalar", file, ref.Line, ref.Image)
+		}
+		start := lineColumnOffset(content, ref.Line, ref.Column)
+		raw := quote + ref.Image + quote
+		if start < 0 || !bytes.HasPrefix(content[start:], []byte(raw)) {
+			return nil, nil, fmt.Errorf("%v:%v: cannot rewrite image %q (escaped or multi-line value)", file, ref.Line, ref.Image)
+		}
+		edits = append(edits, edit{start, start + len(raw), quote + image + quote})
+		changes = append(changes, KubeImageChange{ref, image})
+	}
+
+	// Apply the edits from the end, so that offsets stay valid.
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start > edits[j].start })
+	rewritten := append([]byte(nil), content...)
+	for _, e := range edits {
+		rewritten = append(rewritten[:e.start], append([]byte(e.text), rewritten[e.end:]...)...)
+	}
+	return rewritten, changes, nil
+}
+
+// lineColumnOffset returns the byte offset of a (1-based) line and column of
+// YAML content, counting columns in characters as the YAML parser does, or -1.
+func lineColumnOffset(content []byte, line, column int) int {
+	offset := 0
+	for l := 1; l < line; l++ {
+		i := bytes.IndexByte(content[offset:], '\n')
+		if i < 0 {
+			return -1
+		}
+		offset += i + 1
+	}
+	for c := 1; c < column; c++ {
+		if offset >= len(content) || content[offset] == '\n' {
+			return -1
+		}
+		_, size := utf8.DecodeRune(content[offset:])
+		offset += size
+	}
+	return offset
+}
+
+// ImageMapping maps image references to new ones. A mapping whose source has
+// neither tag nor digest applies to every tag of the repository, and keeps the
+// tag (and digest) unless the target has its own.
+type ImageMapping struct {
+	exact map[string]string
+	repos map[string]string
+}
+
+// ParseImageMapping parses OLD=NEW pairs.
+func ParseImageMapping(pairs []string) (*ImageMapping, error) {
+	m := &ImageMapping{exact: make(map[string]string), repos: make(map[string]string)}
+	for _, pair := range pairs {
+		if err := m.add(pair); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// ReadImageMappingFile adds the OLD=NEW pairs of a file, one per line, to the
+// mapping. Blank lines and lines starting with '#' are ignored.
+func (m *ImageMapping) ReadImageMappingFile(path string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := m.add(line); err != nil {
+			return fmt.Errorf("%v:%v: %v", path, n, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func (m *ImageMapping) add(pair string) error {
+	kv := strings.SplitN(pair, "=", 2)
+	if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+		return fmt.Errorf("invalid image mapping %q (must be OLD=NEW)", pair)
+	}
+	from, err := reference.ParseNormalizedNamed(kv[0])
+	if err != nil {
+		return fmt.Errorf("invalid image mapping %q: %v", pair, err)
+	}
+	if _, err := reference.ParseNormalizedNamed(kv[1]); err != nil {
+		return fmt.Errorf("invalid image mapping %q: %v", pair, err)
+	}
+	if reference.IsNameOnly(from) {
+		m.repos[from.Name()] = kv[1]
+	} else {
+		m.exact[from.String()] = kv[1]
+	}
+	return nil
+}
+
+// Len returns the number of mappings.
+func (m *ImageMapping) Len() int {
+	return len(m.exact) + len(m.repos)
+}
+
+// Map returns the new reference of an image, if it is mapped. Exact mappings
+// take precedence over repository mappings; an image without tag or digest
+// matches the exact mappings of its "latest" tag.
+func (m *ImageMapping) Map(image string) (string, bool) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", false
+	}
+	if to, ok := m.exact[reference.TagNameOnly(named).String()]; ok {
+		return to, true
+	}
+	to, ok := m.repos[named.Name()]
+	if !ok {
+		return "", false
+	}
+	if target, err := reference.ParseNormalizedNamed(to); err == nil && !reference.IsNameOnly(target) {
+		return to, true
+	}
+	if tagged, ok := named.(reference.Tagged); ok {
+		to += ":" + tagged.Tag()
+	}
+	if digested, ok := named.(reference.Digested); ok {
+		to += "@" + digested.Digest().String()
+	}
+	return to, true
+}
+
+// PushResultMappings returns the OLD=NEW mappings recorded for pushed images,
+// given the pinned reference each of them was pushed as: every other name of
+// a pushed image (e.g. the one it had before 'ply docker-regex set-path-prefix'
+// or 'tag-suffix append') maps to what was pushed, as does the pushed name
+// itself. Names of an image that was pushed under several names are
+// ambiguous and only map to themselves.
+func PushResultMappings(images ImageMap, pinned map[string]string) []string {
+	mappings := make([]string, 0)
+	sources := make(map[string][]string)
+	for _, name := range images.SortedNames() {
+		to, ok := pinned[name]
+		if !ok {
+			continue
+		}
+		mappings = append(mappings, name+"="+to)
+		for _, repoTag := range images[name].RepoTags {
+			if _, pushed := images[repoTag]; !pushed {
+				sources[repoTag] = append(sources[repoTag], to)
+			}
+		}
+	}
+	renamed := make([]string, 0)
+	for source, to := range sources {
+		if len(to) > 1 {
+			fmt.Printf("warning: %v was pushed as %v; it is left out of the results\n", source, strings.Join(to, ", "))
+			continue
+		}
+		renamed = append(renamed, source+"="+to[0])
+	}
+	sort.Strings(renamed)
+	return append(mappings, renamed...)
+}
+
+// KustomizeImage is an entry of the images field of a kustomization, which
+// overrides the name, tag or digest of the images of its resources.
+type KustomizeImage struct {
+	Name    string `yaml:"name"`
+	NewName string `yaml:"newName"`
+	NewTag  string `yaml:"newTag"`
+	Digest  string `yaml:"digest"`
+}
+
+// kustomizationFiles are the names kustomize looks for in a directory.
+var kustomizationFiles = map[string]bool{
+	"kustomization.yaml": true,
+	"kustomization.yml":  true,
+	"Kustomization":      true,
+}
+
+// FindKustomizeImages returns the image overrides of the kustomizations below
+// a directory.
+func FindKustomizeImages(dir string) ([]KustomizeImage, error) {
+	images := make([]KustomizeImage, 0)
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if p != dir && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !kustomizationFiles[info.Name()] {
+			return nil
+		}
+		content, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		var kustomization struct {
+			Images []KustomizeImage `yaml:"images"`
+		}
+		if err := yaml.Unmarshal(content, &kustomization); err != nil {
+			return fmt.Errorf("%v: %v", p, err)
+		}
+		images = append(images, kustomization.Images...)
+		return nil
+	})
+	return images, err
+}
+
+// Apply returns the image an override turns an image into, if it applies to
+// it. The tag is kept along with the digest, if any.
+func (k KustomizeImage) Apply(image string) (string, bool) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", false
+	}
+	name, err := reference.ParseNormalizedNamed(k.Name)
+	if err != nil || name.Name() != named.Name() {
+		return "", false
+	}
+	return k.image(named), true
+}
+
+// image returns the image an override turns an image of its name into.
+func (k KustomizeImage) image(named reference.Named) string {
+	image := k.Name
+	if named != nil {
+		image = reference.FamiliarName(named)
+	}
+	if k.NewName != "" {
+		image = k.NewName
+	}
+	if k.NewTag != "" {
+		image += ":" + k.NewTag
+	} else if tagged, ok := named.(reference.Tagged); ok {
+		image += ":" + tagged.Tag()
+	}
+	if k.Digest != "" {
+		image += "@" + k.Digest
+	} else if digested, ok := named.(reference.Digested); ok && k.NewTag == "" {
+		image += "@" + digested.Digest().String()
+	}
+	return image
+}
+
+// ManifestImages returns the images referenced by the Kubernetes manifests of
+// a directory, with the kustomize image overrides found there applied.
+// Overrides that apply to no image of the directory (e.g. to the resources of
+// a base elsewhere) are included if they set a tag.
+func ManifestImages(dir string) ([]string, error) {
+	refs, err := FindKubeImagesInDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	overrides, err := FindKustomizeImages(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	images := make([]string, 0)
+	add := func(image string) {
+		if !seen[image] {
+			seen[image] = true
+			images = append(images, image)
+		}
+	}
+	used := make([]bool, len(overrides))
+	for _, ref := range refs {
+		image := ref.Image
+		for i, k := range overrides {
+			if overridden, ok := k.Apply(ref.Image); ok {
+				image = overridden
+				used[i] = true
+				break
+			}
+		}
+		add(image)
+	}
+	for i, k := range overrides {
+		if !used[i] && k.NewTag != "" {
+			add(k.image(nil))
+		}
+	}
+	sort.Strings(images)
+	return images, nil
+}
+
+// ManifestImagesRegex returns a regex matching exactly the local names (as in
+// RepoTags) of the images referenced by the manifests of a directory (see
+// ManifestImages). Images referenced only by digest cannot be matched and are
+// returned as skipped.
+func ManifestImagesRegex(dir string) (string, []string, []string, error) {
+	images, err := ManifestImages(dir)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	names := make([]string, 0)
+	skipped := make([]string, 0)
+	quoted := make([]string, 0)
+	for _, image := range images {
+		named, err := reference.ParseNormalizedNamed(image)
+		if err != nil {
+			return "", nil, nil, fmt.Errorf("invalid image %q: %v", image, err)
+		}
+		_, tagged := named.(reference.Tagged)
+		if _, digested := named.(reference.Digested); digested && !tagged {
+			skipped = append(skipped, image)
+			continue
+		}
+		name := reference.FamiliarString(reference.TagNameOnly(reference.TrimNamed(named)))
+		if tagged {
+			name = reference.FamiliarName(named) + ":" + named.(reference.Tagged).Tag()
+		}
+		names = append(names, name)
+		quoted = append(quoted, regexp.QuoteMeta(name))
+	}
+	if len(names) == 0 {
+		return "", nil, skipped, fmt.Errorf("no tagged images are referenced by the manifests in %v", dir)
+	}
+	return "^(" + strings.Join(quoted, "|") + ")$", names, skipped, nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestImageMapping(t *testing.T) {
+	const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	// As written by 'ply docker-regex push --results'.
+	pushResults := "gcr.io/new/addon:v1=gcr.io/new/addon:v1@" + digest + "\n"
+	tests := []struct {
+		name    string
+		pairs   []string
+		file    string
+		image   string
+		want    string
+		wantErr bool
+	}{
+		{name: "exact", pairs: []string{"addon:v1=gcr.io/new/addon:v2"}, image: "docker.io/library/addon:v1", want: "gcr.io/new/addon:v2"},
+		{name: "exact latest", pairs: []string{"addon:latest=gcr.io/new/addon:v2"}, image: "addon", want: "gcr.io/new/addon:v2"},
+		{name: "repository keeps the tag", pairs: []string{"gcr.io/old/addon=gcr.io/new/addon"}, image: "gcr.io/old/addon:v1", want: "gcr.io/new/addon:v1"},
+		{name: "repository keeps the digest", pairs: []string{"gcr.io/old/addon=gcr.io/new/addon"}, image: "gcr.io/old/addon@" + digest, want: "gcr.io/new/addon@" + digest},
+		{name: "exact before repository", pairs: []string{"gcr.io/old/addon=gcr.io/new/addon", "gcr.io/old/addon:v1=gcr.io/new/other:v1"}, image: "gcr.io/old/addon:v1", want: "gcr.io/new/other:v1"},
+		{name: "not mapped", pairs: []string{"gcr.io/old/addon=gcr.io/new/addon"}, image: "gcr.io/old/other:v1"},
+		{name: "push results", file: pushResults, image: "gcr.io/new/addon:v1", want: "gcr.io/new/addon:v1@" + digest},
+		{name: "push results other tag", file: pushResults, image: "gcr.io/new/addon:v2"},
+		{name: "comments and blank lines", file: "# pushed\n\n" + pushResults, image: "gcr.io/new/addon:v1", want: "gcr.io/new/addon:v1@" + digest},
+		{name: "invalid pair", pairs: []string{"gcr.io/old/addon"}, wantErr: true},
+		{name: "invalid file", file: "gcr.io/old/addon=\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ParseImageMapping(tt.pairs)
+			if err == nil && tt.file != "" {
+				path := filepath.Join(t.TempDir(), "mapping.txt")
+				if err := ioutil.WriteFile(path, []byte(tt.file), 0644); err != nil {
+					t.Fatal(err)
+				}
+				err = m.ReadImageMappingFile(path)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error: %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got, ok := m.Map(tt.image)
+			if ok != (tt.want != "") || got != tt.want {
+				t.Errorf("Map(%v) = %q, %v; want %q", tt.image, got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestPushResultMappings(t *testing.T) {
+	const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	image := func(repoTags ...string) Image {
+		return Image{ImageSummary: types.ImageSummary{RepoTags: repoTags}}
+	}
+	tests := []struct {
+		name   string
+		images ImageMap
+		// mapped are the images expected to map, to the pinned name they
+		// were pushed as.
+		mapped map[string]string
+		// unmapped are names that must not map.
+		unmapped []string
+	}{
+		{
+			name:   "pushed as is",
+			images: ImageMap{"gcr.io/new/addon:v1": image("gcr.io/new/addon:v1")},
+			mapped: map[string]string{"gcr.io/new/addon:v1": "gcr.io/new/addon:v1"},
+		},
+		{
+			name: "renamed with a path prefix and a tag suffix",
+			images: ImageMap{
+				"gcr.io/new/addon:v1-gke.1": image("addon:v1", "gcr.io/new/addon:v1-gke.1"),
+			},
+			mapped: map[string]string{
+				"gcr.io/new/addon:v1-gke.1":  "gcr.io/new/addon:v1-gke.1",
+				"docker.io/library/addon:v1": "gcr.io/new/addon:v1-gke.1",
+			},
+		},
+		{
+			name: "pushed under two names",
+			images: ImageMap{
+				"gcr.io/a/addon:v1": image("addon:v1", "gcr.io/a/addon:v1", "gcr.io/b/addon:v1"),
+				"gcr.io/b/addon:v1": image("addon:v1", "gcr.io/a/addon:v1", "gcr.io/b/addon:v1"),
+			},
+			mapped: map[string]string{
+				"gcr.io/a/addon:v1": "gcr.io/a/addon:v1",
+				"gcr.io/b/addon:v1": "gcr.io/b/addon:v1",
+			},
+			unmapped: []string{"addon:v1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pinned := make(map[string]string)
+			for name := range tt.images {
+				pinned[name] = name + "@" + digest
+			}
+			m, err := ParseImageMapping(PushResultMappings(tt.images, pinned))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for image, pushed := range tt.mapped {
+				if got, ok := m.Map(image); !ok || got != pushed+"@"+digest {
+					t.Errorf("Map(%v) = %q, %v; want %v", image, got, ok, pushed+"@"+digest)
+				}
+			}
+			for _, image := range tt.unmapped {
+				if got, ok := m.Map(image); ok {
+					t.Errorf("Map(%v) = %q, want no mapping", image, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// Whiteout files mark deletions in image layers [1].
+//
+// [1]: https://github.com/opencontainers/image-spec/blob/main/layer.md#whiteouts
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// LayerFile is an entry of an image layer.
+type LayerFile struct {
+	// Path is absolute and cleaned, e.g. "/usr/bin/foo".
+	Path     string
+	Layer    int
+	Size     int64
+	Mode     os.FileMode
+	Typeflag byte
+	Linkname string
+}
+
+// WalkLayer calls fn for every entry of a layer tarball (compressed or not).
+// Paths passed to fn are absolute and cleaned; the reader yields the content
+// of regular files.
+func WalkLayer(layerPath string, fn func(hdr *tar.Header, p string, r io.Reader) error) error {
+	f, err := os.Open(layerPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(hdr, path.Clean("/"+hdr.Name), tr); err != nil {
+			return err
+		}
+	}
+}
+
+// Filesystem returns the files visible in the final image, i.e. after applying
+// every layer (including whiteouts) on top of the previous ones, keyed by path.
+func (a *ImageArchive) Filesystem(m ArchiveManifest) (map[string]LayerFile, error) {
+	fs := make(map[string]LayerFile)
+	for i, layer := range m.Layers {
+		err := WalkLayer(a.Path(layer), func(hdr *tar.Header, p string, r io.Reader) error {
+			dir, base := path.Split(p)
+			switch {
+			case base == whiteoutOpaque:
+				removeTree(fs, path.Clean(dir), i, false)
+			case strings.HasPrefix(base, whiteoutPrefix):
+				removeTree(fs, path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)), i, true)
+			default:
+				fs[p] = LayerFile{
+					Path:     p,
+					Layer:    i,
+					Size:     hdr.Size,
+					Mode:     hdr.FileInfo().Mode(),
+					Typeflag: hdr.Typeflag,
+					Linkname: hdr.Linkname,
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fs, nil
+}
+
+// removeTree deletes a path (if self is set) and everything below it that
+// came from layers before the given one.
+func removeTree(fs map[string]LayerFile, p string, layer int, self bool) {
+	if self {
+		delete(fs, p)
+	}
+	prefix := strings.TrimSuffix(p, "/") + "/"
+	for other, f := range fs {
+		if strings.HasPrefix(other, prefix) && f.Layer < layer {
+			delete(fs, other)
+		}
+	}
+}
+
+// ReadFiles reads the final content of the given files of an image (see
+// Filesystem) and calls fn for each. Every layer is read at most once.
+func (a *ImageArchive) ReadFiles(m ArchiveManifest, files []LayerFile, fn func(f LayerFile, r io.Reader) error) error {
+	byLayer := make(map[int]map[string]LayerFile)
+	for _, f := range files {
+		if byLayer[f.Layer] == nil {
+			byLayer[f.Layer] = make(map[string]LayerFile)
+		}
+		byLayer[f.Layer][f.Path] = f
+	}
+
+	for i, layer := range m.Layers {
+		wanted := byLayer[i]
+		if len(wanted) == 0 {
+			continue
+		}
+		err := WalkLayer(a.Path(layer), func(hdr *tar.Header, p string, r io.Reader) error {
+			f, ok := wanted[p]
+			if !ok || hdr.Typeflag != tar.TypeReg {
+				return nil
+			}
+			return fn(f, r)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
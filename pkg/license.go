@@ -0,0 +1,84 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"regexp"
+	"strings"
+)
+
+// LicenseUnknown is the classification of license texts that match none of
+// the known licenses.
+const LicenseUnknown = "UNKNOWN"
+
+// licensePhrases identifies licenses (by SPDX ID) from phrases that only
+// occur in their text. All phrases of an entry must be present; the first
+// matching entry wins, so more specific licenses come before the ones whose
+// phrases they share (e.g. LGPL before GPL), and permissive licenses come
+// first so that dual-licensed texts are classified by their permissive
+// option. The GNU licenses refer to each other, so their full texts are
+// recognized by their title lines before their names are looked for (as in
+// notices that only name them).
+var licensePhrases = []struct {
+	id      string
+	phrases []string
+}{
+	{"Apache-2.0", []string{"apache license version 2.0"}},
+	{"MIT", []string{"permission is hereby granted free of charge to any person obtaining a copy"}},
+	{"BSD-4-Clause", []string{"redistribution and use in source and binary forms", "all advertising materials mentioning features"}},
+	{"BSD-3-Clause", []string{"redistribution and use in source and binary forms", "used to endorse or promote products derived"}},
+	{"BSD-2-Clause", []string{"redistribution and use in source and binary forms"}},
+	{"ISC", []string{"distribute this software for any purpose with or without fee is hereby granted"}},
+	{"Zlib", []string{"altered source versions must be plainly marked as such"}},
+	{"Unlicense", []string{"this is free and unencumbered software released into the public domain"}},
+	{"CC0-1.0", []string{"cc0 1.0 universal"}},
+	{"MPL-2.0", []string{"mozilla public license version 2.0"}},
+	{"EPL-2.0", []string{"eclipse public license v 2.0"}},
+	{"EPL-1.0", []string{"eclipse public license v 1.0"}},
+	{"AGPL-3.0", []string{"gnu affero general public license version 3 19 november 2007"}},
+	{"LGPL-3.0", []string{"gnu lesser general public license version 3 29 june 2007"}},
+	{"LGPL-2.1", []string{"gnu lesser general public license version 2.1 february 1999"}},
+	{"GPL-3.0", []string{"gnu general public license version 3 29 june 2007"}},
+	{"GPL-2.0", []string{"gnu general public license version 2 june 1991"}},
+	{"AGPL-3.0", []string{"gnu affero general public license"}},
+	{"LGPL-3.0", []string{"gnu lesser general public license", "version 3"}},
+	{"LGPL-2.1", []string{"gnu lesser general public license", "version 2.1"}},
+	{"GPL-3.0", []string{"gnu general public license", "version 3"}},
+	{"GPL-2.0", []string{"gnu general public license", "version 2"}},
+}
+
+var nonWordRegex = regexp.MustCompile(`[^a-z0-9.]+`)
+
+// ClassifyLicense returns the SPDX ID of the license in a license file, or
+// LicenseUnknown.
+func ClassifyLicense(text []byte) string {
+	// Compare words only, so that wrapping, punctuation and markup do not
+	// matter.
+	normalized := " " + nonWordRegex.ReplaceAllString(strings.ToLower(string(text)), " ") + " "
+	normalized = strings.Replace(normalized, ". ", " ", -1)
+	for _, l := range licensePhrases {
+		matched := true
+		for _, phrase := range l.phrases {
+			if !strings.Contains(normalized, " "+phrase+" ") {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return l.id
+		}
+	}
+	return LicenseUnknown
+}
@@ -0,0 +1,138 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const bsdConditions = `Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+`
+
+func TestClassifyLicense(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "apache",
+			text: "Apache License\nVersion 2.0, January 2004\nhttp://www.apache.org/licenses/",
+			want: "Apache-2.0",
+		},
+		{
+			name: "mit",
+			text: "Permission is hereby granted, free of charge, to any person obtaining a copy\nof this software",
+			want: "MIT",
+		},
+		{
+			name: "bsd-2",
+			text: bsdConditions,
+			want: "BSD-2-Clause",
+		},
+		{
+			name: "bsd-3",
+			text: bsdConditions + `3. Neither the name of the copyright holder nor the names of its
+   contributors may be used to endorse or promote products derived from
+   this software without specific prior written permission.`,
+			want: "BSD-3-Clause",
+		},
+		{
+			// As in github.com/ulikunitz/xz.
+			name: "bsd-3 may not be used",
+			text: bsdConditions + `3. Neither the name of the copyright holder nor the names of its
+   contributors may not be used to endorse or promote products derived
+   from this software without specific prior written permission.`,
+			want: "BSD-3-Clause",
+		},
+		{
+			name: "bsd-4",
+			text: bsdConditions + `3. All advertising materials mentioning features or use of this software
+   must display the following acknowledgement.`,
+			want: "BSD-4-Clause",
+		},
+		{
+			name: "lgpl before gpl",
+			text: "GNU LESSER GENERAL PUBLIC LICENSE\nVersion 3, 29 June 2007",
+			want: "LGPL-3.0",
+		},
+		{
+			name: "gpl",
+			text: "GNU GENERAL PUBLIC LICENSE\nVersion 2, June 1991",
+			want: "GPL-2.0",
+		},
+		{
+			name: "gpl notice",
+			text: "This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.",
+			want: "GPL-3.0",
+		},
+		{
+			name: "unknown",
+			text: "All rights reserved.",
+			want: LicenseUnknown,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClassifyLicense([]byte(tt.text)); got != tt.want {
+				t.Errorf("ClassifyLicense() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestClassifyLicenseTexts classifies the full license texts in
+// testdata/licenses, which are named after their SPDX IDs. The GNU licenses
+// mention each other, e.g. the GPL-3.0 names the AGPL and the LGPL.
+func TestClassifyLicenseTexts(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "licenses", "*.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no license texts in testdata/licenses")
+	}
+	for _, path := range paths {
+		want := strings.TrimSuffix(filepath.Base(path), ".txt")
+		t.Run(want, func(t *testing.T) {
+			text, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ClassifyLicense(text); got != want {
+				t.Errorf("ClassifyLicense(%v) = %v, want %v", path, got, want)
+			}
+			// Texts dual-licensed under a GNU license and a permissive one
+			// are classified by their permissive option.
+			if !strings.Contains(want, "GPL") {
+				return
+			}
+			mit := "Permission is hereby granted, free of charge, to any person obtaining a copy\n\n"
+			if got := ClassifyLicense(append([]byte(mit), text...)); got != "MIT" {
+				t.Errorf("ClassifyLicense(MIT + %v) = %v, want MIT", path, got)
+			}
+		})
+	}
+}
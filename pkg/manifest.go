@@ -0,0 +1,152 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+)
+
+// Media types of the manifest formats understood by ply. Docker's schema 2
+// formats [1] and the OCI image formats [2] are structurally identical, so both
+// are decoded into the Manifest type below.
+//
+// [1]: https://docs.docker.com/registry/spec/manifest-v2-2/
+// [2]: https://github.com/opencontainers/image-spec
+const (
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerConfig       = "application/vnd.docker.container.image.v1+json"
+	MediaTypeDockerLayer        = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	MediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+	MediaTypeOCIConfig          = "application/vnd.oci.image.config.v1+json"
+	MediaTypeOCILayer           = "application/vnd.oci.image.layer.v1.tar"
+	MediaTypeOCILayerGzip       = "application/vnd.oci.image.layer.v1.tar+gzip"
+)
+
+// Media types of non-distributable layers, e.g. Windows base layers, which
+// registries do not serve: clients fetch them from their URLs instead.
+const (
+	MediaTypeDockerForeignLayer           = "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip"
+	MediaTypeOCINondistributableLayer     = "application/vnd.oci.image.layer.nondistributable.v1.tar"
+	MediaTypeOCINondistributableLayerGzip = "application/vnd.oci.image.layer.nondistributable.v1.tar+gzip"
+	MediaTypeOCINondistributableLayerZstd = "application/vnd.oci.image.layer.nondistributable.v1.tar+zstd"
+)
+
+// IsNondistributable reports whether a media type is that of a
+// non-distributable (Docker "foreign" or OCI "nondistributable") layer.
+func IsNondistributable(mediaType string) bool {
+	switch mediaType {
+	case MediaTypeDockerForeignLayer,
+		MediaTypeOCINondistributableLayer,
+		MediaTypeOCINondistributableLayerGzip,
+		MediaTypeOCINondistributableLayerZstd:
+		return true
+	}
+	return false
+}
+
+// manifestMediaTypes is the Accept header sent when fetching manifests.
+var manifestMediaTypes = []string{
+	MediaTypeDockerManifestList,
+	MediaTypeDockerManifest,
+	MediaTypeOCIIndex,
+	MediaTypeOCIManifest,
+}
+
+// Descriptor points at a piece of content by digest.
+type Descriptor struct {
+	MediaType    string            `json:"mediaType"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	URLs         []string          `json:"urls,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	Platform     *Platform         `json:"platform,omitempty"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+}
+
+// Platform describes the architecture and OS an image manifest is built for.
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	OSVersion    string `json:"os.version,omitempty"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+func (p Platform) String() string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// Manifest is an image manifest or a manifest list / image index; which fields
+// are set depends on the media type.
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        *Descriptor       `json:"config,omitempty"`
+	Layers        []Descriptor      `json:"layers,omitempty"`
+	Manifests     []Descriptor      `json:"manifests,omitempty"`
+	Subject       *Descriptor       `json:"subject,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// IsIndex reports whether the media type is a manifest list or image index,
+// i.e. a manifest that points at other manifests rather than at layers.
+func IsIndex(mediaType string) bool {
+	return mediaType == MediaTypeDockerManifestList || mediaType == MediaTypeOCIIndex
+}
+
+// ParseManifest decodes a manifest. If the manifest does not declare its media
+// type, the given one (usually the Content-Type of the response it came from)
+// is used.
+func ParseManifest(raw []byte, mediaType string) (*Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, err
+	}
+	if m.MediaType == "" {
+		m.MediaType = mediaType
+	}
+	if m.MediaType == "" {
+		// OCI manifests may omit the media type; tell an index from an
+		// image manifest by its contents.
+		if m.Manifests != nil {
+			m.MediaType = MediaTypeOCIIndex
+		} else {
+			m.MediaType = MediaTypeOCIManifest
+		}
+	}
+	return &m, nil
+}
+
+// Digest returns the sha256 digest of some content, in the "sha256:<hex>"
+// form used by registries.
+func Digest(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// IsDigest reports whether a reference (the part after ':' or '@' in an image
+// name) is a digest rather than a tag.
+func IsDigest(ref string) bool {
+	return strings.HasPrefix(ref, "sha256:")
+}
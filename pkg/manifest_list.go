@@ -0,0 +1,137 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/docker/distribution/reference"
+)
+
+// PlatformImage is an image that has been pushed to a registry, along with the
+// platform it was built for.
+type PlatformImage struct {
+	Name     string
+	Platform Platform
+}
+
+// CreateIndex assembles a manifest list (or, if oci is set or any of the
+// images is an OCI manifest, an OCI image index) out of single-platform
+// images and pushes it as target:tag. Images living in other repositories are
+// first copied into the target repository, since an index can only reference
+// manifests of its own repository. Two different images for the same platform
+// are an error.
+func (c *RegistryClient) CreateIndex(target reference.Named, tag string, images []PlatformImage, oci bool) (Descriptor, error) {
+	byPlatform := make(map[string]Descriptor)
+	names := make(map[string]string)
+	for _, image := range images {
+		named, ref, err := ParseImageReference(image.Name)
+		if err != nil {
+			return Descriptor{}, err
+		}
+		desc, err := c.HeadManifest(named, ref)
+		if err != nil {
+			return Descriptor{}, err
+		}
+		if desc == nil {
+			return Descriptor{}, fmt.Errorf("%v was not found in its registry (push it first)", image.Name)
+		}
+		if IsIndex(desc.MediaType) {
+			return Descriptor{}, fmt.Errorf("%v is already a multi-platform image", image.Name)
+		}
+
+		platform := image.Platform.String()
+		if existing, ok := byPlatform[platform]; ok {
+			if existing.Digest == desc.Digest {
+				continue
+			}
+			return Descriptor{}, fmt.Errorf("both %v and %v are built for %v", names[platform], image.Name, platform)
+		}
+
+		if named.Name() != target.Name() {
+			if _, err := c.Copy(named, desc.Digest, target, desc.Digest); err != nil {
+				return Descriptor{}, err
+			}
+		}
+
+		p := image.Platform
+		desc.Platform = &p
+		byPlatform[platform] = *desc
+		names[platform] = image.Name
+	}
+	if len(byPlatform) == 0 {
+		return Descriptor{}, fmt.Errorf("no images to assemble into %v", ImageString(target, tag))
+	}
+
+	platforms := make([]string, 0)
+	for platform, desc := range byPlatform {
+		platforms = append(platforms, platform)
+		if desc.MediaType == MediaTypeOCIManifest {
+			oci = true
+		}
+	}
+	sort.Strings(platforms)
+
+	index := Manifest{SchemaVersion: 2, MediaType: MediaTypeDockerManifestList}
+	if oci {
+		index.MediaType = MediaTypeOCIIndex
+	}
+	for _, platform := range platforms {
+		index.Manifests = append(index.Manifests, byPlatform[platform])
+	}
+	raw, err := json.MarshalIndent(index, "", "   ")
+	if err != nil {
+		return Descriptor{}, err
+	}
+
+	digest, err := c.PutManifest(target, tag, index.MediaType, raw)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	return Descriptor{MediaType: index.MediaType, Digest: digest, Size: int64(len(raw))}, nil
+}
+
+// Platforms returns the manifests of an image, one per platform. For
+// single-platform images, the platform is read from the image config.
+func (c *RegistryClient) Platforms(named reference.Named, ref string) (Descriptor, []Descriptor, error) {
+	raw, desc, err := c.GetManifest(named, ref)
+	if err != nil {
+		return desc, nil, err
+	}
+	m, err := ParseManifest(raw, desc.MediaType)
+	if err != nil {
+		return desc, nil, err
+	}
+	if IsIndex(m.MediaType) {
+		return desc, m.Manifests, nil
+	}
+
+	if m.Config == nil {
+		return desc, nil, fmt.Errorf("manifest of %v has no config", ImageString(named, ref))
+	}
+	config, err := c.ReadBlob(named, m.Config.Digest)
+	if err != nil {
+		return desc, nil, err
+	}
+	var platform Platform
+	if err := json.Unmarshal(config, &platform); err != nil {
+		return desc, nil, fmt.Errorf("could not parse config of %v: %v", ImageString(named, ref), err)
+	}
+	single := desc
+	single.Platform = &platform
+	return desc, []Descriptor{single}, nil
+}
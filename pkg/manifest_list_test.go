@@ -0,0 +1,161 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCreateIndex(t *testing.T) {
+	linuxARM64 := Platform{OS: "linux", Architecture: "arm64"}
+	tests := []struct {
+		name string
+		// images are "repo:tag" names of images pushed beforehand.
+		images        []string
+		platforms     []Platform
+		wantPlatforms []string
+		wantErr       bool
+	}{
+		{
+			name:          "two platforms",
+			images:        []string{"addon:v1-amd64", "addon:v1-arm64"},
+			platforms:     []Platform{linuxAMD64, linuxARM64},
+			wantPlatforms: []string{"linux/amd64", "linux/arm64"},
+		},
+		{
+			name:          "images of another repository are copied",
+			images:        []string{"staging/addon:v1-arm64", "staging/addon:v1-amd64"},
+			platforms:     []Platform{linuxARM64, linuxAMD64},
+			wantPlatforms: []string{"linux/amd64", "linux/arm64"},
+		},
+		{
+			name:          "same image twice",
+			images:        []string{"addon:v1-amd64", "addon:v1-amd64"},
+			platforms:     []Platform{linuxAMD64, linuxAMD64},
+			wantPlatforms: []string{"linux/amd64"},
+		},
+		{
+			name:      "two images for one platform",
+			images:    []string{"addon:v1-amd64", "addon:v1-arm64"},
+			platforms: []Platform{linuxAMD64, linuxAMD64},
+			wantErr:   true,
+		},
+		{
+			name:      "unknown image",
+			images:    []string{"addon:v1-amd64", "addon:v2-arm64"},
+			platforms: []Platform{linuxAMD64, linuxARM64},
+			wantErr:   true,
+		},
+		{
+			name:    "no images",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRegistry(t, nil)
+			c := NewRegistryClient()
+			pushed := make(map[string]bool)
+			images := make([]PlatformImage, 0)
+			for i, image := range tt.images {
+				named, tag, err := ParseImageReference(r.Host + "/" + image)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// "v2" images are never pushed.
+				if !pushed[image] && tag != "v2-arm64" {
+					pushTestImage(t, c, named, tag, tt.platforms[i], image)
+					pushed[image] = true
+				}
+				images = append(images, PlatformImage{Name: r.Host + "/" + image, Platform: tt.platforms[i]})
+			}
+
+			target := r.Named(t, "addon")
+			desc, err := c.CreateIndex(target, "v1", images, false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CreateIndex() error = %v, want error: %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if desc.MediaType != MediaTypeOCIIndex {
+				t.Errorf("CreateIndex() media type = %v, want %v", desc.MediaType, MediaTypeOCIIndex)
+			}
+
+			index, manifests, err := c.Platforms(target, "v1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if index.Digest != desc.Digest {
+				t.Errorf("v1 digest = %v, want %v", index.Digest, desc.Digest)
+			}
+			platforms := make([]string, 0)
+			for _, m := range manifests {
+				platforms = append(platforms, m.Platform.String())
+				// Every manifest must be in the target repository.
+				if _, _, err := c.Platforms(target, m.Digest); err != nil {
+					t.Errorf("manifest %v of %v: %v", m.Digest, m.Platform, err)
+				}
+			}
+			if !reflect.DeepEqual(platforms, tt.wantPlatforms) {
+				t.Errorf("platforms = %v, want %v", platforms, tt.wantPlatforms)
+			}
+		})
+	}
+}
+
+func TestCreateIndexRejectsIndexes(t *testing.T) {
+	r := newTestRegistry(t, nil)
+	c := NewRegistryClient()
+	named := r.Named(t, "addon")
+	amd64 := pushTestImage(t, c, named, "v1-amd64", linuxAMD64, "amd64")
+	amd64.Platform = &linuxAMD64
+	pushTestManifest(t, c, named, "v1", Manifest{SchemaVersion: 2, MediaType: MediaTypeOCIIndex, Manifests: []Descriptor{amd64}})
+
+	_, err := c.CreateIndex(named, "v2", []PlatformImage{{Name: r.Host + "/addon:v1", Platform: linuxAMD64}}, false)
+	if err == nil {
+		t.Error("CreateIndex() of a multi-platform image succeeded")
+	}
+}
+
+func TestPlatformsOfSingleImage(t *testing.T) {
+	tests := []struct {
+		name     string
+		platform Platform
+	}{
+		{name: "amd64", platform: linuxAMD64},
+		{name: "arm64", platform: Platform{OS: "linux", Architecture: "arm64"}},
+		{name: "windows", platform: Platform{OS: "windows", Architecture: "amd64"}},
+	}
+	r := newTestRegistry(t, nil)
+	c := NewRegistryClient()
+	named := r.Named(t, "addon")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := pushTestImage(t, c, named, tt.name, tt.platform, tt.name)
+			desc, manifests, err := c.Platforms(named, tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if desc.Digest != want.Digest || len(manifests) != 1 {
+				t.Fatalf("Platforms() = %v, %v; want digest %v and one manifest", desc, manifests, want.Digest)
+			}
+			if got := *manifests[0].Platform; got != tt.platform {
+				t.Errorf("platform = %v, want %v", got, tt.platform)
+			}
+		})
+	}
+}
@@ -0,0 +1,454 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// GoModule is a dependency of a Go module.
+type GoModule struct {
+	Path    string
+	Version string
+	// Replace describes the replacement of the module, if any (either
+	// "path version" or a local directory).
+	Replace string
+	// Dir holds the files of the module, in the module cache, vendor/ or a
+	// local replacement.
+	Dir string
+}
+
+func (m GoModule) String() string {
+	if m.Version == "" {
+		return m.Path
+	}
+	return m.Path + " " + m.Version
+}
+
+// ResolveModules lists the Go module in moduleDir itself, which has no version,
+// followed by the modules it depends on, with their versions as selected by
+// the go command.
+//
+// If the module is vendored (vendor/modules.txt exists), the vendored modules
+// are used. Otherwise the module graph is read from go.mod and the go.mod files
+// of dependencies in the module cache; go.sum then tells which modules were
+// actually needed (their content is hashed). Modules missing from the module
+// cache are skipped, as go.sum also covers the tests of dependencies: run 'go
+// mod download' first to include everything.
+func ResolveModules(moduleDir string) ([]GoModule, error) {
+	gomod := filepath.Join(moduleDir, "go.mod")
+	raw, err := ioutil.ReadFile(gomod)
+	if err != nil {
+		return nil, err
+	}
+	mf, err := modfile.Parse(gomod, raw, nil)
+	if err != nil {
+		return nil, err
+	}
+	if mf.Module == nil {
+		return nil, fmt.Errorf("%v has no module directive", gomod)
+	}
+	// The main module's own license goes first.
+	modules := []GoModule{{Path: mf.Module.Mod.Path, Dir: moduleDir}}
+
+	vendored := filepath.Join(moduleDir, "vendor", "modules.txt")
+	if _, err := os.Stat(vendored); err == nil {
+		deps, err := readVendoredModules(moduleDir, vendored)
+		if err != nil {
+			return nil, err
+		}
+		return append(modules, deps...), nil
+	}
+	cache := goModCache()
+
+	replaces := make(map[string]module.Version)
+	for _, r := range mf.Replace {
+		// A replacement without a version applies to every version.
+		replaces[r.Old.Path+"@"+r.Old.Version] = r.New
+	}
+	// replacement returns the module to use in place of m, which is m itself
+	// if it is not replaced.
+	replacement := func(m module.Version) (module.Version, bool) {
+		if r, ok := replaces[m.Path+"@"+m.Version]; ok {
+			return r, true
+		}
+		if r, ok := replaces[m.Path+"@"]; ok {
+			return r, true
+		}
+		return m, false
+	}
+
+	// Starting with go 1.17, go.mod lists every module needed to build the
+	// main module's packages and their versions are final. Before that, the
+	// versions have to be selected from the whole module graph, taking the
+	// highest version required of each module.
+	selected := make(map[string]string)
+	for _, r := range mf.Require {
+		selected[r.Mod.Path] = r.Mod.Version
+	}
+	if mf.Go == nil || semver.Compare("v"+mf.Go.Version, "v1.17") < 0 {
+		queue := make([]module.Version, 0)
+		for _, r := range mf.Require {
+			queue = append(queue, r.Mod)
+		}
+		visited := make(map[module.Version]bool)
+		for len(queue) > 0 {
+			m := queue[0]
+			queue = queue[1:]
+			if visited[m] {
+				continue
+			}
+			visited[m] = true
+			r, replaced := replacement(m)
+			requires, err := readModuleRequires(cache, moduleDir, r, replaced)
+			if err != nil {
+				return nil, err
+			}
+			for _, dep := range requires {
+				if semver.Compare(dep.Version, selected[dep.Path]) > 0 {
+					selected[dep.Path] = dep.Version
+				}
+				queue = append(queue, dep)
+			}
+		}
+	}
+
+	sums, err := readGoSum(filepath.Join(moduleDir, "go.sum"))
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0)
+	for path := range selected {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		version := selected[path]
+		m := GoModule{Path: path, Version: version}
+		r, replaced := replacement(module.Version{Path: path, Version: version})
+		if replaced && r.Version == "" {
+			m.Replace = r.Path
+			m.Dir = r.Path
+			if !filepath.IsAbs(m.Dir) {
+				m.Dir = filepath.Join(moduleDir, m.Dir)
+			}
+		} else {
+			if replaced {
+				m.Replace = r.Path + " " + r.Version
+			}
+			// Modules that the build did not need are not downloaded.
+			if !sums[r.Path+" "+r.Version] {
+				continue
+			}
+			m.Dir, err = moduleCacheDir(cache, r)
+			if err != nil {
+				return nil, err
+			}
+			// go.sum also lists the modules needed to test dependencies,
+			// which 'go build' does not download.
+			if _, err := os.Stat(m.Dir); os.IsNotExist(err) {
+				fmt.Printf("skipping %v (not in the module cache)\n", m)
+				continue
+			}
+		}
+		if _, err := os.Stat(m.Dir); err != nil {
+			return nil, fmt.Errorf("module %v not found in %v", m, m.Dir)
+		}
+		modules = append(modules, m)
+	}
+	return modules, nil
+}
+
+// readVendoredModules parses vendor/modules.txt, in which every vendored
+// module has a line of the form:
+//
+//	# path version [=> replacement [version]]
+func readVendoredModules(moduleDir, modulesTxt string) ([]GoModule, error) {
+	f, err := os.Open(modulesTxt)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	modules := make([]GoModule, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[0] != "#" {
+			continue
+		}
+		m := GoModule{
+			Path:    fields[1],
+			Version: fields[2],
+			Dir:     filepath.Join(moduleDir, "vendor", filepath.FromSlash(fields[1])),
+		}
+		if len(fields) >= 5 && fields[3] == "=>" {
+			m.Replace = strings.Join(fields[4:], " ")
+		} else if len(fields) >= 4 && fields[2] == "=>" {
+			// Replacements of every version have no version on the left.
+			m.Version = ""
+			m.Replace = strings.Join(fields[3:], " ")
+		}
+		modules = append(modules, m)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(modules, func(i, j int) bool {
+		return modules[i].Path < modules[j].Path
+	})
+	return modules, nil
+}
+
+// readModuleRequires returns the requirements of a dependency from its go.mod
+// file. Modules whose go.mod is not in the module cache contribute no
+// requirements.
+func readModuleRequires(cache, moduleDir string, m module.Version, local bool) ([]module.Version, error) {
+	var gomod string
+	if local {
+		gomod = m.Path
+		if !filepath.IsAbs(gomod) {
+			gomod = filepath.Join(moduleDir, gomod)
+		}
+		gomod = filepath.Join(gomod, "go.mod")
+	} else {
+		path, err := module.EscapePath(m.Path)
+		if err != nil {
+			return nil, err
+		}
+		version, err := module.EscapeVersion(m.Version)
+		if err != nil {
+			return nil, err
+		}
+		gomod = filepath.Join(cache, "cache", "download", filepath.FromSlash(path), "@v", version+".mod")
+	}
+	raw, err := ioutil.ReadFile(gomod)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	mf, err := modfile.ParseLax(gomod, raw, nil)
+	if err != nil {
+		return nil, err
+	}
+	requires := make([]module.Version, 0)
+	for _, r := range mf.Require {
+		requires = append(requires, r.Mod)
+	}
+	return requires, nil
+}
+
+// readGoSum returns the "path version" pairs whose content is hashed in a
+// go.sum file (as opposed to only their go.mod).
+func readGoSum(path string) (map[string]bool, error) {
+	sums := make(map[string]bool)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return sums, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 || strings.HasSuffix(fields[1], "/go.mod") {
+			continue
+		}
+		sums[fields[0]+" "+fields[1]] = true
+	}
+	return sums, scanner.Err()
+}
+
+// goModCache returns the module cache directory, the same way the go command
+// finds it.
+func goModCache() string {
+	if cache := os.Getenv("GOMODCACHE"); cache != "" {
+		return cache
+	}
+	if gopath := filepath.SplitList(os.Getenv("GOPATH")); len(gopath) > 0 && gopath[0] != "" {
+		return filepath.Join(gopath[0], "pkg", "mod")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, "go", "pkg", "mod")
+}
+
+// moduleCacheDir returns the directory of a module version in the module
+// cache, where upper case letters are escaped as "!" followed by the lower
+// case letter.
+func moduleCacheDir(cache string, m module.Version) (string, error) {
+	path, err := module.EscapePath(m.Path)
+	if err != nil {
+		return "", err
+	}
+	version, err := module.EscapeVersion(m.Version)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cache, filepath.FromSlash(path)+"@"+version), nil
+}
+
+// Prefixes of the files at the root of a module that hold its license or
+// other legal notices (the go command vendors the same files).
+var (
+	licenseFilePrefixes = []string{"LICENSE", "LICENCE", "COPYING", "COPYLEFT", "UNLICENSE"}
+	noticeFilePrefixes  = []string{"NOTICE", "COPYRIGHT", "PATENTS", "LEGAL"}
+)
+
+// LicenseFile is a license or notice file of a module.
+type LicenseFile struct {
+	Name string
+	// License is the SPDX ID of the license, or LicenseUnknown. It is empty
+	// for notices.
+	License string
+	Text    []byte
+}
+
+// ModuleNotices are the legal files of a module.
+type ModuleNotices struct {
+	GoModule
+	Files []LicenseFile
+}
+
+// Licenses returns the licenses of the module, or LicenseUnknown if it has
+// no recognizable license file.
+func (n ModuleNotices) Licenses() []string {
+	seen := make(map[string]bool)
+	licenses := make([]string, 0)
+	for _, f := range n.Files {
+		if f.License != "" && f.License != LicenseUnknown && !seen[f.License] {
+			seen[f.License] = true
+			licenses = append(licenses, f.License)
+		}
+	}
+	if len(licenses) == 0 {
+		licenses = append(licenses, LicenseUnknown)
+	}
+	return licenses
+}
+
+// CollectNotices reads and classifies the license and notice files at the
+// root of each module.
+func CollectNotices(modules []GoModule) ([]ModuleNotices, error) {
+	notices := make([]ModuleNotices, 0)
+	for _, m := range modules {
+		entries, err := ioutil.ReadDir(m.Dir)
+		if err != nil {
+			return nil, err
+		}
+		n := ModuleNotices{GoModule: m}
+		for _, e := range entries {
+			if !e.Mode().IsRegular() {
+				continue
+			}
+			name := strings.ToUpper(e.Name())
+			isLicense := hasAnyPrefix(name, licenseFilePrefixes)
+			if !isLicense && !hasAnyPrefix(name, noticeFilePrefixes) {
+				continue
+			}
+			text, err := ioutil.ReadFile(filepath.Join(m.Dir, e.Name()))
+			if err != nil {
+				return nil, err
+			}
+			f := LicenseFile{Name: e.Name(), Text: text}
+			if isLicense {
+				f.License = ClassifyLicense(text)
+			}
+			n.Files = append(n.Files, f)
+		}
+		notices = append(notices, n)
+	}
+	return notices, nil
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteNotices writes the texts of the license and notice files of every
+// module, each under a header naming the module.
+func WriteNotices(w io.Writer, notices []ModuleNotices) error {
+	rule := strings.Repeat("=", 80)
+	for _, n := range notices {
+		if _, err := fmt.Fprintf(w, "%v\n= %v (%v)\n%v\n", rule, n.GoModule, strings.Join(n.Licenses(), ", "), rule); err != nil {
+			return err
+		}
+		for _, f := range n.Files {
+			text := strings.TrimRight(string(f.Text), "\n")
+			if _, err := fmt.Fprintf(w, "\n--- %v ---\n\n%v\n", f.Name, text); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type licenseReport struct {
+	Modules []licenseReportModule `json:"modules"`
+}
+
+type licenseReportModule struct {
+	Path     string              `json:"path"`
+	Version  string              `json:"version,omitempty"`
+	Replace  string              `json:"replace,omitempty"`
+	Licenses []string            `json:"licenses"`
+	Files    []licenseReportFile `json:"files"`
+}
+
+type licenseReportFile struct {
+	Name    string `json:"name"`
+	License string `json:"license,omitempty"`
+}
+
+// LicenseReport renders the licenses of the modules as JSON.
+func LicenseReport(notices []ModuleNotices) ([]byte, error) {
+	report := licenseReport{Modules: make([]licenseReportModule, 0)}
+	for _, n := range notices {
+		m := licenseReportModule{
+			Path:     n.Path,
+			Version:  n.Version,
+			Replace:  n.Replace,
+			Licenses: n.Licenses(),
+			Files:    make([]licenseReportFile, 0),
+		}
+		for _, f := range n.Files {
+			m.Files = append(m.Files, licenseReportFile{f.Name, f.License})
+		}
+		report.Modules = append(report.Modules, m)
+	}
+	return json.MarshalIndent(report, "", "  ")
+}
@@ -0,0 +1,335 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+)
+
+// Annotations used in the index.json of an OCI image layout [1] to record the
+// name of each image. "ref.name" is the standard one; containerd additionally
+// records the fully qualified name, which is what we read back if present.
+//
+// [1]: https://github.com/opencontainers/image-spec/blob/main/image-layout.md
+const (
+	AnnotationRefName        = "org.opencontainers.image.ref.name"
+	AnnotationContainerdName = "io.containerd.image.name"
+)
+
+// WriteOCILayout converts the images of a "docker save" archive into an OCI
+// image layout in dir, which is created if needed. Layers are stored
+// uncompressed, so their digests match the diff IDs in the image configs.
+// Every tag of every image becomes an entry of index.json; existing entries
+// for other names are kept, so several saves can share one layout.
+func (a *ImageArchive) WriteOCILayout(dir string) error {
+	if err := os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644); err != nil {
+		return err
+	}
+
+	index, err := readOCIIndex(dir)
+	if os.IsNotExist(err) {
+		index = &Manifest{SchemaVersion: 2, MediaType: MediaTypeOCIIndex}
+	} else if err != nil {
+		return err
+	}
+
+	for _, m := range a.Manifest {
+		config, err := a.ReadConfig(m)
+		if err != nil {
+			return err
+		}
+		configDesc, err := writeOCIBlob(dir, MediaTypeOCIConfig, config)
+		if err != nil {
+			return err
+		}
+
+		manifest := Manifest{SchemaVersion: 2, MediaType: MediaTypeOCIManifest, Config: &configDesc}
+		for _, layer := range m.Layers {
+			desc, err := copyOCIBlob(dir, MediaTypeOCILayer, a.Path(layer))
+			if err != nil {
+				return err
+			}
+			manifest.Layers = append(manifest.Layers, desc)
+		}
+		raw, err := json.Marshal(manifest)
+		if err != nil {
+			return err
+		}
+		desc, err := writeOCIBlob(dir, MediaTypeOCIManifest, raw)
+		if err != nil {
+			return err
+		}
+
+		var platform Platform
+		if err := json.Unmarshal(config, &platform); err == nil && platform.OS != "" {
+			desc.Platform = &platform
+		}
+
+		for _, repoTag := range m.RepoTags {
+			named, err := reference.ParseNormalizedNamed(repoTag)
+			if err != nil {
+				return err
+			}
+			entry := desc
+			entry.Annotations = map[string]string{
+				AnnotationRefName:        reference.FamiliarString(named),
+				AnnotationContainerdName: named.String(),
+			}
+			index.Manifests = replaceIndexEntry(index.Manifests, entry)
+			fmt.Printf("  - %v (%v)\n", repoTag, desc.Digest)
+		}
+	}
+
+	raw, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "index.json"), raw, 0644)
+}
+
+// replaceIndexEntry adds an entry to an index, replacing any entry with the
+// same name. Unnamed entries are never replaced.
+func replaceIndexEntry(entries []Descriptor, entry Descriptor) []Descriptor {
+	name := ociImageName(entry)
+	kept := make([]Descriptor, 0)
+	for _, e := range entries {
+		if name == "" || ociImageName(e) != name {
+			kept = append(kept, e)
+		}
+	}
+	return append(kept, entry)
+}
+
+// ociImageName returns the image name recorded for an index.json entry, or
+// "" if there is none. A bare ref.name (e.g. "v1.2.3", as written by some
+// tools) is not an image name and is ignored.
+func ociImageName(desc Descriptor) string {
+	if name := desc.Annotations[AnnotationContainerdName]; name != "" {
+		return name
+	}
+	name := desc.Annotations[AnnotationRefName]
+	if !strings.ContainsAny(name, "/:") {
+		return ""
+	}
+	return name
+}
+
+func readOCIIndex(dir string) (*Manifest, error) {
+	raw, err := ioutil.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		return nil, err
+	}
+	return ParseManifest(raw, MediaTypeOCIIndex)
+}
+
+func ociBlobPath(dir, digest string) string {
+	return filepath.Join(dir, "blobs", "sha256", strings.TrimPrefix(digest, "sha256:"))
+}
+
+// readOCIBlob reads a blob of an OCI image layout, checking its digest.
+func readOCIBlob(dir, digest string) ([]byte, error) {
+	if !digestRegex.MatchString(digest) {
+		return nil, fmt.Errorf("invalid digest %q", digest)
+	}
+	raw, err := ioutil.ReadFile(ociBlobPath(dir, digest))
+	if err != nil {
+		return nil, err
+	}
+	if Digest(raw) != digest {
+		return nil, fmt.Errorf("blob %v is corrupt", digest)
+	}
+	return raw, nil
+}
+
+// checkOCIBlob checks the digest of a (large) blob without reading it into
+// memory.
+func checkOCIBlob(dir, digest string) error {
+	if !digestRegex.MatchString(digest) {
+		return fmt.Errorf("invalid digest %q", digest)
+	}
+	actual, err := fileDigest(ociBlobPath(dir, digest))
+	if err != nil {
+		return err
+	}
+	if actual != digest {
+		return fmt.Errorf("blob %v is corrupt", digest)
+	}
+	return nil
+}
+
+func writeOCIBlob(dir, mediaType string, content []byte) (Descriptor, error) {
+	desc := Descriptor{MediaType: mediaType, Digest: Digest(content), Size: int64(len(content))}
+	return desc, ioutil.WriteFile(ociBlobPath(dir, desc.Digest), content, 0644)
+}
+
+func copyOCIBlob(dir, mediaType, path string) (Descriptor, error) {
+	digest, err := fileDigest(path)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	desc := Descriptor{MediaType: mediaType, Digest: digest, Size: info.Size()}
+	if _, err := os.Stat(ociBlobPath(dir, digest)); err == nil {
+		return desc, nil
+	}
+	return desc, copyFile(path, ociBlobPath(dir, digest))
+}
+
+// ReadOCILayout converts the images of an OCI image layout into a "docker
+// save" archive that can be loaded into the daemon. For multi-platform
+// images, only the manifest for the given platform is used. Images are tagged
+// with the names recorded in index.json; entries without a name are loaded
+// untagged. Blobs are checked against their digests. Callers must call Close()
+// on the returned archive.
+func ReadOCILayout(dir string, platform Platform) (*ImageArchive, error) {
+	index, err := readOCIIndex(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := ioutil.TempDir("", "ply-image-")
+	if err != nil {
+		return nil, err
+	}
+	archive := &ImageArchive{Dir: tmp}
+
+	// Entries for the same manifest are loaded as one image with several
+	// tags.
+	byDigest := make(map[string]int)
+	for _, entry := range index.Manifests {
+		desc, err := resolveOCIPlatform(dir, entry, platform)
+		if err != nil {
+			archive.Close()
+			return nil, err
+		}
+
+		i, ok := byDigest[desc.Digest]
+		if !ok {
+			m, err := archive.addOCIManifest(dir, desc)
+			if err != nil {
+				archive.Close()
+				return nil, err
+			}
+			archive.Manifest = append(archive.Manifest, m)
+			i = len(archive.Manifest) - 1
+			byDigest[desc.Digest] = i
+		}
+
+		name := ociImageName(entry)
+		if name == "" {
+			fmt.Printf("warning: %v has no image name; it will be loaded untagged\n", entry.Digest)
+			continue
+		}
+		named, err := reference.ParseNormalizedNamed(name)
+		if err != nil {
+			archive.Close()
+			return nil, err
+		}
+		archive.Manifest[i].RepoTags = append(archive.Manifest[i].RepoTags, reference.FamiliarString(reference.TagNameOnly(named)))
+	}
+	return archive, nil
+}
+
+// resolveOCIPlatform follows an image index down to the image manifest for
+// the given platform.
+func resolveOCIPlatform(dir string, desc Descriptor, platform Platform) (Descriptor, error) {
+	for IsIndex(desc.MediaType) {
+		raw, err := readOCIBlob(dir, desc.Digest)
+		if err != nil {
+			return desc, err
+		}
+		index, err := ParseManifest(raw, desc.MediaType)
+		if err != nil {
+			return desc, err
+		}
+		found := false
+		for _, child := range index.Manifests {
+			if child.Platform != nil && child.Platform.Matches(platform) {
+				desc = child
+				found = true
+				break
+			}
+		}
+		if !found {
+			return desc, fmt.Errorf("index %v has no manifest for %v", desc.Digest, platform)
+		}
+	}
+	return desc, nil
+}
+
+// addOCIManifest copies the config and layers of an OCI image manifest into
+// the archive, laid out as "docker save" does.
+func (a *ImageArchive) addOCIManifest(dir string, desc Descriptor) (ArchiveManifest, error) {
+	raw, err := readOCIBlob(dir, desc.Digest)
+	if err != nil {
+		return ArchiveManifest{}, err
+	}
+	m, err := ParseManifest(raw, desc.MediaType)
+	if err != nil {
+		return ArchiveManifest{}, err
+	}
+	if m.Config == nil {
+		return ArchiveManifest{}, fmt.Errorf("manifest %v has no config", desc.Digest)
+	}
+
+	config, err := readOCIBlob(dir, m.Config.Digest)
+	if err != nil {
+		return ArchiveManifest{}, err
+	}
+	name, err := a.writeConfig(config)
+	if err != nil {
+		return ArchiveManifest{}, err
+	}
+	entry := ArchiveManifest{Config: name}
+
+	for _, layer := range m.Layers {
+		if err := checkOCIBlob(dir, layer.Digest); err != nil {
+			return ArchiveManifest{}, err
+		}
+		// "docker load" decompresses layer.tar as needed, so compressed
+		// layers can be used as they are.
+		name := strings.TrimPrefix(layer.Digest, "sha256:") + "/layer.tar"
+		if _, err := os.Stat(a.Path(name)); os.IsNotExist(err) {
+			if err := os.MkdirAll(filepath.Dir(a.Path(name)), 0755); err != nil {
+				return ArchiveManifest{}, err
+			}
+			if err := linkOrCopy(ociBlobPath(dir, layer.Digest), a.Path(name)); err != nil {
+				return ArchiveManifest{}, err
+			}
+		}
+		entry.Layers = append(entry.Layers, name)
+	}
+	return entry, nil
+}
+
+func linkOrCopy(from, to string) error {
+	if err := os.Link(from, to); err == nil {
+		return nil
+	}
+	return copyFile(from, to)
+}
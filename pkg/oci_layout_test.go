@@ -0,0 +1,176 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReplaceIndexEntry(t *testing.T) {
+	entry := func(digest, refName, containerdName string) Descriptor {
+		annotations := make(map[string]string)
+		if refName != "" {
+			annotations[AnnotationRefName] = refName
+		}
+		if containerdName != "" {
+			annotations[AnnotationContainerdName] = containerdName
+		}
+		return Descriptor{Digest: digest, Annotations: annotations}
+	}
+	unnamed := entry("sha256:a", "", "")
+	bareRef := entry("sha256:b", "v1.2.3", "")
+	named := entry("sha256:c", "addon:v1", "docker.io/library/addon:v1")
+	tests := []struct {
+		name    string
+		entries []Descriptor
+		entry   Descriptor
+		want    []string
+	}{
+		{
+			name:    "same name is replaced",
+			entries: []Descriptor{unnamed, named},
+			entry:   entry("sha256:d", "addon:v1", "docker.io/library/addon:v1"),
+			want:    []string{"sha256:a", "sha256:d"},
+		},
+		{
+			name:    "other names are kept",
+			entries: []Descriptor{named},
+			entry:   entry("sha256:d", "addon:v2", "docker.io/library/addon:v2"),
+			want:    []string{"sha256:c", "sha256:d"},
+		},
+		{
+			name:    "unnamed entries are kept",
+			entries: []Descriptor{unnamed, bareRef, named},
+			entry:   entry("sha256:d", "", ""),
+			want:    []string{"sha256:a", "sha256:b", "sha256:c", "sha256:d"},
+		},
+		{
+			name:    "bare ref.name entries are kept",
+			entries: []Descriptor{unnamed, bareRef},
+			entry:   entry("sha256:d", "v1.2.3", ""),
+			want:    []string{"sha256:a", "sha256:b", "sha256:d"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			digests := make([]string, 0)
+			for _, e := range replaceIndexEntry(tt.entries, tt.entry) {
+				digests = append(digests, e.Digest)
+			}
+			if !reflect.DeepEqual(digests, tt.want) {
+				t.Errorf("replaceIndexEntry() = %v, want %v", digests, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadOCILayout(t *testing.T) {
+	tests := []struct {
+		name string
+		// corrupt alters the layout after the blobs are written.
+		corrupt func(t *testing.T, dir string, m *Manifest, index *Manifest)
+		wantErr bool
+	}{
+		{name: "valid"},
+		{
+			name: "corrupt config",
+			corrupt: func(t *testing.T, dir string, m *Manifest, index *Manifest) {
+				writeTestFile(t, ociBlobPath(dir, m.Config.Digest), "{}")
+			},
+			wantErr: true,
+		},
+		{
+			name: "corrupt layer",
+			corrupt: func(t *testing.T, dir string, m *Manifest, index *Manifest) {
+				writeTestFile(t, ociBlobPath(dir, m.Layers[0].Digest), "other")
+			},
+			wantErr: true,
+		},
+		{
+			name: "manifest digest outside the layout",
+			corrupt: func(t *testing.T, dir string, m *Manifest, index *Manifest) {
+				index.Manifests[0].Digest = "sha256:../../../etc/passwd"
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid index digest",
+			corrupt: func(t *testing.T, dir string, m *Manifest, index *Manifest) {
+				index.Manifests[0].MediaType = MediaTypeOCIIndex
+				index.Manifests[0].Digest = "sha256:../index"
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			config, err := writeOCIBlob(dir, MediaTypeOCIConfig, []byte(`{"architecture":"amd64","os":"linux"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			layer, err := writeOCIBlob(dir, MediaTypeOCILayer, []byte("layer"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := &Manifest{SchemaVersion: 2, MediaType: MediaTypeOCIManifest, Config: &config, Layers: []Descriptor{layer}}
+			raw, err := json.Marshal(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			desc, err := writeOCIBlob(dir, MediaTypeOCIManifest, raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			desc.Annotations = map[string]string{AnnotationRefName: "addon:v1"}
+			index := &Manifest{SchemaVersion: 2, MediaType: MediaTypeOCIIndex, Manifests: []Descriptor{desc}}
+			if tt.corrupt != nil {
+				tt.corrupt(t, dir, m, index)
+			}
+			rawIndex, err := json.Marshal(index)
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeTestFile(t, filepath.Join(dir, "index.json"), string(rawIndex))
+
+			archive, err := ReadOCILayout(dir, linuxAMD64)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadOCILayout() error = %v, want error: %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer archive.Close()
+			if len(archive.Manifest) != 1 || !reflect.DeepEqual(archive.Manifest[0].RepoTags, []string{"addon:v1"}) {
+				t.Errorf("ReadOCILayout() = %+v, want addon:v1", archive.Manifest)
+			}
+		})
+	}
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/client"
+)
+
+// DigestResolver resolves image tags to manifest digests: from the
+// RepoDigests of the local image if it has one for the repository, else from
+// the registry. Results are cached.
+type DigestResolver struct {
+	dcli  *client.Client
+	rcli  *RegistryClient
+	cache map[string]string
+}
+
+// NewDigestResolver returns a resolver. The daemon client may be nil to only
+// ask registries.
+func NewDigestResolver(dcli *client.Client, rcli *RegistryClient) *DigestResolver {
+	return &DigestResolver{dcli: dcli, rcli: rcli, cache: make(map[string]string)}
+}
+
+// Resolve returns the digest of the manifest a tag points to.
+func (r *DigestResolver) Resolve(named reference.Named, tag string) (string, error) {
+	image := ImageString(named, tag)
+	if digest, ok := r.cache[image]; ok {
+		return digest, nil
+	}
+	digest, err := r.localDigest(named, image)
+	if err != nil {
+		return "", err
+	}
+	if digest == "" {
+		desc, err := r.rcli.HeadManifest(named, tag)
+		if err != nil {
+			return "", err
+		}
+		if desc == nil {
+			return "", fmt.Errorf("%v not found locally or in its registry", image)
+		}
+		digest = desc.Digest
+	}
+	r.cache[image] = digest
+	return digest, nil
+}
+
+// localDigest returns the repository digest of a local image, or "" if there
+// is no such image, it was never pushed to (or pulled from) the repository,
+// or the daemon is not running.
+func (r *DigestResolver) localDigest(named reference.Named, image string) (string, error) {
+	if r.dcli == nil {
+		return "", nil
+	}
+	inspect, _, err := r.dcli.ImageInspectWithRaw(context.Background(), image)
+	if client.IsErrNotFound(err) || client.IsErrConnectionFailed(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	for _, repoDigest := range inspect.RepoDigests {
+		digested, err := reference.ParseNormalizedNamed(repoDigest)
+		if err != nil {
+			continue
+		}
+		if d, ok := digested.(reference.Digested); ok && digested.Name() == named.Name() {
+			return d.Digest().String(), nil
+		}
+	}
+	return "", nil
+}
+
+// Pin returns an image reference pinned to the digest its tag (or "latest")
+// currently resolves to, e.g. "foo:v1@sha256:...". References already pinned
+// are updated if the tag has moved; references with only a digest are
+// returned unchanged.
+func (r *DigestResolver) Pin(image string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", fmt.Errorf("invalid image %q: %v", image, err)
+	}
+	_, tagged := named.(reference.Tagged)
+	if _, digested := named.(reference.Digested); digested && !tagged {
+		return image, nil
+	}
+
+	tag := "latest"
+	if tagged {
+		tag = named.(reference.Tagged).Tag()
+	}
+	digest, err := r.Resolve(reference.TrimNamed(named), tag)
+	if err != nil {
+		return "", err
+	}
+	// Keep the reference as written, only replacing the digest.
+	return strings.SplitN(image, "@", 2)[0] + "@" + digest, nil
+}
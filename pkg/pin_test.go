@@ -0,0 +1,81 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import "testing"
+
+func TestDigestResolverPin(t *testing.T) {
+	r := newTestRegistry(t, nil)
+	c := NewRegistryClient()
+	named := r.Named(t, "addon")
+	v1 := pushTestImage(t, c, named, "v1", linuxAMD64, "v1").Digest
+	latest := pushTestImage(t, c, named, "latest", linuxAMD64, "latest").Digest
+	old := pushTestImage(t, c, named, "", linuxAMD64, "old").Digest
+	addon := r.Host + "/addon"
+
+	tests := []struct {
+		name    string
+		image   string
+		want    string
+		wantErr bool
+	}{
+		{name: "tag", image: addon + ":v1", want: addon + ":v1@" + v1},
+		{name: "no tag is latest", image: addon, want: addon + "@" + latest},
+		{name: "pinned to an old digest", image: addon + ":v1@" + old, want: addon + ":v1@" + v1},
+		{name: "pinned to the current digest", image: addon + ":v1@" + v1, want: addon + ":v1@" + v1},
+		{name: "digest only", image: addon + "@" + old, want: addon + "@" + old},
+		{name: "unknown tag", image: addon + ":v2", wantErr: true},
+		{name: "unknown repository", image: r.Host + "/other:v1", wantErr: true},
+		{name: "invalid reference", image: addon + ":-v1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewDigestResolver(nil, c).Pin(tt.image)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Pin(%v) error = %v, want error: %v", tt.image, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Pin(%v) = %v, want %v", tt.image, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDigestResolverCache(t *testing.T) {
+	r := newTestRegistry(t, nil)
+	c := NewRegistryClient()
+	named := r.Named(t, "addon")
+	v1 := pushTestImage(t, c, named, "v1", linuxAMD64, "v1").Digest
+
+	resolver := NewDigestResolver(nil, c)
+	for i := 0; i < 2; i++ {
+		digest, err := resolver.Resolve(named, "v1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if digest != v1 {
+			t.Errorf("Resolve() = %v, want %v", digest, v1)
+		}
+		// Moving the tag does not change what was resolved.
+		pushTestImage(t, c, named, "v1", linuxAMD64, "moved")
+	}
+	r.ResetRequests()
+	if _, err := resolver.Resolve(named, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if requests := r.Requests("/manifests/"); len(requests) != 0 {
+		t.Errorf("cached tag resolved again: %v", requests)
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	units "github.com/docker/go-units"
+	"gopkg.in/yaml.v3"
+)
+
+// LabelBaseDigest is the label recording the digest of the base image an image
+// was built from [1].
+//
+// [1]: https://github.com/opencontainers/image-spec/blob/main/annotations.md
+const LabelBaseDigest = "org.opencontainers.image.base.digest"
+
+// Policy holds the rules images must follow before they are pushed. Rules that
+// are not set are not checked. A policy file looks like:
+//
+//	requiredLabels: [org.opencontainers.image.source]
+//	forbidLatest: true
+//	allowedRegistries: [gcr.io/my-project/]
+//	maxSize: 500MB
+//	requireNonRoot: true
+//	allowedBaseDigests: ["sha256:..."]
+type Policy struct {
+	// RequiredLabels must be set (to a non-empty value) on every image.
+	RequiredLabels []string `yaml:"requiredLabels"`
+	// ForbidLatest rejects images tagged "latest".
+	ForbidLatest bool `yaml:"forbidLatest"`
+	// AllowedRegistries are prefixes of the full image names (e.g.
+	// "gcr.io/my-project/") that images must start with.
+	AllowedRegistries []string `yaml:"allowedRegistries"`
+	// MaxSize is the largest allowed (uncompressed) image size.
+	MaxSize ByteSize `yaml:"maxSize"`
+	// RequireNonRoot rejects images that run as root, including images that
+	// set no USER.
+	RequireNonRoot bool `yaml:"requireNonRoot"`
+	// AllowedBaseDigests are the base images that images may be built on,
+	// as recorded in their LabelBaseDigest label.
+	AllowedBaseDigests []string `yaml:"allowedBaseDigests"`
+}
+
+// ByteSize is a size in bytes, written either as a number or with a (decimal)
+// unit, e.g. "500MB".
+type ByteSize int64
+
+func (s *ByteSize) UnmarshalYAML(node *yaml.Node) error {
+	size, err := units.FromHumanSize(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %v: invalid size %q", node.Line, node.Value)
+	}
+	*s = ByteSize(size)
+	return nil
+}
+
+// LoadPolicy reads a policy from a YAML file. Unknown keys are rejected, so
+// that misspelled rules are not silently ignored.
+func LoadPolicy(path string) (*Policy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	var p Policy
+	if err := decoder.Decode(&p); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return &p, nil
+}
+
+// Violation is a rule of a policy that an image breaks.
+type Violation struct {
+	Rule    string
+	Message string
+}
+
+func (v Violation) String() string {
+	return v.Rule + ": " + v.Message
+}
+
+// Check returns the rules of the policy that an image (by one of its names)
+// breaks.
+func (p *Policy) Check(name string, image Image) []Violation {
+	violations := make([]Violation, 0)
+	add := func(rule, format string, a ...interface{}) {
+		violations = append(violations, Violation{rule, fmt.Sprintf(format, a...)})
+	}
+
+	for _, label := range p.RequiredLabels {
+		if image.Labels[label] == "" {
+			add("requiredLabels", "label %v is not set", label)
+		}
+	}
+
+	named, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		add("name", "invalid image name: %v", err)
+		return violations
+	}
+	if tagged, ok := named.(reference.Tagged); p.ForbidLatest && ok && tagged.Tag() == "latest" {
+		add("forbidLatest", "tag 'latest' is not allowed")
+	}
+	if len(p.AllowedRegistries) > 0 {
+		allowed := false
+		for _, prefix := range p.AllowedRegistries {
+			if strings.HasPrefix(named.Name(), prefix) || strings.HasPrefix(reference.FamiliarName(named), prefix) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			add("allowedRegistries", "%v is not under %v", named.Name(), strings.Join(p.AllowedRegistries, ", "))
+		}
+	}
+
+	if p.MaxSize > 0 && image.Size > int64(p.MaxSize) {
+		add("maxSize", "size %v exceeds %v", units.HumanSize(float64(image.Size)), units.HumanSize(float64(p.MaxSize)))
+	}
+
+	if p.RequireNonRoot && isRootUser(image.User) {
+		user := image.User
+		if user == "" {
+			user = "unset, i.e. root"
+		}
+		add("requireNonRoot", "image runs as root (USER %v)", user)
+	}
+
+	if len(p.AllowedBaseDigests) > 0 {
+		base := image.Labels[LabelBaseDigest]
+		allowed := false
+		for _, digest := range p.AllowedBaseDigests {
+			if base == digest {
+				allowed = true
+				break
+			}
+		}
+		if base == "" {
+			add("allowedBaseDigests", "base image is unknown (label %v is not set)", LabelBaseDigest)
+		} else if !allowed {
+			add("allowedBaseDigests", "base image %v is not allowed", base)
+		}
+	}
+	return violations
+}
+
+// isRootUser reports whether a USER setting ("user[:group]", by name or ID)
+// runs as root.
+func isRootUser(user string) bool {
+	user = strings.SplitN(user, ":", 2)[0]
+	return user == "" || user == "root" || user == "0"
+}
+
+// CheckImages checks every image against the policy and returns the
+// violations of the images that break it, by image name.
+func (p *Policy) CheckImages(images ImageMap) map[string][]Violation {
+	report := make(map[string][]Violation)
+	for name, image := range images {
+		if violations := p.Check(name, image); len(violations) > 0 {
+			report[name] = violations
+		}
+	}
+	return report
+}
+
+// ShowPolicyReport prints the violations of each image, and returns an error
+// if there are any.
+func ShowPolicyReport(images ImageMap, report map[string][]Violation) error {
+	if len(report) == 0 {
+		fmt.Printf("All %v images comply with the policy\n", len(images))
+		return nil
+	}
+	fmt.Println("Policy violations:")
+	for _, name := range images.SortedNames() {
+		for i, v := range report[name] {
+			if i == 0 {
+				fmt.Printf("  - %v\n", name)
+			}
+			fmt.Printf("      %v\n", v)
+		}
+	}
+	return fmt.Errorf("%v of %v images violate the policy", len(report), len(images))
+}
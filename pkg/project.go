@@ -0,0 +1,234 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ProjectFile is the default name of the project configuration file.
+const ProjectFile = "ply.yaml"
+
+// Steps of a project release pipeline, in the order they run.
+const (
+	StepClone         = "clone"
+	StepBuild         = "build"
+	StepLabel         = "label"
+	StepTagSuffix     = "tag-suffix"
+	StepSetPathPrefix = "set-path-prefix"
+	StepPush          = "push"
+)
+
+// ProjectSteps lists the steps of a pipeline in order.
+var ProjectSteps = []string{StepClone, StepBuild, StepLabel, StepTagSuffix, StepSetPathPrefix, StepPush}
+
+// Project describes the release pipeline of an addon: where its source is,
+// how its images are built, and how they are labeled, renamed and pushed.
+// Steps that are not configured are skipped. A project file looks like:
+//
+//	source:
+//	  repo: https://github.com/org/addon
+//	  rev: ${ADDON_VERSION}
+//	  dir: addon
+//	build:
+//	  command: make images
+//	images: ^addon-
+//	labels:
+//	  org.opencontainers.image.source: https://github.com/org/addon
+//	tagSuffix: gke.${BUILD_NUMBER}
+//	pathPrefix: gcr.io/${PROJECT_ID}/addons
+//	push:
+//	  enabled: true
+//	  policy: policy.yaml
+//	  registries: [us-docker.pkg.dev/${PROJECT_ID}/addons]
+//
+// References to environment variables ($VAR or ${VAR}) in values are
+// substituted when the file is loaded, and must be set; $$ is a literal $.
+type Project struct {
+	Source ProjectSource `yaml:"source"`
+	Build  ProjectBuild  `yaml:"build"`
+	// Images is the regex matching the images the build produces.
+	Images string `yaml:"images"`
+	// Platform restricts the pipeline to the images of a platform.
+	Platform string            `yaml:"platform"`
+	Labels   map[string]string `yaml:"labels"`
+	// TagSuffix is appended to the tags of the images after a "-" (e.g.
+	// "gke.1" turns "v1" into "v1-gke.1").
+	TagSuffix  string      `yaml:"tagSuffix"`
+	PathPrefix string      `yaml:"pathPrefix"`
+	Push       ProjectPush `yaml:"push"`
+}
+
+// ProjectSource is the git repository the addon is built from.
+type ProjectSource struct {
+	Repo string `yaml:"repo"`
+	Rev  string `yaml:"rev"`
+	// Dir is the directory the repository is cloned into, and the build
+	// runs in.
+	Dir string `yaml:"dir"`
+}
+
+// ProjectBuild is the shell command that builds the images.
+type ProjectBuild struct {
+	Command string `yaml:"command"`
+}
+
+// ProjectPush configures the push of the images.
+type ProjectPush struct {
+	Enabled bool `yaml:"enabled"`
+	// Images is the regex matching the images to push. It defaults to the
+	// images under PathPrefix with TagSuffix, or to Images without
+	// PathPrefix. It must be set with a PathPrefix but no TagSuffix, as
+	// nothing then tells the renamed images from others under PathPrefix.
+	Images string `yaml:"images"`
+	// Policy is a policy file (see Policy) the images must comply with.
+	Policy string `yaml:"policy"`
+	// Registries are other path prefixes the pushed images are copied to.
+	Registries []string `yaml:"registries"`
+}
+
+// LoadProject reads a project file and substitutes environment variables in
+// its values. Unknown keys and unset variables are rejected.
+func LoadProject(path string) (*Project, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	var p Project
+	if err := decoder.Decode(&p); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	if err := p.expandEnv(); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return &p, nil
+}
+
+// expandEnv substitutes environment variables in the values of the project.
+// Unset variables are an error rather than empty values, which would, for
+// instance, push images under a truncated path.
+func (p *Project) expandEnv() error {
+	unset := map[string]bool{}
+	expand := func(s string) string {
+		return os.Expand(s, func(name string) string {
+			if name == "$" {
+				return "$"
+			}
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				unset[name] = true
+			}
+			return value
+		})
+	}
+	for _, s := range []*string{
+		&p.Source.Repo, &p.Source.Rev, &p.Source.Dir, &p.Build.Command,
+		&p.Images, &p.Platform, &p.TagSuffix, &p.PathPrefix,
+		&p.Push.Images, &p.Push.Policy,
+	} {
+		*s = expand(*s)
+	}
+	for k, v := range p.Labels {
+		p.Labels[k] = expand(v)
+	}
+	for i, r := range p.Push.Registries {
+		p.Push.Registries[i] = expand(r)
+	}
+	if len(unset) > 0 {
+		var names []string
+		for name := range unset {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("environment variables %v are not set", strings.Join(names, ", "))
+	}
+	return nil
+}
+
+func (p *Project) validate() error {
+	needImages := len(p.Labels) > 0 || p.TagSuffix != "" || p.PathPrefix != "" || (p.Push.Enabled && p.Push.Images == "")
+	if needImages && p.Images == "" {
+		return fmt.Errorf("images (a regex) must be set to label, rename or push images")
+	}
+	for _, regex := range []string{p.Images, p.Push.Images} {
+		if _, err := regexp.Compile(regex); err != nil {
+			return err
+		}
+	}
+	if strings.HasPrefix(p.TagSuffix, "-") {
+		return fmt.Errorf("tagSuffix must not start with '-', which is added when it is appended")
+	}
+	if p.Push.Enabled && p.Push.Images == "" && p.PathPrefix != "" && p.TagSuffix == "" {
+		return fmt.Errorf("push.images (a regex) must be set to push images renamed with pathPrefix but no tagSuffix")
+	}
+	if len(p.Push.Registries) > 0 && p.PathPrefix == "" {
+		return fmt.Errorf("push.registries requires pathPrefix")
+	}
+	return nil
+}
+
+// Configured reports whether a step has something to do.
+func (p *Project) Configured(step string) bool {
+	switch step {
+	case StepClone:
+		return p.Source.Repo != ""
+	case StepBuild:
+		return p.Build.Command != ""
+	case StepLabel:
+		return len(p.Labels) > 0
+	case StepTagSuffix:
+		return p.TagSuffix != ""
+	case StepSetPathPrefix:
+		return p.PathPrefix != ""
+	case StepPush:
+		return p.Push.Enabled
+	}
+	return false
+}
+
+// SourceDir returns the directory the source is cloned into and built in.
+func (p *Project) SourceDir() string {
+	if p.Source.Dir != "" {
+		return p.Source.Dir
+	}
+	if p.Source.Repo != "" {
+		return RepoName(p.Source.Repo)
+	}
+	return "."
+}
+
+// PushImages returns the regex matching the images to push: the images
+// renamed by the set-path-prefix and tag-suffix steps, unless set.
+func (p *Project) PushImages() string {
+	if p.Push.Images != "" {
+		return p.Push.Images
+	}
+	if p.PathPrefix == "" {
+		return p.Images
+	}
+	return "^" + regexp.QuoteMeta(p.PathPrefix) + "/[^/:]+:.*" + regexp.QuoteMeta("-"+p.TagSuffix) + "$"
+}
@@ -0,0 +1,139 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestProjectPushImages(t *testing.T) {
+	tests := []struct {
+		name    string
+		project string
+		// push and skip are images the push regex must match and not match.
+		push    []string
+		skip    []string
+		wantErr bool
+	}{
+		{
+			name:    "images",
+			project: "images: ^addon-\npush: {enabled: true}\n",
+			push:    []string{"addon-controller:v1"},
+			skip:    []string{"other:v1"},
+		},
+		{
+			name:    "path prefix and tag suffix",
+			project: "images: ^addon-\ntagSuffix: gke.1\npathPrefix: gcr.io/p/addons\npush: {enabled: true}\n",
+			push:    []string{"gcr.io/p/addons/addon-controller:v1-gke.1"},
+			skip:    []string{"gcr.io/p/addons/addon-controller:v1", "gcr.io/p/addons/old:v1-gke.10", "gcr.io/p/addons/old:v1-xgke.1", "gcr.io/p/addons/sub/addon:v1-gke.1", "gcr.io/p/other:v1-gke.1"},
+		},
+		{
+			name:    "tag suffix with a dash",
+			project: "images: ^addon-\ntagSuffix: -gke.1\n",
+			wantErr: true,
+		},
+		{
+			name:    "path prefix without tag suffix",
+			project: "images: ^addon-\npathPrefix: gcr.io/p/addons\npush: {enabled: true}\n",
+			wantErr: true,
+		},
+		{
+			name:    "path prefix with push images",
+			project: "images: ^addon-\npathPrefix: gcr.io/p/addons\npush: {enabled: true, images: '^gcr.io/p/addons/addon-'}\n",
+			push:    []string{"gcr.io/p/addons/addon-controller:v1"},
+			skip:    []string{"gcr.io/p/addons/unrelated:v1"},
+		},
+		{
+			name:    "path prefix without push",
+			project: "images: ^addon-\npathPrefix: gcr.io/p/addons\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), ProjectFile)
+			if err := ioutil.WriteFile(path, []byte(tt.project), 0644); err != nil {
+				t.Fatal(err)
+			}
+			p, err := LoadProject(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadProject() error = %v, want error: %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			r := regexp.MustCompile(p.PushImages())
+			for _, image := range tt.push {
+				if !r.MatchString(image) {
+					t.Errorf("%v does not match %v", image, r)
+				}
+			}
+			for _, image := range tt.skip {
+				if r.MatchString(image) {
+					t.Errorf("%v matches %v", image, r)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadProjectEnv(t *testing.T) {
+	t.Setenv("PROJECT_ID", "p")
+	t.Setenv("BUILD_NUMBER", "7")
+	t.Setenv("EMPTY", "")
+	tests := []struct {
+		name    string
+		project string
+		want    Project
+		wantErr bool
+	}{
+		{
+			name:    "set",
+			project: "images: ^addon-\ntagSuffix: gke.${BUILD_NUMBER}$EMPTY\npathPrefix: gcr.io/$PROJECT_ID/addons\nbuild: {command: 'echo $$HOME'}\n",
+			want: Project{
+				Images:     "^addon-",
+				TagSuffix:  "gke.7",
+				PathPrefix: "gcr.io/p/addons",
+				Build:      ProjectBuild{Command: "echo $HOME"},
+			},
+		},
+		{
+			name:    "unset",
+			project: "images: ^addon-\npathPrefix: gcr.io/${PLY_TEST_UNSET}/addons\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), ProjectFile)
+			if err := ioutil.WriteFile(path, []byte(tt.project), 0644); err != nil {
+				t.Fatal(err)
+			}
+			p, err := LoadProject(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadProject() error = %v, want error: %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(*p, tt.want) {
+				t.Errorf("LoadProject() = %+v, want %+v", *p, tt.want)
+			}
+		})
+	}
+}
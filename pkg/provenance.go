@@ -0,0 +1,138 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// In-toto and SLSA identifiers of provenance statements [1].
+//
+// [1]: https://slsa.dev/spec/v1.0/provenance
+const (
+	InTotoStatementType     = "https://in-toto.io/Statement/v1"
+	SLSAProvenanceType      = "https://slsa.dev/provenance/v1"
+	MediaTypeInToto         = "application/vnd.in-toto+json"
+	AnnotationPredicateType = "in-toto.io/predicate-type"
+
+	plyBuilderID = "https://github.com/GoogleCloudPlatform/k8s-addon-builder"
+	plyBuildType = "https://github.com/GoogleCloudPlatform/k8s-addon-builder/ply@v1"
+)
+
+// ProvenanceInfo is what a provenance statement records about a build.
+type ProvenanceInfo struct {
+	// Image is the name of the built image, without tag or digest.
+	Image string
+	// Digest is the digest of the image manifest (or index).
+	Digest string
+	Source *GitSource
+	// BuilderVersion identifies the version of ply that ran the build.
+	BuilderVersion map[string]string
+	Parameters     map[string]string
+	// InvocationID identifies the build, e.g. its Cloud Build ID.
+	InvocationID string
+}
+
+type inTotoStatement struct {
+	Type          string          `json:"_type"`
+	Subject       []inTotoSubject `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     slsaProvenance  `json:"predicate"`
+}
+
+type inTotoSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+type slsaProvenance struct {
+	BuildDefinition slsaBuildDefinition `json:"buildDefinition"`
+	RunDetails      slsaRunDetails      `json:"runDetails"`
+}
+
+type slsaBuildDefinition struct {
+	BuildType            string                 `json:"buildType"`
+	ExternalParameters   map[string]interface{} `json:"externalParameters"`
+	ResolvedDependencies []slsaResourceDesc     `json:"resolvedDependencies,omitempty"`
+}
+
+type slsaResourceDesc struct {
+	URI    string            `json:"uri,omitempty"`
+	Digest map[string]string `json:"digest"`
+}
+
+type slsaRunDetails struct {
+	Builder  slsaBuilder   `json:"builder"`
+	Metadata *slsaMetadata `json:"metadata,omitempty"`
+}
+
+type slsaBuilder struct {
+	ID      string            `json:"id"`
+	Version map[string]string `json:"version,omitempty"`
+}
+
+type slsaMetadata struct {
+	InvocationID string `json:"invocationId,omitempty"`
+}
+
+// ProvenanceStatement renders an in-toto statement carrying a SLSA v1.0
+// provenance predicate about an image.
+func ProvenanceStatement(info ProvenanceInfo) ([]byte, error) {
+	parameters := map[string]interface{}{}
+	if len(info.Parameters) > 0 {
+		parameters["parameters"] = info.Parameters
+	}
+	definition := slsaBuildDefinition{
+		BuildType:          plyBuildType,
+		ExternalParameters: parameters,
+	}
+	if info.Source != nil {
+		source := slsaResourceDesc{
+			URI:    gitURI(info.Source.URL),
+			Digest: map[string]string{"gitCommit": info.Source.Commit},
+		}
+		parameters["source"] = source
+		definition.ResolvedDependencies = []slsaResourceDesc{source}
+	}
+
+	statement := inTotoStatement{
+		Type: InTotoStatementType,
+		Subject: []inTotoSubject{{
+			Name:   info.Image,
+			Digest: map[string]string{"sha256": strings.TrimPrefix(info.Digest, "sha256:")},
+		}},
+		PredicateType: SLSAProvenanceType,
+		Predicate: slsaProvenance{
+			BuildDefinition: definition,
+			RunDetails: slsaRunDetails{
+				Builder: slsaBuilder{ID: plyBuilderID, Version: info.BuilderVersion},
+			},
+		},
+	}
+	if info.InvocationID != "" {
+		statement.Predicate.RunDetails.Metadata = &slsaMetadata{InvocationID: info.InvocationID}
+	}
+	return json.MarshalIndent(statement, "", "  ")
+}
+
+// gitURI turns a git remote URL into the "git+" URI form used by SLSA, without
+// the credentials the URL may carry.
+func gitURI(remote string) string {
+	if remote == "" {
+		return remote
+	}
+	return "git+" + redactGitURL(strings.TrimPrefix(remote, "git+"))
+}
@@ -0,0 +1,164 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+)
+
+// MediaTypeOCIEmpty is the media type of the empty JSON object ("{}"), used as
+// the config of artifacts that need none.
+const MediaTypeOCIEmpty = "application/vnd.oci.empty.v1+json"
+
+// ReferrersTag returns the tag under which registries that do not implement
+// the referrers API keep the index of the referrers of a manifest [1].
+//
+// [1]: https://github.com/opencontainers/distribution-spec/blob/main/spec.md#referrers-tag-schema
+func ReferrersTag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1)
+}
+
+// PushReferrer pushes content as an OCI artifact of the given type attached to
+// a manifest of the same repository (the subject), and returns the descriptor
+// of the artifact manifest. If the registry does not implement the referrers
+// API, the artifact is added to the index tagged with ReferrersTag instead.
+func (c *RegistryClient) PushReferrer(named reference.Named, subject Descriptor, artifactType string, content []byte, annotations map[string]string) (Descriptor, error) {
+	config, err := c.PushBlob(named, MediaTypeOCIEmpty, []byte("{}"))
+	if err != nil {
+		return Descriptor{}, err
+	}
+	layer, err := c.PushBlob(named, artifactType, content)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	m := Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeOCIManifest,
+		ArtifactType:  artifactType,
+		Config:        &config,
+		Layers:        []Descriptor{layer},
+		Subject:       &Descriptor{MediaType: subject.MediaType, Digest: subject.Digest, Size: subject.Size},
+		Annotations:   annotations,
+	}
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	desc := Descriptor{
+		MediaType:    MediaTypeOCIManifest,
+		Digest:       Digest(raw),
+		Size:         int64(len(raw)),
+		Annotations:  annotations,
+		ArtifactType: artifactType,
+	}
+
+	header, err := c.putManifest(named, desc.Digest, MediaTypeOCIManifest, raw)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	if header.Get("OCI-Subject") == "" {
+		return desc, c.addToReferrersTag(named, subject.Digest, desc)
+	}
+	return desc, nil
+}
+
+// addToReferrersTag adds a referrer to the index tagged with ReferrersTag.
+func (c *RegistryClient) addToReferrersTag(named reference.Named, subject string, desc Descriptor) error {
+	tag := ReferrersTag(subject)
+	index, err := c.referrersTagIndex(named, tag)
+	if err != nil {
+		return err
+	}
+	for _, existing := range index.Manifests {
+		if existing.Digest == desc.Digest {
+			return nil
+		}
+	}
+	index.Manifests = append(index.Manifests, desc)
+	raw, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	_, err = c.PutManifest(named, tag, MediaTypeOCIIndex, raw)
+	return err
+}
+
+func (c *RegistryClient) referrersTagIndex(named reference.Named, tag string) (*Manifest, error) {
+	existing, err := c.HeadManifest(named, tag)
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return &Manifest{SchemaVersion: 2, MediaType: MediaTypeOCIIndex, Manifests: make([]Descriptor, 0)}, nil
+	}
+	raw, desc, err := c.GetManifest(named, tag)
+	if err != nil {
+		return nil, err
+	}
+	return ParseManifest(raw, desc.MediaType)
+}
+
+// Referrers lists the artifacts attached to a manifest, optionally only those
+// of one artifact type. Registries without the referrers API are handled by
+// reading the index tagged with ReferrersTag.
+func (c *RegistryClient) Referrers(named reference.Named, digest string, artifactType string) ([]Descriptor, error) {
+	u := repositoryURL(named, "referrers/"+digest)
+	if artifactType != "" {
+		u += "?" + url.Values{"artifactType": {artifactType}}.Encode()
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", MediaTypeOCIIndex)
+	resp, err := c.Do(req, pullScope(named))
+	if err != nil {
+		return nil, err
+	}
+
+	var index *Manifest
+	switch resp.StatusCode {
+	case http.StatusOK:
+		defer resp.Body.Close()
+		raw, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		if index, err = ParseManifest(raw, MediaTypeOCIIndex); err != nil {
+			return nil, err
+		}
+	case http.StatusNotFound:
+		drainAndClose(resp)
+		if index, err = c.referrersTagIndex(named, ReferrersTag(digest)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, registryError(resp)
+	}
+
+	referrers := make([]Descriptor, 0)
+	for _, desc := range index.Manifests {
+		if artifactType == "" || desc.ArtifactType == artifactType {
+			referrers = append(referrers, desc)
+		}
+	}
+	return referrers, nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// withoutReferrersAPI makes the registry behave like one that does not
+// implement the referrers API: the endpoint is not found and manifest pushes
+// do not answer with an OCI-Subject header.
+func withoutReferrersAPI(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/referrers/") {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(subjectlessWriter{w}, r)
+	})
+}
+
+type subjectlessWriter struct {
+	http.ResponseWriter
+}
+
+func (w subjectlessWriter) WriteHeader(status int) {
+	w.Header().Del("OCI-Subject")
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func TestReferrers(t *testing.T) {
+	const sbomType = "application/spdx+json"
+	const signatureType = "application/vnd.dev.ply.signature+json"
+	tests := []struct {
+		name         string
+		wrap         func(http.Handler) http.Handler
+		artifactType string
+		want         []string
+		// wantTag is whether the referrers tag is used.
+		wantTag bool
+	}{
+		{name: "api", want: []string{sbomType, signatureType}},
+		{name: "api filtered", artifactType: sbomType, want: []string{sbomType}},
+		{name: "api no match", artifactType: "application/unknown", want: []string{}},
+		{name: "tag fallback", wrap: withoutReferrersAPI, want: []string{sbomType, signatureType}, wantTag: true},
+		{name: "tag fallback filtered", wrap: withoutReferrersAPI, artifactType: signatureType, want: []string{signatureType}, wantTag: true},
+		{name: "tag fallback no match", wrap: withoutReferrersAPI, artifactType: "application/unknown", want: []string{}, wantTag: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRegistry(t, tt.wrap)
+			c := NewRegistryClient()
+			named := r.Named(t, "addon")
+			subject := pushTestImage(t, c, named, "v1", linuxAMD64, "layer")
+
+			pushed := make(map[string]string)
+			for _, artifactType := range []string{sbomType, signatureType} {
+				desc, err := c.PushReferrer(named, subject, artifactType, []byte(artifactType), map[string]string{"type": artifactType})
+				if err != nil {
+					t.Fatal(err)
+				}
+				pushed[artifactType] = desc.Digest
+			}
+			// Pushing the same artifact again must not list it twice.
+			if _, err := c.PushReferrer(named, subject, sbomType, []byte(sbomType), map[string]string{"type": sbomType}); err != nil {
+				t.Fatal(err)
+			}
+
+			tag, err := c.HeadManifest(named, ReferrersTag(subject.Digest))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (tag != nil) != tt.wantTag {
+				t.Errorf("referrers tag %v exists: %v, want %v", ReferrersTag(subject.Digest), tag != nil, tt.wantTag)
+			}
+
+			referrers, err := c.Referrers(named, subject.Digest, tt.artifactType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0)
+			for _, desc := range referrers {
+				if desc.Digest != pushed[desc.ArtifactType] {
+					t.Errorf("referrer %v of type %v, want %v", desc.Digest, desc.ArtifactType, pushed[desc.ArtifactType])
+				}
+				if desc.Annotations["type"] != desc.ArtifactType {
+					t.Errorf("referrer %v annotations = %v", desc.Digest, desc.Annotations)
+				}
+				got = append(got, desc.ArtifactType)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Referrers() types = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
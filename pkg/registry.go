@@ -0,0 +1,375 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/docker/distribution/reference"
+)
+
+// RegistryClient talks to registries implementing the Docker Registry HTTP API
+// V2 [1] directly, without going through the Docker daemon. Credentials are
+// looked up the same way the docker CLI does (see credentials.go), and bearer
+// tokens obtained through the token authentication flow [2] are cached per
+// registry and scope.
+//
+// [1]: https://docs.docker.com/registry/spec/api/
+// [2]: https://docs.docker.com/registry/spec/auth/token/
+type RegistryClient struct {
+	Client *http.Client
+
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+func NewRegistryClient() *RegistryClient {
+	return &RegistryClient{
+		Client: http.DefaultClient,
+		tokens: make(map[string]string),
+	}
+}
+
+// RegistryHost returns the host:port of the registry serving the repository.
+func RegistryHost(named reference.Named) string {
+	host := reference.Domain(named)
+	// Docker Hub's API is not served from the domain used in image names.
+	if host == "docker.io" {
+		return "registry-1.docker.io"
+	}
+	return host
+}
+
+// registryScheme returns "http" for registries running on the local host (as
+// the Docker daemon does by default) and "https" otherwise.
+func registryScheme(host string) string {
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	if isLocalHost(hostname) {
+		return "http"
+	}
+	return "https"
+}
+
+// isLocalHost reports whether a host name (without port) is the local host.
+func isLocalHost(hostname string) bool {
+	if hostname == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(hostname)
+	return ip != nil && ip.IsLoopback()
+}
+
+// repositoryURL returns the URL of an API endpoint under /v2/<name>/.
+func repositoryURL(named reference.Named, endpoint string) string {
+	host := RegistryHost(named)
+	return fmt.Sprintf("%v://%v/v2/%v/%v", registryScheme(host), host, reference.Path(named), endpoint)
+}
+
+// pullScope and pushScope return the token scopes [1] needed to read from or
+// write to a repository.
+//
+// [1]: https://docs.docker.com/registry/spec/auth/scope/
+func pullScope(named reference.Named) string {
+	return "repository:" + reference.Path(named) + ":pull"
+}
+
+func pushScope(named reference.Named) string {
+	return "repository:" + reference.Path(named) + ":pull,push"
+}
+
+// Do sends a request to a registry, authenticating if the registry asks for
+// it. The scope is the token scope requested if the registry uses token
+// authentication. Requests with a body are only retried after an
+// authentication challenge if the body can be rewound (see
+// http.Request.GetBody).
+func (c *RegistryClient) Do(req *http.Request, scope string) (*http.Response, error) {
+	key := req.URL.Host + " " + scope
+	if auth := c.cachedAuth(key); auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	drainAndClose(resp)
+	if challenge == "" {
+		return nil, fmt.Errorf("%v %v: unauthorized and no authentication challenge given", req.Method, req.URL)
+	}
+
+	auth, err := c.authenticate(req.URL.Host, challenge, scope)
+	if err != nil {
+		return nil, fmt.Errorf("%v %v: %v", req.Method, req.URL, err)
+	}
+	c.cacheAuth(key, auth)
+
+	retry := req.Clone(req.Context())
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil, fmt.Errorf("%v %v: cannot retry request after authentication", req.Method, req.URL)
+		}
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	retry.Header.Set("Authorization", auth)
+
+	resp, err = c.Client.Do(retry)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		drainAndClose(resp)
+		return nil, fmt.Errorf("%v %v: authentication failed (check the credentials for %v)", req.Method, req.URL, req.URL.Host)
+	}
+	return resp, nil
+}
+
+func (c *RegistryClient) cachedAuth(key string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tokens[key]
+}
+
+func (c *RegistryClient) cacheAuth(key, auth string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tokens[key] = auth
+}
+
+// authenticate answers a WWW-Authenticate challenge and returns the value to
+// use for the Authorization header.
+func (c *RegistryClient) authenticate(host, challenge, scope string) (string, error) {
+	scheme, params := parseChallenge(challenge)
+	creds, err := LookupCredentials(host)
+	if err != nil {
+		return "", err
+	}
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if creds.Username == "" && creds.Secret == "" {
+			return "", fmt.Errorf("registry requires credentials but none were found for %v", host)
+		}
+		req := &http.Request{Header: make(http.Header)}
+		req.SetBasicAuth(creds.Username, creds.Secret)
+		return req.Header.Get("Authorization"), nil
+	case "bearer":
+		token, err := c.fetchToken(params, scope, creds)
+		if err != nil {
+			return "", err
+		}
+		return "Bearer " + token, nil
+	default:
+		return "", fmt.Errorf("unsupported authentication scheme %q", scheme)
+	}
+}
+
+// fetchToken obtains a bearer token from the authorization service named in a
+// challenge.
+func (c *RegistryClient) fetchToken(params map[string]string, scope string, creds Credentials) (string, error) {
+	realm, ok := params["realm"]
+	if !ok {
+		return "", fmt.Errorf("bearer challenge has no realm")
+	}
+	u, err := url.Parse(realm)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	if service, ok := params["service"]; ok {
+		q.Set("service", service)
+	}
+	// Prefer the scope asked for by the caller, which may be broader than
+	// the one in the challenge (e.g. push access for cross-repository
+	// mounts).
+	if scope == "" {
+		scope = params["scope"]
+	}
+	for _, s := range strings.Split(scope, " ") {
+		if s != "" {
+			q.Add("scope", s)
+		}
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if creds.Username != "" || creds.Secret != "" {
+		req.SetBasicAuth(creds.Username, creds.Secret)
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request to %v failed: %v", u.Host, resp.Status)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("could not decode token response from %v: %v", u.Host, err)
+	}
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	if token.AccessToken != "" {
+		return token.AccessToken, nil
+	}
+	return "", fmt.Errorf("token response from %v contains no token", u.Host)
+}
+
+var challengeParamRegex = regexp.MustCompile(`([a-zA-Z_]+)="([^"]*)"`)
+
+// parseChallenge splits a WWW-Authenticate header such as
+//
+//	Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+//
+// into its scheme and parameters.
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := make(map[string]string)
+	parts := strings.SplitN(strings.TrimSpace(challenge), " ", 2)
+	if len(parts) == 2 {
+		for _, m := range challengeParamRegex.FindAllStringSubmatch(parts[1], -1) {
+			params[strings.ToLower(m[1])] = m[2]
+		}
+	}
+	return parts[0], params
+}
+
+// Tags lists all tags of a repository, following pagination links.
+func (c *RegistryClient) Tags(named reference.Named) ([]string, error) {
+	tags := make([]string, 0)
+	next := repositoryURL(named, "tags/list")
+	for next != "" {
+		req, err := http.NewRequest(http.MethodGet, next, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.Do(req, pullScope(named))
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, registryError(resp)
+		}
+
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, page.Tags...)
+
+		next, err = nextLink(req.URL, resp.Header.Get("Link"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// nextLink returns the absolute URL of the rel="next" entry in an RFC 5988
+// Link header, or "" if there is none.
+func nextLink(base *url.URL, header string) (string, error) {
+	for _, link := range strings.Split(header, ",") {
+		parts := strings.Split(link, ";")
+		target := strings.TrimSpace(parts[0])
+		if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+			continue
+		}
+		for _, param := range parts[1:] {
+			param = strings.Replace(strings.TrimSpace(param), " ", "", -1)
+			if param != `rel="next"` && param != "rel=next" {
+				continue
+			}
+			u, err := base.Parse(target[1 : len(target)-1])
+			if err != nil {
+				return "", err
+			}
+			return u.String(), nil
+		}
+	}
+	return "", nil
+}
+
+// registryError builds an error out of a failed registry response, including
+// the error codes [1] from the body if there are any. The body is closed.
+//
+// [1]: https://docs.docker.com/registry/spec/api/#errors
+func registryError(resp *http.Response) error {
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+
+	var errs struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	msg := resp.Status
+	if json.Unmarshal(body, &errs) == nil && len(errs.Errors) > 0 {
+		details := make([]string, 0)
+		for _, e := range errs.Errors {
+			details = append(details, e.Code+": "+e.Message)
+		}
+		msg += " (" + strings.Join(details, "; ") + ")"
+	}
+	return fmt.Errorf("%v %v: %v", resp.Request.Method, resp.Request.URL, msg)
+}
+
+func drainAndClose(resp *http.Response) {
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+}
+
+// FilterTags returns the tags matching the regex.
+func FilterTags(tags []string, r *regexp.Regexp) []string {
+	filtered := make([]string, 0)
+	for _, tag := range tags {
+		if r.MatchString(tag) {
+			filtered = append(filtered, tag)
+		}
+	}
+	return filtered
+}
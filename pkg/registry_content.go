@@ -0,0 +1,329 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+)
+
+// ParseImageReference splits an image name into the repository and the tag or
+// digest to use. Names without either refer to the "latest" tag.
+func ParseImageReference(image string) (reference.Named, string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return nil, "", err
+	}
+	ref := "latest"
+	if digested, ok := named.(reference.Digested); ok {
+		ref = digested.Digest().String()
+	} else if tagged, ok := named.(reference.Tagged); ok {
+		ref = tagged.Tag()
+	}
+	return reference.TrimNamed(named), ref, nil
+}
+
+// ImageString joins a repository and a tag or digest back into an image name.
+func ImageString(named reference.Named, ref string) string {
+	if IsDigest(ref) {
+		return reference.FamiliarString(named) + "@" + ref
+	}
+	return reference.FamiliarString(named) + ":" + ref
+}
+
+// GetManifest fetches a manifest by tag or digest. The returned descriptor
+// carries the media type, digest and size of the raw manifest bytes.
+func (c *RegistryClient) GetManifest(named reference.Named, ref string) ([]byte, Descriptor, error) {
+	req, err := http.NewRequest(http.MethodGet, repositoryURL(named, "manifests/"+ref), nil)
+	if err != nil {
+		return nil, Descriptor{}, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	resp, err := c.Do(req, pullScope(named))
+	if err != nil {
+		return nil, Descriptor{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, Descriptor{}, registryError(resp)
+	}
+	defer resp.Body.Close()
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, Descriptor{}, err
+	}
+	desc := Descriptor{
+		MediaType: contentType(resp),
+		Digest:    Digest(raw),
+		Size:      int64(len(raw)),
+	}
+	if IsDigest(ref) && desc.Digest != ref {
+		return nil, Descriptor{}, fmt.Errorf("manifest %v has unexpected digest %v", ImageString(named, ref), desc.Digest)
+	}
+	if m, err := ParseManifest(raw, desc.MediaType); err == nil {
+		desc.MediaType = m.MediaType
+	}
+	return raw, desc, nil
+}
+
+// HeadManifest resolves a tag or digest to a descriptor without downloading
+// the manifest. It returns nil (and no error) if the manifest does not exist.
+func (c *RegistryClient) HeadManifest(named reference.Named, ref string) (*Descriptor, error) {
+	req, err := http.NewRequest(http.MethodHead, repositoryURL(named, "manifests/"+ref), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	resp, err := c.Do(req, pullScope(named))
+	if err != nil {
+		return nil, err
+	}
+	drainAndClose(resp)
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HEAD %v: %v", req.URL, resp.Status)
+	}
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		// Not all registries send the digest on HEAD; fall back to
+		// fetching the manifest.
+		_, desc, err := c.GetManifest(named, ref)
+		if err != nil {
+			return nil, err
+		}
+		return &desc, nil
+	}
+	return &Descriptor{
+		MediaType: contentType(resp),
+		Digest:    digest,
+		Size:      resp.ContentLength,
+	}, nil
+}
+
+// PutManifest uploads a manifest under a tag or digest and returns its digest.
+func (c *RegistryClient) PutManifest(named reference.Named, ref string, mediaType string, raw []byte) (string, error) {
+	_, err := c.putManifest(named, ref, mediaType, raw)
+	if err != nil {
+		return "", err
+	}
+	return Digest(raw), nil
+}
+
+// putManifest uploads a manifest and returns the headers of the response.
+func (c *RegistryClient) putManifest(named reference.Named, ref string, mediaType string, raw []byte) (http.Header, error) {
+	req, err := http.NewRequest(http.MethodPut, repositoryURL(named, "manifests/"+ref), bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", mediaType)
+	resp, err := c.Do(req, pushScope(named))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return nil, registryError(resp)
+	}
+	drainAndClose(resp)
+	return resp.Header, nil
+}
+
+// BlobExists reports whether a repository contains a blob.
+func (c *RegistryClient) BlobExists(named reference.Named, digest string) (bool, error) {
+	req, err := http.NewRequest(http.MethodHead, repositoryURL(named, "blobs/"+digest), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.Do(req, pullScope(named))
+	if err != nil {
+		return false, err
+	}
+	drainAndClose(resp)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("HEAD %v: %v", req.URL, resp.Status)
+	}
+}
+
+// GetBlob opens a blob for reading. Callers must close the returned reader.
+func (c *RegistryClient) GetBlob(named reference.Named, digest string) (io.ReadCloser, int64, error) {
+	req, err := http.NewRequest(http.MethodGet, repositoryURL(named, "blobs/"+digest), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := c.Do(req, pullScope(named))
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, registryError(resp)
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// ReadBlob downloads a (small) blob, such as an image config, into memory.
+func (c *RegistryClient) ReadBlob(named reference.Named, digest string) ([]byte, error) {
+	r, _, err := c.GetBlob(named, digest)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if Digest(content) != digest {
+		return nil, fmt.Errorf("blob %v of %v has unexpected digest %v", digest, reference.FamiliarString(named), Digest(content))
+	}
+	return content, nil
+}
+
+// MountBlob asks the registry to make a blob of another repository on the
+// same registry available in named, without transferring any data [1]. It
+// reports whether the mount succeeded; if not, the upload session the
+// registry opened instead is returned so that the blob can be pushed with
+// UploadBlobTo.
+//
+// [1]: https://docs.docker.com/registry/spec/api/#cross-repository-blob-mount
+func (c *RegistryClient) MountBlob(named reference.Named, digest string, from reference.Named) (bool, string, error) {
+	q := url.Values{}
+	q.Set("mount", digest)
+	q.Set("from", reference.Path(from))
+	req, err := http.NewRequest(http.MethodPost, repositoryURL(named, "blobs/uploads/")+"?"+q.Encode(), nil)
+	if err != nil {
+		return false, "", err
+	}
+	resp, err := c.Do(req, pushScope(named)+" "+pullScope(from))
+	if err != nil {
+		return false, "", err
+	}
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		drainAndClose(resp)
+		return true, "", nil
+	case http.StatusAccepted:
+		drainAndClose(resp)
+		// The upload is finished with a PUT whose body cannot be resent
+		// after an authentication challenge, under the push scope of
+		// named; the token of the mount covers it.
+		if auth := resp.Request.Header.Get("Authorization"); auth != "" {
+			c.cacheAuth(req.URL.Host+" "+pushScope(named), auth)
+		}
+		location, err := uploadLocation(resp)
+		return false, location, err
+	default:
+		return false, "", registryError(resp)
+	}
+}
+
+// UploadBlob pushes a blob of known digest and size to a repository.
+func (c *RegistryClient) UploadBlob(named reference.Named, digest string, size int64, r io.Reader) error {
+	req, err := http.NewRequest(http.MethodPost, repositoryURL(named, "blobs/uploads/"), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.Do(req, pushScope(named))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		return registryError(resp)
+	}
+	drainAndClose(resp)
+	location, err := uploadLocation(resp)
+	if err != nil {
+		return err
+	}
+	return c.UploadBlobTo(named, location, digest, size, r)
+}
+
+// UploadBlobTo completes an upload session (as returned by MountBlob) with a
+// single monolithic PUT.
+func (c *RegistryClient) UploadBlobTo(named reference.Named, location string, digest string, size int64, r io.Reader) error {
+	u, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	q.Set("digest", digest)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodPut, u.String(), ioutil.NopCloser(r))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := c.Do(req, pushScope(named))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return registryError(resp)
+	}
+	drainAndClose(resp)
+	return nil
+}
+
+// PushBlob uploads an in-memory blob unless the repository already has it,
+// and returns its descriptor.
+func (c *RegistryClient) PushBlob(named reference.Named, mediaType string, content []byte) (Descriptor, error) {
+	desc := Descriptor{MediaType: mediaType, Digest: Digest(content), Size: int64(len(content))}
+	exists, err := c.BlobExists(named, desc.Digest)
+	if err != nil {
+		return desc, err
+	}
+	if exists {
+		return desc, nil
+	}
+	return desc, c.UploadBlob(named, desc.Digest, desc.Size, bytes.NewReader(content))
+}
+
+// uploadLocation returns the absolute URL of the upload session started by a
+// POST to /v2/<name>/blobs/uploads/.
+func uploadLocation(resp *http.Response) (string, error) {
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", fmt.Errorf("%v %v: no upload location returned", resp.Request.Method, resp.Request.URL)
+	}
+	u, err := resp.Request.URL.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// contentType returns the media type of a response, without parameters.
+func contentType(resp *http.Response) string {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
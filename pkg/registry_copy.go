@@ -0,0 +1,187 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/docker/distribution/reference"
+)
+
+// Copy copies an image from one repository to another, registry to registry.
+// Manifests are copied byte for byte, so digests are preserved; for manifest
+// lists and image indexes, every referenced manifest is copied (by digest)
+// first. Blobs already present in the destination are skipped, and blobs
+// living on the same registry are mounted rather than downloaded and uploaded
+// again.
+func (c *RegistryClient) Copy(src reference.Named, srcRef string, dst reference.Named, dstRef string) (Descriptor, error) {
+	raw, desc, err := c.GetManifest(src, srcRef)
+	if err != nil {
+		return desc, err
+	}
+	m, err := ParseManifest(raw, desc.MediaType)
+	if err != nil {
+		return desc, fmt.Errorf("could not parse manifest of %v: %v", ImageString(src, srcRef), err)
+	}
+
+	if IsIndex(m.MediaType) {
+		for _, child := range m.Manifests {
+			if _, err := c.Copy(src, child.Digest, dst, child.Digest); err != nil {
+				return desc, err
+			}
+		}
+	} else {
+		blobs := make([]Descriptor, 0)
+		if m.Config != nil {
+			blobs = append(blobs, *m.Config)
+		}
+		blobs = append(blobs, m.Layers...)
+		for _, blob := range blobs {
+			if err := c.copyBlob(src, dst, blob); err != nil {
+				return desc, err
+			}
+		}
+	}
+
+	if _, err := c.PutManifest(dst, dstRef, desc.MediaType, raw); err != nil {
+		return desc, err
+	}
+	fmt.Printf("copied %v\n    to %v (%v)\n", ImageString(src, srcRef), ImageString(dst, dstRef), desc.Digest)
+	return desc, nil
+}
+
+func (c *RegistryClient) copyBlob(src, dst reference.Named, blob Descriptor) error {
+	if len(blob.URLs) > 0 && IsNondistributable(blob.MediaType) {
+		return nil
+	}
+
+	exists, err := c.BlobExists(dst, blob.Digest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	location := ""
+	if RegistryHost(src) == RegistryHost(dst) {
+		mounted, l, err := c.MountBlob(dst, blob.Digest, src)
+		if err != nil {
+			return err
+		}
+		if mounted {
+			fmt.Printf("  mounted %v\n", blob.Digest)
+			return nil
+		}
+		location = l
+	}
+
+	r, size, err := c.GetBlob(src, blob.Digest)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if size < 0 {
+		size = blob.Size
+	}
+	if location != "" {
+		err = c.UploadBlobTo(dst, location, blob.Digest, size, r)
+	} else {
+		err = c.UploadBlob(dst, blob.Digest, size, r)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("  uploaded %v (%v bytes)\n", blob.Digest, size)
+	return nil
+}
+
+// CopyTags copies every tag of src matching the regex to dst, keeping tag
+// names. It returns the tags that were copied.
+func (c *RegistryClient) CopyTags(src, dst reference.Named, r *regexp.Regexp) ([]string, error) {
+	tags, err := c.Tags(src)
+	if err != nil {
+		return nil, err
+	}
+	copied := make([]string, 0)
+	for _, tag := range FilterTags(tags, r) {
+		if _, err := c.Copy(src, tag, dst, tag); err != nil {
+			return copied, err
+		}
+		copied = append(copied, tag)
+	}
+	return copied, nil
+}
+
+// Tag adds a tag to an existing manifest by uploading the manifest again under
+// the new tag. No blobs are transferred.
+func (c *RegistryClient) Tag(named reference.Named, ref string, newTag string) (Descriptor, error) {
+	if !isValidTag(newTag) {
+		return Descriptor{}, fmt.Errorf("new tag %v is invalid", newTag)
+	}
+	raw, desc, err := c.GetManifest(named, ref)
+	if err != nil {
+		return desc, err
+	}
+	if _, err := c.PutManifest(named, newTag, desc.MediaType, raw); err != nil {
+		return desc, err
+	}
+	fmt.Printf("tagged from:%v\n         to:%v\n", ImageString(named, ref), ImageString(named, newTag))
+	return desc, nil
+}
+
+// AppendTagSuffix tags every tag of a repository matching the regex with
+// "-<tagSuffix>" appended, following the same rules as "docker-regex
+// tag-suffix append": "latest" and tags that already have the suffix, or
+// already have a suffixed counterpart, are skipped.
+func (c *RegistryClient) AppendTagSuffix(named reference.Named, r *regexp.Regexp, tagSuffix string) error {
+	tags, err := c.Tags(named)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for _, tag := range tags {
+		existing[tag] = true
+	}
+
+	tagOps := make([]TagOp, 0)
+	for _, tag := range FilterTags(tags, r) {
+		newTag, skipReason, err := AppendTagSuffix(tag, tagSuffix)
+		if err != nil {
+			return err
+		}
+		if skipReason == "" && existing[newTag] {
+			skipReason = fmt.Sprintf("already suffixed to '-%v'", tagSuffix)
+		}
+		if skipReason != "" {
+			fmt.Printf("skipping %v (%v)\n", ImageString(named, tag), skipReason)
+			continue
+		}
+		tagOps = append(tagOps, TagOp{From: tag, To: newTag})
+	}
+
+	if len(tagOps) == 0 {
+		fmt.Printf("Nothing to do.\n")
+		return nil
+	}
+
+	for _, op := range tagOps {
+		if _, err := c.Tag(named, op.From, op.To); err != nil {
+			return err
+		}
+	}
+	return nil
+}
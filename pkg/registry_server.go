@@ -0,0 +1,716 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// RegistryServer is a small, in-process implementation of the OCI
+// distribution API [1] that stores everything in a directory. It exists so
+// that code talking to registries can be exercised hermetically (in tests, or
+// with "ply registry serve" while debugging); it performs no authentication
+// and is not meant to serve real traffic.
+//
+// The directory layout is:
+//
+//	blobs/sha256/<hex>                              content of every blob and manifest
+//	repositories/<name>/_layers/sha256/<hex>        blob links of a repository
+//	repositories/<name>/_manifests/revisions/<hex>  media type of a manifest
+//	repositories/<name>/_manifests/tags/<tag>       digest a tag points at
+//	repositories/<name>/_manifests/referrers/<subject hex>/<hex>
+//	                                                manifests whose subject is <subject hex>
+//	repositories/<name>/_uploads/<uuid>             in-progress blob uploads
+//
+// [1]: https://github.com/opencontainers/distribution-spec/blob/main/spec.md
+type RegistryServer struct {
+	Dir string
+
+	mu     sync.Mutex
+	server *http.Server
+}
+
+// NewRegistryServer returns a server storing its data in dir, which is
+// created if it does not exist.
+func NewRegistryServer(dir string) (*RegistryServer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &RegistryServer{Dir: dir}, nil
+}
+
+// Start serves the registry on a random port of the loopback interface and
+// returns its address (host:port), which can be used as the registry part of
+// image names. The registry client talks plain HTTP to loopback addresses.
+func (s *RegistryServer) Start() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	s.server = &http.Server{Handler: s}
+	go s.server.Serve(l)
+	return l.Addr().String(), nil
+}
+
+// Close stops a server started with Start.
+func (s *RegistryServer) Close() error {
+	if s.server == nil {
+		return nil
+	}
+	return s.server.Close()
+}
+
+var registryRouteRegex = regexp.MustCompile(`^/v2/(.+)/(manifests|blobs|tags|referrers)/(.*)$`)
+
+// nameRegex matches valid repository names.
+var nameRegex = regexp.MustCompile(`^[a-z0-9]+(?:(?:\.|_|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:\.|_|__|-+)[a-z0-9]+)*)*$`)
+
+var digestRegex = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// uploadIDRegex matches the upload IDs made by newUploadID.
+var uploadIDRegex = regexp.MustCompile(`^[a-f0-9]{32}$`)
+
+func (s *RegistryServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+
+	if r.URL.Path == "/v2/" || r.URL.Path == "/v2" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+		return
+	}
+	if r.URL.Path == "/v2/_catalog" && r.Method == http.MethodGet {
+		s.catalog(w, r)
+		return
+	}
+
+	m := registryRouteRegex.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		writeRegistryError(w, http.StatusNotFound, "NOT_FOUND", "unknown endpoint "+r.URL.Path)
+		return
+	}
+	name, kind, rest := m[1], m[2], m[3]
+	if !nameRegex.MatchString(name) {
+		writeRegistryError(w, http.StatusBadRequest, "NAME_INVALID", "invalid repository name "+name)
+		return
+	}
+	// Paths in the storage directory are made of the name and reference, so
+	// neither may contain anything else (e.g. "../").
+	if !validRouteReference(kind, rest) {
+		writeRegistryError(w, http.StatusBadRequest, "NAME_INVALID", "invalid reference "+rest)
+		return
+	}
+
+	// Keep things simple: one request at a time.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case kind == "manifests":
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			s.getManifest(w, r, name, rest)
+		case http.MethodPut:
+			s.putManifest(w, r, name, rest)
+		case http.MethodDelete:
+			s.deleteManifest(w, name, rest)
+		default:
+			writeRegistryError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", r.Method)
+		}
+	case kind == "blobs" && (rest == "uploads/" || rest == "uploads"):
+		if r.Method != http.MethodPost {
+			writeRegistryError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", r.Method)
+			return
+		}
+		s.startUpload(w, r, name)
+	case kind == "blobs" && strings.HasPrefix(rest, "uploads/"):
+		s.upload(w, r, name, strings.TrimPrefix(rest, "uploads/"))
+	case kind == "blobs":
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			s.getBlob(w, r, name, rest)
+		case http.MethodDelete:
+			s.deleteBlob(w, name, rest)
+		default:
+			writeRegistryError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", r.Method)
+		}
+	case kind == "tags" && rest == "list" && r.Method == http.MethodGet:
+		s.listTags(w, r, name)
+	case kind == "referrers" && r.Method == http.MethodGet:
+		s.listReferrers(w, r, name, rest)
+	default:
+		writeRegistryError(w, http.StatusNotFound, "NOT_FOUND", "unknown endpoint "+r.URL.Path)
+	}
+}
+
+// validRouteReference returns whether the part of a route after the kind is a
+// tag or digest for manifests, a digest for blobs and referrers, or an upload
+// ID for uploads.
+func validRouteReference(kind, rest string) bool {
+	switch {
+	case kind == "manifests":
+		return digestRegex.MatchString(rest) || isValidTag(rest)
+	case kind == "blobs" && (rest == "uploads/" || rest == "uploads"):
+		return true
+	case kind == "blobs" && strings.HasPrefix(rest, "uploads/"):
+		return uploadIDRegex.MatchString(strings.TrimPrefix(rest, "uploads/"))
+	case kind == "blobs" || kind == "referrers":
+		return digestRegex.MatchString(rest)
+	}
+	return rest == "list"
+}
+
+func writeRegistryError(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+}
+
+func (s *RegistryServer) path(parts ...string) string {
+	return filepath.Join(append([]string{s.Dir}, parts...)...)
+}
+
+func (s *RegistryServer) repoPath(name string, parts ...string) string {
+	return s.path(append([]string{"repositories", filepath.FromSlash(name)}, parts...)...)
+}
+
+func (s *RegistryServer) blobPath(digest string) string {
+	return s.path("blobs", "sha256", strings.TrimPrefix(digest, "sha256:"))
+}
+
+func (s *RegistryServer) layerLinkPath(name, digest string) string {
+	return s.repoPath(name, "_layers", "sha256", strings.TrimPrefix(digest, "sha256:"))
+}
+
+func (s *RegistryServer) revisionPath(name, digest string) string {
+	return s.repoPath(name, "_manifests", "revisions", strings.TrimPrefix(digest, "sha256:"))
+}
+
+func (s *RegistryServer) tagPath(name, tag string) string {
+	return s.repoPath(name, "_manifests", "tags", tag)
+}
+
+func (s *RegistryServer) referrerPath(name, subject, digest string) string {
+	return s.repoPath(name, "_manifests", "referrers", strings.TrimPrefix(subject, "sha256:"), strings.TrimPrefix(digest, "sha256:"))
+}
+
+func (s *RegistryServer) hasBlob(name, digest string) bool {
+	if !digestRegex.MatchString(digest) {
+		return false
+	}
+	_, err := os.Stat(s.layerLinkPath(name, digest))
+	return err == nil
+}
+
+func (s *RegistryServer) hasManifest(name, digest string) bool {
+	if !digestRegex.MatchString(digest) {
+		return false
+	}
+	_, err := os.Stat(s.revisionPath(name, digest))
+	return err == nil
+}
+
+// writeFile writes a file, creating parent directories as needed.
+func writeFile(path string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, content, 0644)
+}
+
+// resolveManifest turns a tag or digest into a digest, or "" if unknown.
+func (s *RegistryServer) resolveManifest(name, ref string) string {
+	digest := ref
+	if !IsDigest(ref) {
+		if !isValidTag(ref) {
+			return ""
+		}
+		content, err := ioutil.ReadFile(s.tagPath(name, ref))
+		if err != nil {
+			return ""
+		}
+		digest = string(content)
+	}
+	if !digestRegex.MatchString(digest) || !s.hasManifest(name, digest) {
+		return ""
+	}
+	return digest
+}
+
+func (s *RegistryServer) getManifest(w http.ResponseWriter, r *http.Request, name, ref string) {
+	digest := s.resolveManifest(name, ref)
+	if digest == "" {
+		writeRegistryError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown: "+name+":"+ref)
+		return
+	}
+	mediaType, err := ioutil.ReadFile(s.revisionPath(name, digest))
+	if err != nil {
+		writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	content, err := ioutil.ReadFile(s.blobPath(digest))
+	if err != nil {
+		writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", string(mediaType))
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		w.Write(content)
+	}
+}
+
+func (s *RegistryServer) putManifest(w http.ResponseWriter, r *http.Request, name, ref string) {
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeRegistryError(w, http.StatusBadRequest, "MANIFEST_INVALID", err.Error())
+		return
+	}
+	digest := Digest(content)
+	if IsDigest(ref) && ref != digest {
+		writeRegistryError(w, http.StatusBadRequest, "DIGEST_INVALID", "manifest digest is "+digest)
+		return
+	}
+	if !IsDigest(ref) && !isValidTag(ref) {
+		writeRegistryError(w, http.StatusBadRequest, "TAG_INVALID", "invalid tag "+ref)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	m, err := ParseManifest(content, mediaType)
+	if err != nil {
+		writeRegistryError(w, http.StatusBadRequest, "MANIFEST_INVALID", err.Error())
+		return
+	}
+	if mediaType == "" {
+		mediaType = m.MediaType
+	}
+
+	// Like real registries, refuse manifests pointing at content that was
+	// never pushed; this catches clients pushing things in the wrong order.
+	for _, child := range m.Manifests {
+		if !s.hasManifest(name, child.Digest) {
+			writeRegistryError(w, http.StatusBadRequest, "MANIFEST_BLOB_UNKNOWN", "unknown manifest "+child.Digest)
+			return
+		}
+	}
+	blobs := m.Layers
+	if m.Config != nil {
+		blobs = append([]Descriptor{*m.Config}, blobs...)
+	}
+	for _, blob := range blobs {
+		if len(blob.URLs) == 0 && !s.hasBlob(name, blob.Digest) {
+			writeRegistryError(w, http.StatusBadRequest, "MANIFEST_BLOB_UNKNOWN", "unknown blob "+blob.Digest)
+			return
+		}
+	}
+
+	if err := writeFile(s.blobPath(digest), content); err != nil {
+		writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	if err := writeFile(s.revisionPath(name, digest), []byte(mediaType)); err != nil {
+		writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	if !IsDigest(ref) {
+		if err := writeFile(s.tagPath(name, ref), []byte(digest)); err != nil {
+			writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+			return
+		}
+	}
+	// The subject does not have to exist (yet).
+	if m.Subject != nil && digestRegex.MatchString(m.Subject.Digest) {
+		if err := writeFile(s.referrerPath(name, m.Subject.Digest, digest), nil); err != nil {
+			writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+			return
+		}
+		w.Header().Set("OCI-Subject", m.Subject.Digest)
+	}
+
+	w.Header().Set("Location", "/v2/"+name+"/manifests/"+digest)
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *RegistryServer) deleteManifest(w http.ResponseWriter, name, ref string) {
+	var err error
+	if IsDigest(ref) {
+		if !s.hasManifest(name, ref) {
+			writeRegistryError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown: "+ref)
+			return
+		}
+		err = os.Remove(s.revisionPath(name, ref))
+	} else {
+		err = os.Remove(s.tagPath(name, ref))
+		if os.IsNotExist(err) {
+			writeRegistryError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown: "+ref)
+			return
+		}
+	}
+	if err != nil {
+		writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *RegistryServer) getBlob(w http.ResponseWriter, r *http.Request, name, digest string) {
+	if !digestRegex.MatchString(digest) || !s.hasBlob(name, digest) {
+		writeRegistryError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown: "+digest)
+		return
+	}
+	f, err := os.Open(s.blobPath(digest))
+	if err != nil {
+		writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		io.Copy(w, f)
+	}
+}
+
+func (s *RegistryServer) deleteBlob(w http.ResponseWriter, name, digest string) {
+	if !digestRegex.MatchString(digest) || !s.hasBlob(name, digest) {
+		writeRegistryError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown: "+digest)
+		return
+	}
+	if err := os.Remove(s.layerLinkPath(name, digest)); err != nil {
+		writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *RegistryServer) startUpload(w http.ResponseWriter, r *http.Request, name string) {
+	q := r.URL.Query()
+
+	// Cross-repository mount. Both parameters end up in file paths.
+	if mount, from := q.Get("mount"), q.Get("from"); mount != "" && from != "" {
+		if digestRegex.MatchString(mount) && nameRegex.MatchString(from) && s.hasBlob(from, mount) {
+			if err := writeFile(s.layerLinkPath(name, mount), nil); err != nil {
+				writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+				return
+			}
+			w.Header().Set("Location", "/v2/"+name+"/blobs/"+mount)
+			w.Header().Set("Docker-Content-Digest", mount)
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		// Fall through to a regular upload, as the spec requires.
+	}
+
+	id, err := newUploadID()
+	if err != nil {
+		writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	if err := writeFile(s.repoPath(name, "_uploads", id), nil); err != nil {
+		writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+
+	// Monolithic upload in a single POST.
+	if digest := q.Get("digest"); digest != "" {
+		s.finishUpload(w, r, name, id, digest)
+		return
+	}
+
+	w.Header().Set("Location", "/v2/"+name+"/blobs/uploads/"+id)
+	w.Header().Set("Docker-Upload-UUID", id)
+	w.Header().Set("Range", "0-0")
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *RegistryServer) upload(w http.ResponseWriter, r *http.Request, name, id string) {
+	if !uploadIDRegex.MatchString(id) {
+		writeRegistryError(w, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN", "upload unknown: "+id)
+		return
+	}
+	path := s.repoPath(name, "_uploads", id)
+	if _, err := os.Stat(path); err != nil {
+		writeRegistryError(w, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN", "upload unknown: "+id)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.uploadStatus(w, name, id, http.StatusNoContent)
+	case http.MethodPatch:
+		if err := appendUpload(path, r.Body); err != nil {
+			writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+			return
+		}
+		s.uploadStatus(w, name, id, http.StatusAccepted)
+	case http.MethodPut:
+		s.finishUpload(w, r, name, id, r.URL.Query().Get("digest"))
+	case http.MethodDelete:
+		os.Remove(path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeRegistryError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", r.Method)
+	}
+}
+
+func (s *RegistryServer) uploadStatus(w http.ResponseWriter, name, id string, status int) {
+	info, err := os.Stat(s.repoPath(name, "_uploads", id))
+	if err != nil {
+		writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	end := info.Size() - 1
+	if end < 0 {
+		end = 0
+	}
+	w.Header().Set("Location", "/v2/"+name+"/blobs/uploads/"+id)
+	w.Header().Set("Docker-Upload-UUID", id)
+	w.Header().Set("Range", fmt.Sprintf("0-%v", end))
+	w.WriteHeader(status)
+}
+
+// finishUpload appends the request body to an upload, checks its digest and
+// moves it into the blob store.
+func (s *RegistryServer) finishUpload(w http.ResponseWriter, r *http.Request, name, id, digest string) {
+	path := s.repoPath(name, "_uploads", id)
+	defer os.Remove(path)
+
+	if !digestRegex.MatchString(digest) {
+		writeRegistryError(w, http.StatusBadRequest, "DIGEST_INVALID", "invalid digest "+digest)
+		return
+	}
+	if err := appendUpload(path, r.Body); err != nil {
+		writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	actual, err := fileDigest(path)
+	if err != nil {
+		writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	if actual != digest {
+		writeRegistryError(w, http.StatusBadRequest, "DIGEST_INVALID", "uploaded content has digest "+actual)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.blobPath(digest)), 0755); err != nil {
+		writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	if err := os.Rename(path, s.blobPath(digest)); err != nil {
+		writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	if err := writeFile(s.layerLinkPath(name, digest), nil); err != nil {
+		writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+
+	w.Header().Set("Location", "/v2/"+name+"/blobs/"+digest)
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func appendUpload(path string, body io.Reader) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func newUploadID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (s *RegistryServer) listTags(w http.ResponseWriter, r *http.Request, name string) {
+	infos, err := ioutil.ReadDir(s.repoPath(name, "_manifests", "tags"))
+	if os.IsNotExist(err) {
+		writeRegistryError(w, http.StatusNotFound, "NAME_UNKNOWN", "repository unknown: "+name)
+		return
+	} else if err != nil {
+		writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	tags := make([]string, 0)
+	for _, info := range infos {
+		tags = append(tags, info.Name())
+	}
+
+	page, next := paginate(tags, r.URL.Query())
+	if next != "" {
+		w.Header().Set("Link", fmt.Sprintf(`</v2/%v/tags/list?%v>; rel="next"`, name, next))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"name": name, "tags": page})
+}
+
+// listReferrers returns an image index of the manifests whose subject is the
+// given digest, optionally filtered by artifact type.
+func (s *RegistryServer) listReferrers(w http.ResponseWriter, r *http.Request, name, subject string) {
+	if !digestRegex.MatchString(subject) {
+		writeRegistryError(w, http.StatusBadRequest, "DIGEST_INVALID", "invalid digest "+subject)
+		return
+	}
+	infos, err := ioutil.ReadDir(filepath.Dir(s.referrerPath(name, subject, "x")))
+	if err != nil && !os.IsNotExist(err) {
+		writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+
+	artifactType := r.URL.Query().Get("artifactType")
+	index := Manifest{SchemaVersion: 2, MediaType: MediaTypeOCIIndex, Manifests: make([]Descriptor, 0)}
+	for _, info := range infos {
+		digest := "sha256:" + info.Name()
+		// Deleted referrers are not listed anymore.
+		if !s.hasManifest(name, digest) {
+			continue
+		}
+		mediaType, err := ioutil.ReadFile(s.revisionPath(name, digest))
+		if err != nil {
+			writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+			return
+		}
+		content, err := ioutil.ReadFile(s.blobPath(digest))
+		if err != nil {
+			writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+			return
+		}
+		m, err := ParseManifest(content, string(mediaType))
+		if err != nil {
+			writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+			return
+		}
+		desc := Descriptor{
+			MediaType:    string(mediaType),
+			Digest:       digest,
+			Size:         int64(len(content)),
+			Annotations:  m.Annotations,
+			ArtifactType: m.ArtifactType,
+		}
+		if desc.ArtifactType == "" && m.Config != nil {
+			desc.ArtifactType = m.Config.MediaType
+		}
+		if artifactType != "" && desc.ArtifactType != artifactType {
+			continue
+		}
+		index.Manifests = append(index.Manifests, desc)
+	}
+
+	if artifactType != "" {
+		w.Header().Set("OCI-Filters-Applied", "artifactType")
+	}
+	w.Header().Set("Content-Type", MediaTypeOCIIndex)
+	json.NewEncoder(w).Encode(index)
+}
+
+func (s *RegistryServer) catalog(w http.ResponseWriter, r *http.Request) {
+	root := s.path("repositories")
+	repos := make([]string, 0)
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		if info.Name() == "_manifests" {
+			rel, _ := filepath.Rel(root, filepath.Dir(path))
+			repos = append(repos, filepath.ToSlash(rel))
+			return filepath.SkipDir
+		}
+		if strings.HasPrefix(info.Name(), "_") {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+
+	page, next := paginate(repos, r.URL.Query())
+	if next != "" {
+		w.Header().Set("Link", fmt.Sprintf(`</v2/_catalog?%v>; rel="next"`, next))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"repositories": page})
+}
+
+// paginate applies the "n" and "last" query parameters to a list, returning
+// the page and the query string of the next page ("" if this is the last).
+func paginate(items []string, q url.Values) ([]string, string) {
+	sort.Strings(items)
+	if last := q.Get("last"); last != "" {
+		i := sort.SearchStrings(items, last)
+		if i < len(items) && items[i] == last {
+			i++
+		}
+		items = items[i:]
+	}
+	n, err := strconv.Atoi(q.Get("n"))
+	if err != nil || n <= 0 || n >= len(items) {
+		return items, ""
+	}
+	next := url.Values{}
+	next.Set("n", strconv.Itoa(n))
+	next.Set("last", items[n-1])
+	return items[:n], next.Encode()
+}
@@ -0,0 +1,533 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/distribution/reference"
+)
+
+// testRegistry is a RegistryServer served over HTTP on the loopback
+// interface, recording the requests it receives. Its handler can be wrapped
+// to alter the API, e.g. to paginate or to hide endpoints.
+type testRegistry struct {
+	*RegistryServer
+	Host string
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func newTestRegistry(t *testing.T, wrap func(http.Handler) http.Handler) *testRegistry {
+	t.Helper()
+	server, err := NewRegistryServer(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &testRegistry{RegistryServer: server}
+	var handler http.Handler = server
+	if wrap != nil {
+		handler = wrap(handler)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		r.requests = append(r.requests, req.Method+" "+req.URL.RequestURI())
+		r.mu.Unlock()
+		handler.ServeHTTP(w, req)
+	}))
+	t.Cleanup(ts.Close)
+	r.Host = strings.TrimPrefix(ts.URL, "http://")
+	return r
+}
+
+// Named returns the name of a repository of the registry.
+func (r *testRegistry) Named(t *testing.T, repo string) reference.Named {
+	t.Helper()
+	named, err := reference.ParseNormalizedNamed(r.Host + "/" + repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return named
+}
+
+// Requests returns the requests received since the last ResetRequests that
+// match the regex, as "METHOD /path?query".
+func (r *testRegistry) Requests(regex string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	matching := make([]string, 0)
+	for _, req := range r.requests {
+		if regexp.MustCompile(regex).MatchString(req) {
+			matching = append(matching, req)
+		}
+	}
+	return matching
+}
+
+func (r *testRegistry) ResetRequests() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = nil
+}
+
+// pushTestImage pushes an image made of one layer per string, tagged (or
+// pushed by digest if the tag is ""), and returns its manifest descriptor.
+func pushTestImage(t *testing.T, c *RegistryClient, named reference.Named, tag string, platform Platform, layers ...string) Descriptor {
+	t.Helper()
+	rawConfig, err := json.Marshal(map[string]interface{}{
+		"architecture": platform.Architecture,
+		"os":           platform.OS,
+		"rootfs":       map[string]interface{}{"type": "layers"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := c.PushBlob(named, MediaTypeOCIConfig, rawConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := Manifest{SchemaVersion: 2, MediaType: MediaTypeOCIManifest, Config: &config}
+	for _, layer := range layers {
+		desc, err := c.PushBlob(named, MediaTypeOCILayer, []byte(layer))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Layers = append(m.Layers, desc)
+	}
+	return pushTestManifest(t, c, named, tag, m)
+}
+
+func pushTestManifest(t *testing.T, c *RegistryClient, named reference.Named, tag string, m Manifest) Descriptor {
+	t.Helper()
+	raw, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := tag
+	if ref == "" {
+		ref = Digest(raw)
+	}
+	digest, err := c.PutManifest(named, ref, m.MediaType, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Descriptor{MediaType: m.MediaType, Digest: digest, Size: int64(len(raw))}
+}
+
+var linuxAMD64 = Platform{OS: "linux", Architecture: "amd64"}
+
+// paginateTags makes the registry return pages of n tags unless the client
+// asks for a page size.
+func paginateTags(n int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			q := r.URL.Query()
+			if strings.HasSuffix(r.URL.Path, "/tags/list") && q.Get("n") == "" {
+				q.Set("n", fmt.Sprint(n))
+				r.URL.RawQuery = q.Encode()
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestRegistryTags(t *testing.T) {
+	tags := []string{"v2", "latest", "v1", "v10", "v3"}
+	want := []string{"latest", "v1", "v10", "v2", "v3"}
+	tests := []struct {
+		name      string
+		pageSize  int
+		wantPages int
+	}{
+		{name: "no pagination", pageSize: 0, wantPages: 1},
+		{name: "pages of one", pageSize: 1, wantPages: 5},
+		{name: "pages of two", pageSize: 2, wantPages: 3},
+		{name: "page size equal to tag count", pageSize: 5, wantPages: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var wrap func(http.Handler) http.Handler
+			if tt.pageSize > 0 {
+				wrap = paginateTags(tt.pageSize)
+			}
+			r := newTestRegistry(t, wrap)
+			c := NewRegistryClient()
+			named := r.Named(t, "addon")
+			for _, tag := range tags {
+				pushTestImage(t, c, named, tag, linuxAMD64, "layer")
+			}
+			r.ResetRequests()
+
+			got, err := c.Tags(named)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Tags() = %v, want %v", got, want)
+			}
+			if pages := r.Requests("/tags/list"); len(pages) != tt.wantPages {
+				t.Errorf("Tags() fetched %v pages, want %v: %v", len(pages), tt.wantPages, pages)
+			}
+		})
+	}
+
+	t.Run("unknown repository", func(t *testing.T) {
+		r := newTestRegistry(t, nil)
+		if _, err := NewRegistryClient().Tags(r.Named(t, "nothing")); err == nil {
+			t.Error("Tags() of an unknown repository succeeded")
+		}
+	})
+}
+
+func TestRegistryCopy(t *testing.T) {
+	tests := []struct {
+		name string
+		// sameRegistry copies between repositories of one registry, which
+		// mounts blobs instead of transferring them.
+		sameRegistry bool
+		index        bool
+		// existing is pushed to the destination beforehand, so its blobs
+		// are neither mounted nor uploaded.
+		existing   []string
+		wantMounts int
+		wantGets   int
+	}{
+		{name: "across registries", sameRegistry: false, wantMounts: 0, wantGets: 3},
+		{name: "same registry mounts blobs", sameRegistry: true, wantMounts: 3, wantGets: 0},
+		{name: "existing blobs are skipped", sameRegistry: false, existing: []string{"base"}, wantGets: 2},
+		{name: "index across registries", sameRegistry: false, index: true, wantGets: 5},
+		{name: "index on the same registry", sameRegistry: true, index: true, wantMounts: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := newTestRegistry(t, nil)
+			dst := src
+			if !tt.sameRegistry {
+				dst = newTestRegistry(t, nil)
+			}
+			c := NewRegistryClient()
+			srcNamed, dstNamed := src.Named(t, "staging/addon"), dst.Named(t, "release/addon")
+
+			var want Descriptor
+			children := make([]Descriptor, 0)
+			if tt.index {
+				// The base layer is shared by both platforms' images.
+				children = append(children,
+					pushTestImage(t, c, srcNamed, "", linuxAMD64, "base", "amd64"),
+					pushTestImage(t, c, srcNamed, "", Platform{OS: "linux", Architecture: "arm64"}, "base", "arm64"))
+				want = pushTestManifest(t, c, srcNamed, "v1", Manifest{
+					SchemaVersion: 2,
+					MediaType:     MediaTypeOCIIndex,
+					Manifests:     children,
+				})
+			} else {
+				want = pushTestImage(t, c, srcNamed, "v1", linuxAMD64, "base", "app")
+			}
+			if len(tt.existing) > 0 {
+				pushTestImage(t, c, dstNamed, "old", Platform{OS: "windows"}, tt.existing...)
+			}
+			src.ResetRequests()
+			dst.ResetRequests()
+
+			got, err := c.Copy(srcNamed, "v1", dstNamed, "v1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Digest != want.Digest {
+				t.Errorf("Copy() digest = %v, want %v", got.Digest, want.Digest)
+			}
+			if desc, err := c.HeadManifest(dstNamed, "v1"); err != nil || desc == nil || desc.Digest != want.Digest {
+				t.Errorf("destination v1 = %v, %v; want digest %v", desc, err, want.Digest)
+			}
+			for _, child := range children {
+				if desc, err := c.HeadManifest(dstNamed, child.Digest); err != nil || desc == nil {
+					t.Errorf("destination is missing child manifest %v: %v", child.Digest, err)
+				}
+			}
+			if mounts := dst.Requests(`^POST /v2/release/addon/blobs/uploads/\?from=staging%2Faddon&mount=`); len(mounts) != tt.wantMounts {
+				t.Errorf("Copy() mounted %v blobs, want %v: %v", len(mounts), tt.wantMounts, mounts)
+			}
+			if gets := src.Requests(`^GET /v2/staging/addon/blobs/`); len(gets) != tt.wantGets {
+				t.Errorf("Copy() downloaded %v blobs, want %v: %v", len(gets), tt.wantGets, gets)
+			}
+		})
+	}
+}
+
+func TestRegistryTag(t *testing.T) {
+	r := newTestRegistry(t, nil)
+	c := NewRegistryClient()
+	named := r.Named(t, "addon")
+	v1 := pushTestImage(t, c, named, "v1", linuxAMD64, "v1")
+
+	tests := []struct {
+		name    string
+		ref     string
+		newTag  string
+		wantErr bool
+	}{
+		{name: "by tag", ref: "v1", newTag: "v1-gke.1"},
+		{name: "by digest", ref: v1.Digest, newTag: "stable"},
+		{name: "retag existing", ref: "v1", newTag: "v1-gke.1"},
+		{name: "unknown tag", ref: "v2", newTag: "v2-gke.1", wantErr: true},
+		{name: "invalid tag", ref: "v1", newTag: "-bad", wantErr: true},
+		{name: "empty tag", ref: "v1", newTag: "", wantErr: true},
+		{name: "tag too long", ref: "v1", newTag: strings.Repeat("v", 129), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r.ResetRequests()
+			_, err := c.Tag(named, tt.ref, tt.newTag)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Tag(%v, %v) error = %v, want error: %v", tt.ref, tt.newTag, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				// Invalid tags fail before the manifest is fetched.
+				if tt.ref == "v1" && len(r.Requests(".")) > 0 {
+					t.Errorf("Tag(%v, %q) sent requests: %v", tt.ref, tt.newTag, r.Requests("."))
+				}
+				return
+			}
+			desc, err := c.HeadManifest(named, tt.newTag)
+			if err != nil || desc == nil || desc.Digest != v1.Digest {
+				t.Errorf("%v = %v, %v; want digest %v", tt.newTag, desc, err, v1.Digest)
+			}
+		})
+	}
+}
+
+func TestRegistryAppendTagSuffix(t *testing.T) {
+	tests := []struct {
+		name     string
+		tags     []string
+		regex    string
+		suffix   string
+		wantTags []string
+	}{
+		{
+			name:     "all tags",
+			tags:     []string{"v1", "v2"},
+			regex:    ".*",
+			suffix:   "gke.1",
+			wantTags: []string{"v1", "v1-gke.1", "v2", "v2-gke.1"},
+		},
+		{
+			name:     "regex",
+			tags:     []string{"v1", "v2"},
+			regex:    "^v1$",
+			suffix:   "gke.1",
+			wantTags: []string{"v1", "v1-gke.1", "v2"},
+		},
+		{
+			name:     "latest is skipped",
+			tags:     []string{"latest", "v1"},
+			regex:    ".*",
+			suffix:   "gke.1",
+			wantTags: []string{"latest", "v1", "v1-gke.1"},
+		},
+		{
+			name:     "suffixed tags are skipped",
+			tags:     []string{"v1", "v1-gke.1", "v2-gke.1"},
+			regex:    ".*",
+			suffix:   "gke.1",
+			wantTags: []string{"v1", "v1-gke.1", "v2-gke.1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRegistry(t, nil)
+			c := NewRegistryClient()
+			named := r.Named(t, "addon")
+			digests := make(map[string]string)
+			for _, tag := range tt.tags {
+				digests[tag] = pushTestImage(t, c, named, tag, linuxAMD64, tag).Digest
+			}
+
+			if err := c.AppendTagSuffix(named, regexp.MustCompile(tt.regex), tt.suffix); err != nil {
+				t.Fatal(err)
+			}
+			got, err := c.Tags(named)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.wantTags) {
+				t.Errorf("tags = %v, want %v", got, tt.wantTags)
+			}
+			// New tags point at the manifest of the tag they suffix.
+			for tag, digest := range digests {
+				newTag := tag + "-" + tt.suffix
+				if _, existed := digests[newTag]; existed {
+					continue
+				}
+				desc, err := c.HeadManifest(named, newTag)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if desc != nil && desc.Digest != digest {
+					t.Errorf("%v points at %v, want %v", newTag, desc.Digest, digest)
+				}
+			}
+		})
+	}
+}
+
+func TestRegistryServerPathTraversal(t *testing.T) {
+	root := t.TempDir()
+	victim := filepath.Join(root, "victim")
+	if err := ioutil.WriteFile(victim, []byte("victim"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewRegistryServer(filepath.Join(root, "registry"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// From the directory of tags or revisions of "addon", five levels up is
+	// root.
+	escape := "../../../../../victim"
+	tests := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodDelete, "/v2/addon/manifests/" + escape},
+		{http.MethodDelete, "/v2/addon/manifests/sha256:" + escape},
+		{http.MethodGet, "/v2/addon/manifests/" + escape},
+		{http.MethodPut, "/v2/addon/manifests/" + escape},
+		{http.MethodDelete, "/v2/addon/blobs/sha256:" + escape},
+		{http.MethodGet, "/v2/addon/blobs/sha256:" + escape},
+		{http.MethodPatch, "/v2/addon/blobs/uploads/" + escape},
+		{http.MethodGet, "/v2/addon/referrers/sha256:" + escape},
+		{http.MethodDelete, "/v2/../../victim/manifests/latest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader("{}")))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status = %v, want %v", w.Code, http.StatusBadRequest)
+			}
+			if _, err := os.Stat(victim); err != nil {
+				t.Fatalf("%v was removed: %v", victim, err)
+			}
+		})
+	}
+}
+
+// tokenAuth makes the registry use token authentication: requests need a
+// bearer token from its /token endpoint, which grants the scopes asked for to
+// user:password. Tokens are the scopes they grant, base64-encoded.
+func tokenAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "password" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			token := base64.StdEncoding.EncodeToString([]byte(strings.Join(r.URL.Query()["scope"], " ")))
+			json.NewEncoder(w).Encode(map[string]string{"token": token})
+			return
+		}
+		m := registryRouteRegex.FindStringSubmatch(r.URL.Path)
+		if m == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		needed := []string{"repository:" + m[1] + ":pull"}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			needed[0] += ",push"
+		}
+		if from := r.URL.Query().Get("from"); from != "" {
+			needed = append(needed, "repository:"+from+":pull")
+		}
+		granted, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		for _, scope := range needed {
+			if !strings.Contains(" "+string(granted)+" ", " "+scope+" ") {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="http://%v/token",service="test",scope="%v"`, r.Host, scope))
+				writeRegistryError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withoutMounts makes the registry ignore cross-repository mount requests
+// and open an upload session instead, as registries may do.
+func withoutMounts(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		q.Del("mount")
+		q.Del("from")
+		r.URL.RawQuery = q.Encode()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func TestRegistryTokenAuth(t *testing.T) {
+	tests := []struct {
+		name        string
+		wrap        func(http.Handler) http.Handler
+		wantUploads int
+	}{
+		{name: "mounts", wrap: tokenAuth},
+		{name: "mount falls back to an upload", wrap: func(h http.Handler) http.Handler { return tokenAuth(withoutMounts(h)) }, wantUploads: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRegistry(t, tt.wrap)
+			dockerConfig := t.TempDir()
+			t.Setenv("DOCKER_CONFIG", dockerConfig)
+			auth := base64.StdEncoding.EncodeToString([]byte("user:password"))
+			if err := ioutil.WriteFile(filepath.Join(dockerConfig, "config.json"), []byte(`{"auths": {"`+r.Host+`": {"auth": "`+auth+`"}}}`), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			c := NewRegistryClient()
+			src, dst := r.Named(t, "staging/addon"), r.Named(t, "release/addon")
+			want := pushTestImage(t, c, src, "v1", linuxAMD64, "app")
+			if tags, err := c.Tags(src); err != nil || !reflect.DeepEqual(tags, []string{"v1"}) {
+				t.Errorf("Tags() = %v, %v; want [v1]", tags, err)
+			}
+			r.ResetRequests()
+
+			got, err := c.Copy(src, "v1", dst, "v1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Digest != want.Digest {
+				t.Errorf("Copy() digest = %v, want %v", got.Digest, want.Digest)
+			}
+			if uploads := r.Requests(`^PUT /v2/release/addon/blobs/uploads/`); len(uploads) != tt.wantUploads {
+				t.Errorf("Copy() uploaded %v blobs, want %v: %v", len(uploads), tt.wantUploads, uploads)
+			}
+			if tokens := r.Requests(`^GET /token`); len(tokens) > 3 {
+				t.Errorf("Copy() requested %v tokens, want at most 3: %v", len(tokens), tokens)
+			}
+		})
+	}
+}
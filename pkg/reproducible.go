@@ -0,0 +1,126 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// ParseSourceDateEpoch parses a SOURCE_DATE_EPOCH value [1] (seconds since
+// the Unix epoch). An empty string means that timestamps should not be
+// pinned, in which case nil is returned.
+//
+// [1]: https://reproducible-builds.org/specs/source-date-epoch/
+func ParseSourceDateEpoch(epoch string) (*time.Time, error) {
+	if epoch == "" {
+		return nil, nil
+	}
+	seconds, err := strconv.ParseInt(epoch, 10, 64)
+	if err != nil || seconds < 0 {
+		return nil, fmt.Errorf("invalid source date epoch '%v' (must be a non-negative number of seconds)", epoch)
+	}
+	t := time.Unix(seconds, 0).UTC()
+	return &t, nil
+}
+
+// PinCreated rewrites the configs of the given images so that the image and
+// every history entry claim to have been created at the given time. Fields
+// that only identify the (throwaway) build container are dropped. The image
+// ID is the digest of the config, so two builds that produce the same layers
+// end up with bit-identical image IDs.
+func PinCreated(dcli *client.Client, repoTags []string, created time.Time) error {
+	archive, err := SaveImages(dcli, repoTags)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	if err := archive.PinCreated(created); err != nil {
+		return err
+	}
+	return archive.Load(dcli)
+}
+
+// PinCreated pins the creation time of every image in the archive; see the
+// package-level PinCreated function.
+func (a *ImageArchive) PinCreated(created time.Time) error {
+	for i, m := range a.Manifest {
+		raw, err := a.ReadConfig(m)
+		if err != nil {
+			return err
+		}
+		pinned, err := pinConfigCreated(raw, created)
+		if err != nil {
+			return fmt.Errorf("could not rewrite config of %v: %v", strings.Join(m.RepoTags, ", "), err)
+		}
+		name, err := a.writeConfig(pinned)
+		if err != nil {
+			return err
+		}
+		if name != m.Config {
+			fmt.Printf("pinned creation time of %v to %v\n", strings.Join(m.RepoTags, ", "), created.Format(time.RFC3339))
+		}
+		a.Manifest[i].Config = name
+	}
+	return nil
+}
+
+// writeConfig stores a config in the archive under its content address (as
+// "docker save" does) and returns the name of the file.
+func (a *ImageArchive) writeConfig(config []byte) (string, error) {
+	sum := sha256.Sum256(config)
+	name := hex.EncodeToString(sum[:]) + ".json"
+	if err := ioutil.WriteFile(a.Path(name), config, 0644); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+func pinConfigCreated(raw []byte, created time.Time) ([]byte, error) {
+	// Decode into a generic map so that fields we do not know about survive
+	// the round trip untouched.
+	var config map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, err
+	}
+
+	timestamp := created.UTC().Format(time.RFC3339Nano)
+	config["created"] = timestamp
+	if history, ok := config["history"].([]interface{}); ok {
+		for _, h := range history {
+			if entry, ok := h.(map[string]interface{}); ok {
+				entry["created"] = timestamp
+			}
+		}
+	}
+	// The legacy builder records the ID and config of the intermediate
+	// container that was committed into the image; both change every build.
+	delete(config, "container")
+	delete(config, "container_config")
+
+	return json.Marshal(config)
+}
@@ -0,0 +1,319 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"debug/buildinfo"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Package types, as used in package URLs [1].
+//
+// [1]: https://github.com/package-url/purl-spec
+const (
+	PackageTypeGolang = "golang"
+	PackageTypeDeb    = "deb"
+	PackageTypeApk    = "apk"
+)
+
+// Package is a piece of software found in an image.
+type Package struct {
+	Type    string
+	Name    string
+	Version string
+	// Arch is only known for OS packages.
+	Arch string
+	// License is only known for apk packages (dpkg does not record it).
+	License string
+	// Locations lists the files the package was found in.
+	Locations []string
+}
+
+// PURL returns the package URL of the package. distro qualifies OS packages
+// (e.g. "debian").
+func (p Package) PURL(distro string) string {
+	purl := "pkg:" + p.Type + "/"
+	switch p.Type {
+	case PackageTypeGolang:
+		purl += p.Name
+	default:
+		if distro != "" {
+			purl += url.PathEscape(distro) + "/"
+		}
+		purl += url.PathEscape(p.Name)
+	}
+	if p.Version != "" {
+		purl += "@" + url.PathEscape(p.Version)
+	}
+	if p.Arch != "" {
+		purl += "?arch=" + url.QueryEscape(p.Arch)
+	}
+	return purl
+}
+
+// ImageContents is the result of scanning an image for software.
+type ImageContents struct {
+	// Distro is the ID from /etc/os-release, if any (e.g. "debian").
+	Distro   string
+	Packages []Package
+}
+
+// Files inspected by ScanImage, other than executables.
+const (
+	dpkgStatusFile   = "/var/lib/dpkg/status"
+	dpkgStatusDir    = "/var/lib/dpkg/status.d/"
+	apkInstalledFile = "/lib/apk/db/installed"
+	osReleaseFile    = "/etc/os-release"
+	osReleaseFileAlt = "/usr/lib/os-release"
+)
+
+// ScanImage lists the software in an image of the archive: the modules
+// compiled into Go binaries (from the build info embedded by the Go
+// toolchain), and the packages recorded in the dpkg and apk databases.
+func (a *ImageArchive) ScanImage(m ArchiveManifest) (*ImageContents, error) {
+	fs, err := a.Filesystem(m)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := make([]LayerFile, 0)
+	for p, f := range fs {
+		if !f.Mode.IsRegular() || f.Size == 0 {
+			continue
+		}
+		if f.Mode&0111 != 0 ||
+			p == dpkgStatusFile || strings.HasPrefix(p, dpkgStatusDir) ||
+			p == apkInstalledFile || p == osReleaseFile || p == osReleaseFileAlt {
+			wanted = append(wanted, f)
+		}
+	}
+
+	contents := &ImageContents{}
+	packages := make(map[string]*Package)
+	add := func(p Package, location string) {
+		key := p.Type + " " + p.Name + " " + p.Version + " " + p.Arch
+		existing, ok := packages[key]
+		if !ok {
+			existing = &p
+			packages[key] = existing
+		}
+		existing.Locations = append(existing.Locations, location)
+	}
+
+	err = a.ReadFiles(m, wanted, func(f LayerFile, r io.Reader) error {
+		switch {
+		case f.Path == dpkgStatusFile || strings.HasPrefix(f.Path, dpkgStatusDir):
+			pkgs, err := parseDpkgStatus(r)
+			if err != nil {
+				return fmt.Errorf("%v: %v", f.Path, err)
+			}
+			for _, p := range pkgs {
+				add(p, f.Path)
+			}
+		case f.Path == apkInstalledFile:
+			pkgs, err := parseApkInstalled(r)
+			if err != nil {
+				return fmt.Errorf("%v: %v", f.Path, err)
+			}
+			for _, p := range pkgs {
+				add(p, f.Path)
+			}
+		case f.Path == osReleaseFile || f.Path == osReleaseFileAlt:
+			if id := parseOSReleaseID(r); id != "" && (contents.Distro == "" || f.Path == osReleaseFile) {
+				contents.Distro = id
+			}
+		default:
+			for _, p := range goBinaryPackages(r) {
+				add(p, f.Path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range packages {
+		sort.Strings(p.Locations)
+		contents.Packages = append(contents.Packages, *p)
+	}
+	sort.Slice(contents.Packages, func(i, j int) bool {
+		a, b := contents.Packages[i], contents.Packages[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Version < b.Version
+	})
+	return contents, nil
+}
+
+// goBinaryPackages returns the Go modules (including the standard library)
+// that went into a binary, or nothing if it is not a Go binary.
+func goBinaryPackages(r io.Reader) []Package {
+	// Cheaply rule out anything that is not an executable before reading
+	// the whole file.
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil || !isExecutableMagic(magic) {
+		return nil
+	}
+	content, err := ioutil.ReadAll(br)
+	if err != nil {
+		return nil
+	}
+	info, err := buildinfo.Read(bytes.NewReader(content))
+	if err != nil {
+		return nil
+	}
+
+	pkgs := []Package{{Type: PackageTypeGolang, Name: "stdlib", Version: info.GoVersion}}
+	if info.Main.Path != "" {
+		pkgs = append(pkgs, Package{Type: PackageTypeGolang, Name: info.Main.Path, Version: info.Main.Version})
+	}
+	for _, dep := range info.Deps {
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		pkgs = append(pkgs, Package{Type: PackageTypeGolang, Name: dep.Path, Version: dep.Version})
+	}
+	return pkgs
+}
+
+// isExecutableMagic recognizes ELF, Mach-O and PE binaries.
+func isExecutableMagic(magic []byte) bool {
+	switch {
+	case bytes.Equal(magic, []byte("\x7fELF")):
+		return true
+	case bytes.Equal(magic[:2], []byte("MZ")):
+		return true
+	case bytes.Equal(magic, []byte{0xcf, 0xfa, 0xed, 0xfe}), bytes.Equal(magic, []byte{0xce, 0xfa, 0xed, 0xfe}):
+		return true
+	}
+	return false
+}
+
+// parseDpkgStatus parses a dpkg status file, which is made of RFC 822-style
+// paragraphs, one per package. Packages that are not fully installed are
+// skipped.
+func parseDpkgStatus(r io.Reader) ([]Package, error) {
+	pkgs := make([]Package, 0)
+	paragraphs, err := parseParagraphs(r, ":")
+	if err != nil {
+		return nil, err
+	}
+	for _, fields := range paragraphs {
+		if fields["Package"] == "" {
+			continue
+		}
+		// Distroless images ship one status file per package without a
+		// Status field.
+		if status, ok := fields["Status"]; ok && !strings.HasSuffix(status, " installed") {
+			continue
+		}
+		pkgs = append(pkgs, Package{
+			Type:    PackageTypeDeb,
+			Name:    fields["Package"],
+			Version: fields["Version"],
+			Arch:    fields["Architecture"],
+		})
+	}
+	return pkgs, nil
+}
+
+// parseApkInstalled parses the apk database [1], whose paragraphs are made of
+// single-letter fields.
+//
+// [1]: https://wiki.alpinelinux.org/wiki/Apk_spec
+func parseApkInstalled(r io.Reader) ([]Package, error) {
+	pkgs := make([]Package, 0)
+	paragraphs, err := parseParagraphs(r, ":")
+	if err != nil {
+		return nil, err
+	}
+	for _, fields := range paragraphs {
+		if fields["P"] == "" {
+			continue
+		}
+		pkgs = append(pkgs, Package{
+			Type:    PackageTypeApk,
+			Name:    fields["P"],
+			Version: fields["V"],
+			Arch:    fields["A"],
+			License: fields["L"],
+		})
+	}
+	return pkgs, nil
+}
+
+// parseParagraphs splits a file into blank-line separated paragraphs of
+// "key<sep>value" lines. Continuation lines (starting with whitespace) are
+// ignored, as is any repeated key after the first. Lines can be of any length.
+func parseParagraphs(r io.Reader, sep string) ([]map[string]string, error) {
+	paragraphs := make([]map[string]string, 0)
+	current := make(map[string]string)
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF && line == "" {
+			break
+		} else if err != nil && err != io.EOF {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if strings.TrimSpace(line) == "" {
+			if len(current) > 0 {
+				paragraphs = append(paragraphs, current)
+				current = make(map[string]string)
+			}
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		kv := strings.SplitN(line, sep, 2)
+		if len(kv) != 2 {
+			continue
+		}
+		if _, ok := current[kv[0]]; !ok {
+			current[kv[0]] = strings.TrimSpace(kv[1])
+		}
+	}
+	if len(current) > 0 {
+		paragraphs = append(paragraphs, current)
+	}
+	return paragraphs, nil
+}
+
+// parseOSReleaseID returns the ID field of an os-release file.
+func parseOSReleaseID(r io.Reader) string {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "ID=") {
+			return strings.Trim(strings.TrimPrefix(line, "ID="), `"'`)
+		}
+	}
+	return ""
+}
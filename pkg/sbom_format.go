@@ -0,0 +1,237 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// SBOMInfo describes the image an SBOM is about and how the SBOM was made.
+type SBOMInfo struct {
+	ImageName string
+	// ImageID is the digest of the image config.
+	ImageID string
+	// Tool names the program that produced the SBOM, e.g. "ply-v1.2.3".
+	Tool    string
+	Created time.Time
+}
+
+// documentID derives a stable identifier for an SBOM from the image and the
+// time it was made, so regenerating an SBOM with a pinned creation time gives
+// a bit-identical document.
+func (info SBOMInfo) documentID() string {
+	sum := sha256.Sum256([]byte(info.ImageName + "\x00" + info.ImageID + "\x00" + info.Created.UTC().Format(time.RFC3339)))
+	// Format as a (name-based, version 5 style) UUID.
+	sum[6] = (sum[6] & 0x0f) | 0x50
+	sum[8] = (sum[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+var spdxIDRegex = regexp.MustCompile(`[^a-zA-Z0-9.-]+`)
+
+// spdxLicenseRegex loosely matches SPDX license expressions. apk records
+// licenses as such, but not always valid ones.
+var spdxLicenseRegex = regexp.MustCompile(`^[a-zA-Z0-9.+-]+( (AND|OR|WITH) [a-zA-Z0-9.+-]+)*$`)
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	SPDXID           string            `json:"SPDXID"`
+	Name             string            `json:"name"`
+	VersionInfo      string            `json:"versionInfo,omitempty"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	LicenseConcluded string            `json:"licenseConcluded"`
+	LicenseDeclared  string            `json:"licenseDeclared"`
+	CopyrightText    string            `json:"copyrightText"`
+	SourceInfo       string            `json:"sourceInfo,omitempty"`
+	PrimaryPurpose   string            `json:"primaryPackagePurpose,omitempty"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// SPDXDocument renders an SBOM as an SPDX 2.3 JSON document [1]. The image is
+// the package the document describes; everything found in it is related to
+// it with CONTAINS.
+//
+// [1]: https://spdx.github.io/spdx-spec/v2.3/
+func SPDXDocument(info SBOMInfo, contents *ImageContents) ([]byte, error) {
+	doc := spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              info.ImageName,
+		DocumentNamespace: "https://github.com/GoogleCloudPlatform/k8s-addon-builder/spdx/" + info.documentID(),
+		CreationInfo: spdxCreationInfo{
+			Created:  info.Created.UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: " + info.Tool},
+		},
+	}
+
+	imageID := "SPDXRef-Image"
+	doc.Packages = append(doc.Packages, spdxPackage{
+		SPDXID:           imageID,
+		Name:             info.ImageName,
+		VersionInfo:      info.ImageID,
+		DownloadLocation: "NOASSERTION",
+		LicenseConcluded: "NOASSERTION",
+		LicenseDeclared:  "NOASSERTION",
+		CopyrightText:    "NOASSERTION",
+		PrimaryPurpose:   "CONTAINER",
+	})
+	doc.Relationships = append(doc.Relationships, spdxRelationship{"SPDXRef-DOCUMENT", "DESCRIBES", imageID})
+
+	for i, p := range contents.Packages {
+		id := fmt.Sprintf("SPDXRef-Package-%v-%v-%v", p.Type, spdxIDRegex.ReplaceAllString(p.Name, "-"), i)
+		license := "NOASSERTION"
+		if spdxLicenseRegex.MatchString(p.License) {
+			license = p.License
+		}
+		doc.Packages = append(doc.Packages, spdxPackage{
+			SPDXID:           id,
+			Name:             p.Name,
+			VersionInfo:      p.Version,
+			DownloadLocation: "NOASSERTION",
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  license,
+			CopyrightText:    "NOASSERTION",
+			SourceInfo:       "found in " + strings.Join(p.Locations, ", "),
+			ExternalRefs: []spdxExternalRef{{
+				ReferenceCategory: "PACKAGE-MANAGER",
+				ReferenceType:     "purl",
+				ReferenceLocator:  p.PURL(contents.Distro),
+			}},
+		})
+		doc.Relationships = append(doc.Relationships, spdxRelationship{imageID, "CONTAINS", id})
+	}
+
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+type cycloneDXDocument struct {
+	BOMFormat    string               `json:"bomFormat"`
+	SpecVersion  string               `json:"specVersion"`
+	SerialNumber string               `json:"serialNumber"`
+	Version      int                  `json:"version"`
+	Metadata     cycloneDXMetadata    `json:"metadata"`
+	Components   []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXMetadata struct {
+	Timestamp string             `json:"timestamp"`
+	Tools     []cycloneDXTool    `json:"tools"`
+	Component cycloneDXComponent `json:"component"`
+}
+
+type cycloneDXTool struct {
+	Name string `json:"name"`
+}
+
+type cycloneDXComponent struct {
+	BOMRef     string              `json:"bom-ref,omitempty"`
+	Type       string              `json:"type"`
+	Name       string              `json:"name"`
+	Version    string              `json:"version,omitempty"`
+	PURL       string              `json:"purl,omitempty"`
+	Licenses   []cycloneDXLicense  `json:"licenses,omitempty"`
+	Properties []cycloneDXProperty `json:"properties,omitempty"`
+}
+
+type cycloneDXLicense struct {
+	Expression string `json:"expression"`
+}
+
+type cycloneDXProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// CycloneDXDocument renders an SBOM as a CycloneDX 1.4 JSON document [1].
+//
+// [1]: https://cyclonedx.org/docs/1.4/json/
+func CycloneDXDocument(info SBOMInfo, contents *ImageContents) ([]byte, error) {
+	doc := cycloneDXDocument{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.4",
+		SerialNumber: "urn:uuid:" + info.documentID(),
+		Version:      1,
+		Metadata: cycloneDXMetadata{
+			Timestamp: info.Created.UTC().Format(time.RFC3339),
+			Tools:     []cycloneDXTool{{Name: info.Tool}},
+			Component: cycloneDXComponent{
+				BOMRef:  info.ImageID,
+				Type:    "container",
+				Name:    info.ImageName,
+				Version: info.ImageID,
+			},
+		},
+		Components: make([]cycloneDXComponent, 0),
+	}
+
+	seen := make(map[string]bool)
+	for _, p := range contents.Packages {
+		purl := p.PURL(contents.Distro)
+		component := cycloneDXComponent{
+			Type:    "library",
+			Name:    p.Name,
+			Version: p.Version,
+			PURL:    purl,
+		}
+		// bom-refs must be unique.
+		if !seen[purl] {
+			component.BOMRef = purl
+			seen[purl] = true
+		}
+		if spdxLicenseRegex.MatchString(p.License) {
+			component.Licenses = []cycloneDXLicense{{Expression: p.License}}
+		}
+		for _, location := range p.Locations {
+			component.Properties = append(component.Properties, cycloneDXProperty{"ply:location", location})
+		}
+		doc.Components = append(doc.Components, component)
+	}
+
+	return json.MarshalIndent(doc, "", "  ")
+}
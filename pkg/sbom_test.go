@@ -0,0 +1,203 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// longLine is longer than bufio.Scanner lines can be, even with a larger
+// buffer.
+var longLine = " " + strings.Repeat("x", 2*1024*1024) + "\n"
+
+func TestParseDpkgStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		status string
+		want   []Package
+	}{
+		{
+			name: "status file",
+			status: `Package: base-files
+Status: install ok installed
+Architecture: amd64
+Version: 12.4+deb12u5
+Description: Debian base system miscellaneous files
+ This package contains the basic filesystem hierarchy.
+Conffiles:` + longLine + `
+
+Package: removed
+Status: deinstall ok config-files
+Version: 1.0
+
+Package: libc6
+Status: install ok installed
+Architecture: amd64
+Version: 2.36-9+deb12u4
+Description: GNU C Library: Shared libraries
+`,
+			want: []Package{
+				{Type: PackageTypeDeb, Name: "base-files", Version: "12.4+deb12u5", Arch: "amd64"},
+				{Type: PackageTypeDeb, Name: "libc6", Version: "2.36-9+deb12u4", Arch: "amd64"},
+			},
+		},
+		{
+			name:   "distroless status.d file",
+			status: "Package: tzdata\r\nVersion: 2024a-0+deb12u1\r\nArchitecture: all\r\n",
+			want: []Package{
+				{Type: PackageTypeDeb, Name: "tzdata", Version: "2024a-0+deb12u1", Arch: "all"},
+			},
+		},
+		{
+			name:   "empty",
+			status: "\n\n",
+			want:   []Package{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDpkgStatus(strings.NewReader(tt.status))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDpkgStatus() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseApkInstalled(t *testing.T) {
+	installed := `C:Q1abc=
+P:musl
+V:1.2.4-r2
+A:x86_64
+L:MIT
+T:the musl c library (libc) implementation
+
+C:Q1def=
+P:busybox
+V:1.36.1-r15
+A:x86_64
+L:GPL-2.0-only
+F:bin
+R:` + longLine[1:] + `
+`
+	got, err := parseApkInstalled(strings.NewReader(installed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Package{
+		{Type: PackageTypeApk, Name: "musl", Version: "1.2.4-r2", Arch: "x86_64", License: "MIT"},
+		{Type: PackageTypeApk, Name: "busybox", Version: "1.36.1-r15", Arch: "x86_64", License: "GPL-2.0-only"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseApkInstalled() = %+v, want %+v", got, want)
+	}
+}
+
+// failingReader returns its content, then an error.
+type failingReader struct {
+	r io.Reader
+}
+
+func (f failingReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		return n, errors.New("read failed")
+	}
+	return n, err
+}
+
+func TestParseParagraphsError(t *testing.T) {
+	_, err := parseDpkgStatus(failingReader{strings.NewReader("Package: a\n\nPackage: b\n")})
+	if err == nil {
+		t.Error("parseDpkgStatus() of a failing reader succeeded")
+	}
+}
+
+func TestPackagePURL(t *testing.T) {
+	tests := []struct {
+		pkg    Package
+		distro string
+		want   string
+	}{
+		{Package{Type: PackageTypeGolang, Name: "github.com/spf13/cobra", Version: "v1.8.0"}, "debian", "pkg:golang/github.com/spf13/cobra@v1.8.0"},
+		{Package{Type: PackageTypeDeb, Name: "libc6", Version: "2.36-9+deb12u4", Arch: "amd64"}, "debian", "pkg:deb/debian/libc6@2.36-9+deb12u4?arch=amd64"},
+		{Package{Type: PackageTypeApk, Name: "musl", Version: "1.2.4-r2"}, "", "pkg:apk/musl@1.2.4-r2"},
+	}
+	for _, tt := range tests {
+		if got := tt.pkg.PURL(tt.distro); got != tt.want {
+			t.Errorf("%v.PURL(%q) = %v, want %v", tt.pkg.Name, tt.distro, got, tt.want)
+		}
+	}
+}
+
+func TestSBOMDocuments(t *testing.T) {
+	info := SBOMInfo{
+		ImageName: "gcr.io/project/addon:v1",
+		ImageID:   "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+		Tool:      "ply-test",
+		Created:   time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	contents := &ImageContents{
+		Distro: "alpine",
+		Packages: []Package{
+			{Type: PackageTypeApk, Name: "busybox", Version: "1.36.1-r15", Arch: "x86_64", License: "GPL-2.0-only", Locations: []string{"/lib/apk/db/installed"}},
+			{Type: PackageTypeApk, Name: "ssl_client", Version: "1.36.1-r15", Arch: "x86_64", License: "GPL-2.0-only and custom", Locations: []string{"/lib/apk/db/installed"}},
+			{Type: PackageTypeGolang, Name: "stdlib", Version: "go1.21.5", Locations: []string{"/bin/addon", "/bin/helper"}},
+			{Type: PackageTypeGolang, Name: "stdlib", Version: "go1.21.5", Locations: []string{"/bin/other"}},
+		},
+	}
+	tests := []struct {
+		golden string
+		render func(SBOMInfo, *ImageContents) ([]byte, error)
+	}{
+		{"sbom.spdx.json", SPDXDocument},
+		{"sbom.cyclonedx.json", CycloneDXDocument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			got, err := tt.render(info, contents)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join("testdata", tt.golden)
+			if *updateGolden {
+				if err := ioutil.WriteFile(path, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%v differs from %v (run the tests with -update to rewrite it):\n%s", tt.golden, path, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,334 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+)
+
+// Signatures are stored the way cosign [1] stores them, so that either tool
+// can verify what the other signed: a "simple signing" payload naming the
+// image digest is signed, and the payload goes in a layer of an image tagged
+// SignatureTag(digest), with the signature in an annotation of the layer.
+//
+// [1]: https://github.com/sigstore/cosign/blob/main/specs/SIGNATURE_SPEC.md
+const (
+	MediaTypeSimpleSigning    = "application/vnd.dev.cosign.simplesigning.v1+json"
+	AnnotationCosignSignature = "dev.cosignproject.cosign/signature"
+	simpleSigningType         = "cosign container image signature"
+)
+
+// Signature is a signed simple signing payload.
+type Signature struct {
+	Payload   []byte
+	Signature []byte
+}
+
+type simpleSigningPayload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]interface{} `json:"optional"`
+}
+
+// SignatureTag returns the tag under which the signatures of a manifest are
+// stored: "sha256-<hex>.sig".
+func SignatureTag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1) + ".sig"
+}
+
+// LoadPrivateKey reads an ECDSA or ed25519 private key from a PEM file
+// (PKCS #8, or SEC 1 for ECDSA). Encrypted keys are not supported.
+func LoadPrivateKey(path string) (crypto.Signer, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	var key interface{}
+	if block.Type == "EC PRIVATE KEY" {
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	switch key := key.(type) {
+	case *ecdsa.PrivateKey:
+		return key, nil
+	case ed25519.PrivateKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("%v: unsupported key type %T (must be ECDSA or ed25519)", path, key)
+	}
+}
+
+// LoadPublicKey reads an ECDSA or ed25519 public key from a PEM file.
+func LoadPublicKey(path string) (crypto.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("%v: unsupported key type %T (must be ECDSA or ed25519)", path, key)
+	}
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("%v: no PEM data found", path)
+	}
+	if strings.Contains(block.Type, "ENCRYPTED") {
+		return nil, fmt.Errorf("%v: encrypted keys are not supported", path)
+	}
+	return block, nil
+}
+
+// SignImage signs the manifest digest of an image with a private key.
+func SignImage(key crypto.Signer, named reference.Named, digest string) (Signature, error) {
+	var payload simpleSigningPayload
+	payload.Critical.Identity.DockerReference = named.Name()
+	payload.Critical.Image.DockerManifestDigest = digest
+	payload.Critical.Type = simpleSigningType
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return Signature{}, err
+	}
+
+	var sig []byte
+	switch key.(type) {
+	case ed25519.PrivateKey:
+		// ed25519 signs messages, not digests.
+		sig, err = key.Sign(rand.Reader, raw, crypto.Hash(0))
+	default:
+		sum := sha256.Sum256(raw)
+		sig, err = key.Sign(rand.Reader, sum[:], crypto.SHA256)
+	}
+	if err != nil {
+		return Signature{}, err
+	}
+	return Signature{Payload: raw, Signature: sig}, nil
+}
+
+// Verify checks that a signature was made with a public key over a payload
+// that names the given repository and manifest digest. A signature of the
+// same digest in another repository does not count.
+func (s Signature) Verify(pub crypto.PublicKey, named reference.Named, digest string) error {
+	switch pub := pub.(type) {
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, s.Payload, s.Signature) {
+			return fmt.Errorf("invalid signature")
+		}
+	case *ecdsa.PublicKey:
+		sum := sha256.Sum256(s.Payload)
+		if !ecdsa.VerifyASN1(pub, sum[:], s.Signature) {
+			return fmt.Errorf("invalid signature")
+		}
+	default:
+		return fmt.Errorf("unsupported key type %T", pub)
+	}
+
+	var payload simpleSigningPayload
+	if err := json.Unmarshal(s.Payload, &payload); err != nil {
+		return fmt.Errorf("invalid payload: %v", err)
+	}
+	if payload.Critical.Type != simpleSigningType {
+		return fmt.Errorf("unexpected payload type %q", payload.Critical.Type)
+	}
+	if payload.Critical.Image.DockerManifestDigest != digest {
+		return fmt.Errorf("signature is for %v", payload.Critical.Image.DockerManifestDigest)
+	}
+	if signed := payload.Critical.Identity.DockerReference; signed != named.Name() {
+		return fmt.Errorf("signature is for %v", signed)
+	}
+	return nil
+}
+
+// PushSignature adds a signature to the signature image of a manifest,
+// creating it if needed. Signatures already present are not added again.
+func (c *RegistryClient) PushSignature(named reference.Named, digest string, sig Signature) error {
+	tag := SignatureTag(digest)
+	layers := make([]Descriptor, 0)
+	existing, err := c.HeadManifest(named, tag)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		raw, desc, err := c.GetManifest(named, tag)
+		if err != nil {
+			return err
+		}
+		m, err := ParseManifest(raw, desc.MediaType)
+		if err != nil {
+			return err
+		}
+		layers = m.Layers
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(sig.Signature)
+	layer, err := c.PushBlob(named, MediaTypeSimpleSigning, sig.Payload)
+	if err != nil {
+		return err
+	}
+	for _, l := range layers {
+		if l.Digest == layer.Digest && l.Annotations[AnnotationCosignSignature] == encoded {
+			return nil
+		}
+	}
+	layer.Annotations = map[string]string{AnnotationCosignSignature: encoded}
+	layers = append(layers, layer)
+
+	// The config is what cosign writes: an image config listing the
+	// layers.
+	diffIDs := make([]string, 0)
+	for _, l := range layers {
+		diffIDs = append(diffIDs, l.Digest)
+	}
+	configRaw, err := json.Marshal(map[string]interface{}{
+		"architecture": "",
+		"os":           "",
+		"created":      "0001-01-01T00:00:00Z",
+		"config":       map[string]interface{}{},
+		"rootfs":       map[string]interface{}{"type": "layers", "diff_ids": diffIDs},
+	})
+	if err != nil {
+		return err
+	}
+	config, err := c.PushBlob(named, MediaTypeOCIConfig, configRaw)
+	if err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeOCIManifest,
+		Config:        &config,
+		Layers:        layers,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.PutManifest(named, tag, MediaTypeOCIManifest, raw)
+	return err
+}
+
+// Signatures returns the signatures of a manifest stored in its repository.
+func (c *RegistryClient) Signatures(named reference.Named, digest string) ([]Signature, error) {
+	tag := SignatureTag(digest)
+	existing, err := c.HeadManifest(named, tag)
+	if err != nil || existing == nil {
+		return nil, err
+	}
+	raw, desc, err := c.GetManifest(named, tag)
+	if err != nil {
+		return nil, err
+	}
+	m, err := ParseManifest(raw, desc.MediaType)
+	if err != nil {
+		return nil, err
+	}
+
+	sigs := make([]Signature, 0)
+	for _, l := range m.Layers {
+		encoded, ok := l.Annotations[AnnotationCosignSignature]
+		if l.MediaType != MediaTypeSimpleSigning || !ok {
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("layer %v of %v: %v", l.Digest, ImageString(named, tag), err)
+		}
+		payload, err := c.ReadBlob(named, l.Digest)
+		if err != nil {
+			return nil, err
+		}
+		sigs = append(sigs, Signature{Payload: payload, Signature: sig})
+	}
+	return sigs, nil
+}
+
+// signatureFile returns the path (without extension) of the local signature
+// files of a manifest.
+func signatureFile(dir string, named reference.Named, digest string) string {
+	return filepath.Join(dir, SafeFileName(named.Name())+"@"+strings.TrimSuffix(SignatureTag(digest), ".sig"))
+}
+
+// WriteSignatureFiles stores a signature in a directory instead of a registry,
+// as a ".payload" file and a ".sig" file holding the base64-encoded signature
+// (like cosign's --output-payload and --output-signature).
+func WriteSignatureFiles(dir string, named reference.Named, digest string, sig Signature) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	path := signatureFile(dir, named, digest)
+	if err := ioutil.WriteFile(path+".payload", sig.Payload, 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path+".sig", []byte(base64.StdEncoding.EncodeToString(sig.Signature)), 0644)
+}
+
+// ReadSignatureFiles reads a signature written by WriteSignatureFiles. It
+// returns no signature if there are no files for the manifest.
+func ReadSignatureFiles(dir string, named reference.Named, digest string) ([]Signature, error) {
+	path := signatureFile(dir, named, digest)
+	encoded, err := ioutil.ReadFile(path + ".sig")
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		return nil, fmt.Errorf("%v.sig: %v", path, err)
+	}
+	payload, err := ioutil.ReadFile(path + ".payload")
+	if err != nil {
+		return nil, err
+	}
+	return []Signature{{Payload: payload, Signature: sig}}, nil
+}
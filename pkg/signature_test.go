@@ -0,0 +1,184 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"reflect"
+	"testing"
+
+	"github.com/docker/distribution/reference"
+)
+
+func testKeys(t *testing.T) map[string]crypto.Signer {
+	t.Helper()
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]crypto.Signer{"ecdsa": ecKey, "ed25519": edKey}
+}
+
+func TestSignatureVerify(t *testing.T) {
+	const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	const otherDigest = "sha256:fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+	keys := testKeys(t)
+	named, err := reference.ParseNormalizedNamed("gcr.io/release/addon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	staging, err := reference.ParseNormalizedNamed("gcr.io/staging/addon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		// key signs, verifyKey verifies.
+		key, verifyKey string
+		signed         reference.Named
+		verified       reference.Named
+		verifiedDigest string
+		tamper         bool
+		wantErr        bool
+	}{
+		{name: "ecdsa", key: "ecdsa", verifyKey: "ecdsa", signed: named, verified: named, verifiedDigest: digest},
+		{name: "ed25519", key: "ed25519", verifyKey: "ed25519", signed: named, verified: named, verifiedDigest: digest},
+		{name: "other key", key: "ecdsa", verifyKey: "ed25519", signed: named, verified: named, verifiedDigest: digest, wantErr: true},
+		{name: "other digest", key: "ecdsa", verifyKey: "ecdsa", signed: named, verified: named, verifiedDigest: otherDigest, wantErr: true},
+		{name: "other repository", key: "ecdsa", verifyKey: "ecdsa", signed: staging, verified: named, verifiedDigest: digest, wantErr: true},
+		{name: "tampered payload", key: "ed25519", verifyKey: "ed25519", signed: named, verified: named, verifiedDigest: digest, tamper: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sig, err := SignImage(keys[tt.key], tt.signed, digest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.tamper {
+				sig.Payload = append(sig.Payload, ' ')
+			}
+			err = sig.Verify(keys[tt.verifyKey].Public(), tt.verified, tt.verifiedDigest)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Verify() error = %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSignatureStorage(t *testing.T) {
+	keys := testKeys(t)
+	tests := []struct {
+		name  string
+		store func(c *RegistryClient, dir string, named reference.Named, digest string, sig Signature) error
+		load  func(c *RegistryClient, dir string, named reference.Named, digest string) ([]Signature, error)
+		// Signing with ecdsa, ed25519 then ecdsa again (each ecdsa signature
+		// stored twice) leaves want signatures, verified by wantKeys.
+		want     int
+		wantKeys []string
+	}{
+		{
+			name: "registry",
+			store: func(c *RegistryClient, dir string, named reference.Named, digest string, sig Signature) error {
+				return c.PushSignature(named, digest, sig)
+			},
+			load: func(c *RegistryClient, dir string, named reference.Named, digest string) ([]Signature, error) {
+				return c.Signatures(named, digest)
+			},
+			// ecdsa signatures are randomized, so the second one is new.
+			want:     3,
+			wantKeys: []string{"ecdsa", "ed25519"},
+		},
+		{
+			name: "files",
+			store: func(c *RegistryClient, dir string, named reference.Named, digest string, sig Signature) error {
+				return WriteSignatureFiles(dir, named, digest, sig)
+			},
+			load: func(c *RegistryClient, dir string, named reference.Named, digest string) ([]Signature, error) {
+				return ReadSignatureFiles(dir, named, digest)
+			},
+			// The files hold the last signature written.
+			want:     1,
+			wantKeys: []string{"ecdsa"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			r := newTestRegistry(t, nil)
+			c := NewRegistryClient()
+			named := r.Named(t, "addon")
+			digest := pushTestImage(t, c, named, "v1", linuxAMD64, "layer").Digest
+
+			sigs, err := tt.load(c, dir, named, digest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sigs) != 0 {
+				t.Fatalf("%v signatures before signing, want none", len(sigs))
+			}
+			for _, key := range []string{"ecdsa", "ed25519", "ecdsa"} {
+				sig, err := SignImage(keys[key], named, digest)
+				if err != nil {
+					t.Fatal(err)
+				}
+				times := 1
+				if key == "ecdsa" {
+					times = 2
+				}
+				for i := 0; i < times; i++ {
+					if err := tt.store(c, dir, named, digest, sig); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			if sigs, err = tt.load(c, dir, named, digest); err != nil {
+				t.Fatal(err)
+			}
+			if len(sigs) != tt.want {
+				t.Errorf("%v signatures, want %v", len(sigs), tt.want)
+			}
+			verified := make([]string, 0)
+			for _, name := range []string{"ecdsa", "ed25519"} {
+				for _, sig := range sigs {
+					if sig.Verify(keys[name].Public(), named, digest) == nil {
+						verified = append(verified, name)
+						break
+					}
+				}
+			}
+			if !reflect.DeepEqual(verified, tt.wantKeys) {
+				t.Errorf("signatures verified by %v, want %v", verified, tt.wantKeys)
+			}
+			// Signatures of a repository do not carry over to another.
+			other := r.Named(t, "other")
+			for _, sig := range sigs {
+				for name, key := range keys {
+					if sig.Verify(key.Public(), other, digest) == nil {
+						t.Errorf("signature by %v verified for %v", name, other.Name())
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/ulikunitz/xz"
+)
+
+// SourceBundlePath is where source bundles go in images, which is where
+// extract_source.sh looks for them.
+const SourceBundlePath = "/source.tar.xz"
+
+// sourceFile is an entry of a source bundle.
+type sourceFile struct {
+	path     string
+	mode     int64
+	size     int64
+	linkname string
+	open     func() (io.ReadCloser, error)
+}
+
+// WriteSourceBundle writes an xz-compressed tarball of the tree committed at
+// HEAD of the git repository in repoDir. Files matching .gitignore patterns
+// are left out even if they were committed, but vendored modules (vendor/ with
+// a modules.txt) are taken from the working tree whether or not they are
+// committed. The tarball is deterministic: entries are sorted, owned by root
+// and all have the given modification time.
+func WriteSourceBundle(w io.Writer, repoDir string, modTime time.Time) error {
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		return fmt.Errorf("could not open git repository %v: %v", repoDir, err)
+	}
+	commit, err := headCommit(repo)
+	if err != nil {
+		return err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+
+	var matcher gitignore.Matcher
+	if worktree, err := repo.Worktree(); err == nil {
+		patterns, err := gitignore.ReadPatterns(worktree.Filesystem, nil)
+		if err != nil {
+			return err
+		}
+		matcher = gitignore.NewMatcher(patterns)
+	}
+
+	files := make(map[string]sourceFile)
+	err = tree.Files().ForEach(func(f *object.File) error {
+		if matcher != nil && matcher.Match(strings.Split(f.Name, "/"), false) {
+			return nil
+		}
+		sf := sourceFile{path: f.Name, mode: 0644, size: f.Size}
+		switch f.Mode {
+		case filemode.Executable:
+			sf.mode = 0755
+		case filemode.Symlink:
+			target, err := f.Contents()
+			if err != nil {
+				return err
+			}
+			sf.mode, sf.size, sf.linkname = 0777, 0, target
+		}
+		sf.open = f.Blob.Reader
+		files[f.Name] = sf
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := addVendoredFiles(files, repoDir); err != nil {
+		return err
+	}
+
+	paths := make([]string, 0)
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	xw, err := xz.NewWriter(w)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(xw)
+	for _, p := range paths {
+		f := files[p]
+		hdr := &tar.Header{
+			Name:     f.path,
+			Mode:     f.mode,
+			Size:     f.size,
+			ModTime:  modTime,
+			Typeflag: tar.TypeReg,
+			Format:   tar.FormatPAX,
+		}
+		if f.linkname != "" {
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = f.linkname
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		r, err := f.open()
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, r)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("%v: %v", p, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return xw.Close()
+}
+
+// addVendoredFiles adds the files under vendor/ in the working tree, which is
+// often ignored rather than committed.
+func addVendoredFiles(files map[string]sourceFile, repoDir string) error {
+	vendorDir := filepath.Join(repoDir, "vendor")
+	if _, err := os.Stat(filepath.Join(vendorDir, "modules.txt")); os.IsNotExist(err) {
+		return nil
+	}
+	return filepath.Walk(vendorDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(repoDir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		sf := sourceFile{path: rel, mode: 0644, size: info.Size()}
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			sf.mode, sf.size, sf.linkname = 0777, 0, target
+		case info.Mode().IsRegular():
+			if info.Mode()&0111 != 0 {
+				sf.mode = 0755
+			}
+			sf.open = func() (io.ReadCloser, error) {
+				return os.Open(p)
+			}
+		default:
+			return nil
+		}
+		files[rel] = sf
+		return nil
+	})
+}